@@ -0,0 +1,84 @@
+package aoiservice
+
+import (
+	"aoi"
+	"aoi/base/linemath"
+)
+
+// _Object 服务内部持有的对象, 只在AOI协程中读写
+type _Object struct {
+	id  string
+	pos linemath.Vector2
+
+	watcher *_Watcher // 是watcher时指向外层
+}
+
+func (o *_Object) GetAOIID() string {
+	return o.id
+}
+
+func (o *_Object) GetCoordPos() linemath.Vector2 {
+	return o.pos
+}
+
+// target 交给AOI的对象, watcher需要用外层才能被识别
+func (o *_Object) target() aoi.IObject {
+	if o.watcher != nil {
+		return o.watcher
+	}
+
+	return o
+}
+
+type _Watcher struct {
+	_Object
+
+	visual  float32
+	events  chan Event
+	closing <-chan struct{} // 服务关闭后不再等待消费方
+}
+
+func (w *_Watcher) GetVisual() float32 {
+	return w.visual
+}
+
+func (w *_Watcher) OnObjectEnter(obj aoi.IObject) {
+	w.send(Event{Type: EventEnter, Objs: []ObjectInfo{newObjectInfo(obj)}})
+}
+
+func (w *_Watcher) OnObjectLeave(obj aoi.IObject) {
+	w.send(Event{Type: EventLeave, Objs: []ObjectInfo{newObjectInfo(obj)}})
+}
+
+func (w *_Watcher) OnBatchEnter(objs []aoi.IObject) {
+	w.send(Event{Type: EventEnter, Objs: newObjectInfos(objs)})
+}
+
+func (w *_Watcher) OnBatchLeave(objs []aoi.IObject) {
+	w.send(Event{Type: EventLeave, Objs: newObjectInfos(objs)})
+}
+
+// send 通道满时等待消费方, 服务关闭后直接丢弃
+func (w *_Watcher) send(ev Event) {
+	select {
+	case w.events <- ev:
+	case <-w.closing:
+		select {
+		case w.events <- ev:
+		default:
+		}
+	}
+}
+
+func newObjectInfo(obj aoi.IObject) ObjectInfo {
+	return ObjectInfo{ID: obj.GetAOIID(), Pos: obj.GetCoordPos()}
+}
+
+func newObjectInfos(objs []aoi.IObject) []ObjectInfo {
+	infos := make([]ObjectInfo, 0, len(objs))
+	for _, o := range objs {
+		infos = append(infos, newObjectInfo(o))
+	}
+
+	return infos
+}
@@ -0,0 +1,251 @@
+package aoiservice
+
+import (
+	"aoi"
+	"aoi/base/linemath"
+	"errors"
+	"sync"
+)
+
+// Service 在独立协程中持有一个AOI, 其他协程通过命令通道访问
+//
+// 命令队列满时调用方阻塞, watcher事件通道满时AOI协程阻塞, 形成背压.
+// 因此事件的消费方不要在同一个协程里调用Service的方法, 否则可能互相等待.
+// Close之后阻塞的调用返回ErrServiceClosed, 事件通道满时丢弃事件, 不会因为消费方停止读取而卡住.
+type Service struct {
+	aoi  aoi.IAOI
+	objs map[string]*_Object // 只在AOI协程中访问
+
+	eventBuffer int
+
+	mu      sync.RWMutex // 保护closed
+	closed  bool
+	cmds    chan func()
+	closing chan struct{}
+	done    chan struct{}
+}
+
+type EventType int
+
+const (
+	EventEnter EventType = iota
+	EventLeave
+)
+
+// ObjectInfo 事件和查询中返回的对象快照
+type ObjectInfo struct {
+	ID  string
+	Pos linemath.Vector2
+}
+
+// Event 推送给watcher的进出视野事件
+type Event struct {
+	Type EventType
+	Objs []ObjectInfo
+}
+
+var (
+	ErrServiceClosed     = errors.New("service closed")
+	ErrQueryNotSupported = errors.New("query not supported")
+)
+
+type Config struct {
+	// New 创建被托管的AOI
+	New func() (aoi.IAOI, error)
+	// QueueSize 命令队列长度, 默认1024
+	QueueSize int
+	// EventBuffer 每个watcher事件通道的长度, 默认256
+	EventBuffer int
+}
+
+func New(cfg *Config) (*Service, error) {
+	a, err := cfg.New()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Service{
+		aoi:         a,
+		objs:        make(map[string]*_Object),
+		eventBuffer: cfg.EventBuffer,
+		closing:     make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if s.eventBuffer <= 0 {
+		s.eventBuffer = 256
+	}
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = 1024
+	}
+	s.cmds = make(chan func(), queueSize)
+
+	go s.run()
+
+	return s, nil
+}
+
+// Add 加入一个没有视野的对象
+func (s *Service) Add(id string, pos linemath.Vector2) error {
+	return s.call(func() error {
+		obj := &_Object{id: id, pos: pos}
+		if err := s.aoi.AddToAOI(obj); err != nil {
+			return err
+		}
+		s.objs[id] = obj
+		return nil
+	})
+}
+
+// AddWatcher 加入一个watcher, 返回它的事件通道, 移除或者服务关闭时通道被关闭
+func (s *Service) AddWatcher(id string, pos linemath.Vector2, visual float32) (<-chan Event, error) {
+	events := make(chan Event, s.eventBuffer)
+	err := s.call(func() error {
+		w := &_Watcher{_Object: _Object{id: id, pos: pos}, visual: visual, events: events, closing: s.closing}
+		w.watcher = w
+		if err := s.aoi.AddToAOI(w); err != nil {
+			return err
+		}
+		s.objs[id] = &w._Object
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (s *Service) Move(id string, pos linemath.Vector2) error {
+	return s.call(func() error {
+		obj, ok := s.objs[id]
+		if !ok {
+			return aoi.ErrObjectNotExisted
+		}
+
+		oldPos := obj.pos
+		obj.pos = pos
+		if err := s.aoi.Move(obj.target()); err != nil {
+			obj.pos = oldPos
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *Service) Remove(id string) error {
+	return s.call(func() error {
+		obj, ok := s.objs[id]
+		if !ok {
+			return aoi.ErrObjectNotExisted
+		}
+
+		if err := s.aoi.RemoveFromAOI(obj.target()); err != nil {
+			return err
+		}
+		delete(s.objs, id)
+		if obj.watcher != nil {
+			close(obj.watcher.events)
+		}
+		return nil
+	})
+}
+
+// QueryRadius 需要托管的AOI实现aoi.IAOIQuery
+func (s *Service) QueryRadius(pos linemath.Vector2, radius float32) ([]ObjectInfo, error) {
+	var result []ObjectInfo
+	err := s.call(func() error {
+		q, ok := s.aoi.(aoi.IAOIQuery)
+		if !ok {
+			return ErrQueryNotSupported
+		}
+
+		q.QueryRadius(pos, radius, func(obj aoi.IObject) bool {
+			result = append(result, newObjectInfo(obj))
+			return true
+		})
+		return nil
+	})
+
+	return result, err
+}
+
+// Close 不再接受新的命令, 执行完队列中剩余的命令后关闭所有事件通道, 阻塞到AOI协程退出
+// 关闭之后事件通道已满时事件被丢弃, 消费方停止读取也不会阻塞关闭
+func (s *Service) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		<-s.done
+		return
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.closing)
+	<-s.done
+}
+
+// call 投递命令并等待结果, 投递和等待时不持有锁, 关闭时都会返回
+func (s *Service) call(fn func() error) error {
+	s.mu.RLock()
+	closed := s.closed
+	s.mu.RUnlock()
+	if closed {
+		return ErrServiceClosed
+	}
+
+	result := make(chan error, 1)
+	select {
+	case s.cmds <- func() {
+		result <- fn()
+	}:
+	case <-s.closing:
+		return ErrServiceClosed
+	}
+
+	select {
+	case err := <-result:
+		return err
+	case <-s.done:
+		// 关闭的同时进入队列的命令可能没有被执行
+		select {
+		case err := <-result:
+			return err
+		default:
+			return ErrServiceClosed
+		}
+	}
+}
+
+func (s *Service) run() {
+	defer close(s.done)
+
+	for {
+		select {
+		case cmd := <-s.cmds:
+			cmd()
+		case <-s.closing:
+			s.drain()
+			return
+		}
+	}
+}
+
+// drain 关闭时执行剩余的命令, closed之后不会再有新命令进入队列
+func (s *Service) drain() {
+	for {
+		select {
+		case cmd := <-s.cmds:
+			cmd()
+		default:
+			for _, obj := range s.objs {
+				if obj.watcher != nil {
+					close(obj.watcher.events)
+				}
+			}
+			s.objs = make(map[string]*_Object)
+			return
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package aoiservice
+
+import (
+	"aoi"
+	"aoi/base/linemath"
+	"aoi/toweraoi"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestService(t *testing.T) *Service {
+	s, err := New(&Config{
+		New: func() (aoi.IAOI, error) {
+			return toweraoi.New(&toweraoi.Config{
+				MinPos:    linemath.Vector2{X: -100, Y: -100},
+				MaxPos:    linemath.Vector2{X: 100, Y: 100},
+				TowerSize: 10,
+			})
+		},
+		QueueSize:   4,
+		EventBuffer: 16,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestService_Events(t *testing.T) {
+	s := newTestService(t)
+
+	events, err := s.AddWatcher("watcher", linemath.Vector2{}, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev := <-events; ev.Type != EventEnter || len(ev.Objs) != 1 || ev.Objs[0].ID != "watcher" {
+		t.Fatal("watcher should see itself", ev)
+	}
+
+	if err := s.Add("obj", linemath.Vector2{X: 5, Y: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if ev := <-events; ev.Type != EventEnter || ev.Objs[0].ID != "obj" {
+		t.Fatal("obj enter", ev)
+	}
+	if s.Add("obj", linemath.Vector2{}) != aoi.ErrObjectExisted {
+		t.Fatal("add twice")
+	}
+
+	if err := s.Move("obj", linemath.Vector2{X: 80, Y: 80}); err != nil {
+		t.Fatal(err)
+	}
+	if ev := <-events; ev.Type != EventLeave || ev.Objs[0].ID != "obj" {
+		t.Fatal("obj leave", ev)
+	}
+	if s.Move("obj", linemath.Vector2{X: 1000}) != aoi.ErrPosInvalid {
+		t.Fatal("move out of map")
+	}
+
+	result, err := s.QueryRadius(linemath.Vector2{X: 80, Y: 80}, 1)
+	if err != nil || len(result) != 1 || result[0].ID != "obj" {
+		t.Fatal("QueryRadius", result, err)
+	}
+
+	if err := s.Remove("watcher"); err != nil {
+		t.Fatal(err)
+	}
+	for range events {
+	}
+
+	s.Close()
+	if s.Add("other", linemath.Vector2{}) != ErrServiceClosed {
+		t.Fatal("service closed")
+	}
+}
+
+func TestService_Concurrent(t *testing.T) {
+	s := newTestService(t)
+
+	events, err := s.AddWatcher("watcher", linemath.Vector2{}, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	visible := make(map[string]bool)
+	consumed := make(chan struct{})
+	go func() {
+		defer close(consumed)
+		for ev := range events {
+			for _, o := range ev.Objs {
+				visible[o.ID] = ev.Type == EventEnter
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				id := fmt.Sprintf("obj:%d:%d", worker, j)
+				s.Add(id, linemath.Vector2{X: float32(j), Y: float32(worker)})
+				s.Move(id, linemath.Vector2{X: float32(-j), Y: float32(-worker)})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 关闭时队列中的命令执行完, 事件通道随后关闭
+	s.Close()
+	<-consumed
+
+	count := 0
+	for _, v := range visible {
+		if v {
+			count++
+		}
+	}
+	if count != 8*50+1 {
+		t.Fatal("visible count", count)
+	}
+}
+
+func TestService_CloseBlocked(t *testing.T) {
+	s := newTestService(t)
+
+	if _, err := s.AddWatcher("watcher", linemath.Vector2{}, 20); err != nil {
+		t.Fatal(err)
+	}
+
+	// 消费方不再读取, 事件通道满后AOI协程阻塞, 命令队列随后被填满
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s.Add(fmt.Sprint("obj", i), linemath.Vector2{X: 1, Y: 1})
+		}(i)
+	}
+	for len(s.cmds) < cap(s.cmds) {
+		runtime.Gosched()
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		wg.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked")
+	}
+
+	if s.Add("other", linemath.Vector2{}) != ErrServiceClosed {
+		t.Fatal("service closed")
+	}
+}
@@ -0,0 +1,459 @@
+package aoitest
+
+import (
+	"aoi"
+	"aoi/base/linemath"
+	"testing"
+)
+
+// 被测AOI的地图范围, 有边界的实现需要按这个范围创建
+var (
+	MinPos = linemath.Vector2{X: -100, Y: -100}
+	MaxPos = linemath.Vector2{X: 100, Y: 100}
+)
+
+// 测试中使用的视野, 距离near以内的对象一定可见, 距离far以上的一定不可见
+const (
+	visual = 20
+	near   = 5
+	far    = 100
+)
+
+// Factory 被测的实现
+type Factory struct {
+	// New 创建一个新的AOI, 地图范围是[MinPos, MaxPos], 灯塔类的实现灯塔边长不超过10
+	New func() (aoi.IAOI, error)
+
+	// Unlimited 没有视野和地图边界, 所有watcher看到所有对象(defaultaoi), 不检查距离和ErrPosInvalid
+	Unlimited bool
+}
+
+// RunConformance 所有aoi.IAOI实现共同遵守的约定: 加入移除移动, 全局对象, 群组, 错误返回和遍历
+// watcher是否看到自己由实现决定, 不检查
+func RunConformance(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		f    func(t *testing.T, s *suite)
+	}{
+		{"AddRemove", testAddRemove},
+		{"Move", testMove},
+		{"Global", testGlobal},
+		{"Group", testGroup},
+		{"Traversal", testTraversal},
+		{"Errors", testErrors},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			a, err := factory.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s := &suite{t: t, aoi: a, unlimited: factory.Unlimited}
+			test.f(t, s)
+			s.checkWatchers()
+		})
+	}
+}
+
+type suite struct {
+	t         *testing.T
+	aoi       aoi.IAOI
+	unlimited bool
+
+	watchers []*Watcher
+}
+
+func (s *suite) watcher(id string, x, y float32) *Watcher {
+	w := NewWatcher(id, linemath.Vector2{X: x, Y: y}, visual)
+	s.watchers = append(s.watchers, w)
+	return w
+}
+
+func (s *suite) add(obj aoi.IObject) {
+	s.t.Helper()
+	if err := s.aoi.AddToAOI(obj); err != nil {
+		s.t.Fatalf("AddToAOI %s: %v", obj.GetAOIID(), err)
+	}
+}
+
+func (s *suite) remove(obj aoi.IObject) {
+	s.t.Helper()
+	if err := s.aoi.RemoveFromAOI(obj); err != nil {
+		s.t.Fatalf("RemoveFromAOI %s: %v", obj.GetAOIID(), err)
+	}
+}
+
+func (s *suite) move(obj aoi.IObject, x, y float32) {
+	s.t.Helper()
+	setPos(obj, linemath.Vector2{X: x, Y: y})
+	if err := s.aoi.Move(obj); err != nil {
+		s.t.Fatalf("Move %s: %v", obj.GetAOIID(), err)
+	}
+}
+
+// sees 检查可见性, 没有视野限制的实现所有对象都应该可见
+func (s *suite) sees(w *Watcher, id string, expected bool) {
+	s.t.Helper()
+	if s.unlimited {
+		expected = true
+	}
+	if w.Sees(id) != expected {
+		s.t.Fatalf("watcher %s sees %s: %v, expected %v, visible %v", w.ID, id, w.Sees(id), expected, w.Visible())
+	}
+}
+
+func (s *suite) expectErr(op string, err, expected error) {
+	s.t.Helper()
+	if err != expected {
+		s.t.Fatalf("%s: got %v, expected %v", op, err, expected)
+	}
+}
+
+// checkWatchers 所有watcher收到的通知前后一致
+func (s *suite) checkWatchers() {
+	s.t.Helper()
+	for _, w := range s.watchers {
+		if err := w.Err(); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+}
+
+func traversalIDs(f func(cb func(watcher aoi.IWatcher) bool)) map[string]int {
+	ids := make(map[string]int)
+	f(func(watcher aoi.IWatcher) bool {
+		ids[watcher.GetAOIID()]++
+		return true
+	})
+
+	return ids
+}
+
+func setPos(obj aoi.IObject, pos linemath.Vector2) {
+	switch o := obj.(type) {
+	case *Watcher:
+		o.Pos = pos
+	case *Marker:
+		o.Pos = pos
+	}
+}
+
+func testAddRemove(t *testing.T, s *suite) {
+	w := s.watcher("watcher", 0, 0)
+	s.add(w)
+
+	m := NewMarker("marker", linemath.Vector2{X: 3})
+	s.add(m)
+	s.sees(w, "marker", true)
+	if len(w.Enters) == 0 {
+		t.Fatal("no enter notification")
+	}
+
+	// 后加入的watcher看到已有的对象, 已有的watcher也看到它
+	late := s.watcher("late", 0, 3)
+	s.add(late)
+	s.sees(late, "marker", true)
+	s.sees(late, "watcher", true)
+	s.sees(w, "late", true)
+
+	distant := NewMarker("distant", linemath.Vector2{X: far - 10, Y: far - 10})
+	s.add(distant)
+	s.sees(w, "distant", false)
+
+	s.remove(m)
+	if w.Sees("marker") || late.Sees("marker") {
+		t.Fatal("removed marker still visible", w.Visible(), late.Visible())
+	}
+
+	s.remove(late)
+	if w.Sees("late") {
+		t.Fatal("removed watcher still visible", w.Visible())
+	}
+
+	// 移除之后ID可以重新使用
+	s.add(m)
+	s.sees(w, "marker", true)
+}
+
+func testMove(t *testing.T, s *suite) {
+	w := s.watcher("watcher", 0, 0)
+	m := NewMarker("marker", linemath.Vector2{X: 3})
+	s.add(w)
+	s.add(m)
+	s.sees(w, "marker", true)
+
+	s.move(m, far-20, far-20)
+	s.sees(w, "marker", false)
+	s.move(m, near, 0)
+	s.sees(w, "marker", true)
+
+	// watcher移动
+	s.move(w, -far+20, far-20)
+	s.sees(w, "marker", false)
+	s.move(w, 0, 1)
+	s.sees(w, "marker", true)
+
+	// 视野内的小范围移动不产生通知
+	w.Reset()
+	s.move(m, near-1, 1)
+	s.move(w, 1, 0)
+	if len(w.Enters) != 0 || len(w.Leaves) != 0 {
+		t.Fatal("notified while moving in sight", w.Enters, w.Leaves)
+	}
+	s.sees(w, "marker", true)
+}
+
+func testGlobal(t *testing.T, s *suite) {
+	w := s.watcher("watcher", -far+20, -far+20)
+	g := NewMarker("global", linemath.Vector2{X: far - 20, Y: far - 20})
+	s.add(w)
+	s.add(g)
+	s.sees(w, "global", false)
+
+	if err := s.aoi.AddGlobalMarker(g); err != nil {
+		t.Fatal(err)
+	}
+	s.sees(w, "global", true)
+	s.expectErr("AddGlobalMarker twice", s.aoi.AddGlobalMarker(g), aoi.ErrObjectExisted)
+
+	// 全局对象移动后仍然可见, 之后加入的watcher也能看到
+	s.move(g, far-10, far-20)
+	s.sees(w, "global", true)
+	late := s.watcher("late", -far+20, far-20)
+	s.add(late)
+	s.sees(late, "global", true)
+
+	// 取消全局后回到当前位置
+	if err := s.aoi.RemoveGlobalMarker(g); err != nil {
+		t.Fatal(err)
+	}
+	s.sees(w, "global", false)
+	s.sees(late, "global", false)
+	s.expectErr("RemoveGlobalMarker twice", s.aoi.RemoveGlobalMarker(g), aoi.ErrObjectNotExisted)
+
+	if err := s.aoi.AddGlobalMarker(g); err != nil {
+		t.Fatal(err)
+	}
+	setPos(g, linemath.Vector2{X: -far + 20 + near, Y: -far + 20})
+	if err := s.aoi.RemoveGlobalMarker(g); err != nil {
+		t.Fatal(err)
+	}
+	s.sees(w, "global", true)
+	s.sees(late, "global", false)
+
+	// 移除全局对象
+	if err := s.aoi.AddGlobalMarker(g); err != nil {
+		t.Fatal(err)
+	}
+	s.remove(g)
+	if w.Sees("global") || late.Sees("global") {
+		t.Fatal("removed global still visible", w.Visible(), late.Visible())
+	}
+}
+
+func testGroup(t *testing.T, s *suite) {
+	w := s.watcher("watcher", -far+20, -far+20)
+	member := NewMarker("member", linemath.Vector2{X: far - 20, Y: far - 20})
+	other := s.watcher("other", far-20, -far+20)
+	s.add(w)
+	s.add(member)
+	s.add(other)
+	s.sees(w, "member", false)
+
+	groupID, err := s.aoi.CreateGroup([]aoi.IObject{w, member})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.sees(w, "member", true)
+	s.sees(other, "member", false)
+
+	ids := traversalIDs(func(cb func(watcher aoi.IWatcher) bool) {
+		s.aoi.TraversalGroup(member, groupID, cb)
+	})
+	if ids["watcher"] != 1 || ids["other"] != 0 {
+		t.Fatal("TraversalGroup", ids)
+	}
+
+	// 移出和重新加入群组
+	if err := s.aoi.RemoveFromGroup(member, groupID); err != nil {
+		t.Fatal(err)
+	}
+	s.sees(w, "member", false)
+	if err := s.aoi.AddToGroup(member, groupID); err != nil {
+		t.Fatal(err)
+	}
+	s.sees(w, "member", true)
+
+	// 新成员是watcher时也看到群组里的对象
+	if err := s.aoi.AddToGroup(other, groupID); err != nil {
+		t.Fatal(err)
+	}
+	s.sees(other, "member", true)
+	s.sees(w, "other", true)
+
+	// 群组中的对象移动后仍然可见
+	s.move(member, far-10, far-10)
+	s.sees(w, "member", true)
+
+	if err := s.aoi.DestroyGroup(groupID); err != nil {
+		t.Fatal(err)
+	}
+	s.sees(w, "member", false)
+	s.sees(other, "member", false)
+	s.expectErr("DestroyGroup twice", s.aoi.DestroyGroup(groupID), aoi.ErrGroupNotExisted)
+
+	// 移除群组成员
+	groupID, err = s.aoi.CreateGroup([]aoi.IObject{w, member})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.sees(w, "member", true)
+	s.remove(member)
+	if w.Sees("member") {
+		t.Fatal("removed member still visible", w.Visible())
+	}
+}
+
+func testTraversal(t *testing.T, s *suite) {
+	w := s.watcher("watcher", 0, 0)
+	distant := s.watcher("distant", far-20, far-20)
+	m := NewMarker("marker", linemath.Vector2{X: 3})
+	s.add(w)
+	s.add(distant)
+	s.add(m)
+
+	ids := traversalIDs(func(cb func(watcher aoi.IWatcher) bool) {
+		s.aoi.Traversal(m, cb)
+	})
+	if ids["watcher"] != 1 || (!s.unlimited && ids["distant"] != 0) || (s.unlimited && ids["distant"] != 1) {
+		t.Fatal("Traversal", ids)
+	}
+	for id, count := range ids {
+		if count != 1 {
+			t.Fatal("Traversal visits twice", id, ids)
+		}
+	}
+
+	// cb返回false时停止
+	count := 0
+	s.aoi.Traversal(m, func(watcher aoi.IWatcher) bool {
+		count++
+		return false
+	})
+	if count != 1 {
+		t.Fatal("Traversal not stopped", count)
+	}
+
+	// 不在AOI中的对象和nil不遍历
+	count = 0
+	s.aoi.Traversal(NewMarker("unknown", linemath.Vector2{}), func(watcher aoi.IWatcher) bool {
+		count++
+		return true
+	})
+	s.aoi.Traversal(nil, func(watcher aoi.IWatcher) bool {
+		count++
+		return true
+	})
+	if count != 0 {
+		t.Fatal("Traversal unknown", count)
+	}
+
+	// 全局对象被所有watcher遍历
+	if err := s.aoi.AddGlobalMarker(m); err != nil {
+		t.Fatal(err)
+	}
+	ids = traversalIDs(func(cb func(watcher aoi.IWatcher) bool) {
+		s.aoi.Traversal(m, cb)
+	})
+	if ids["watcher"] != 1 || ids["distant"] != 1 {
+		t.Fatal("Traversal global", ids)
+	}
+}
+
+func testErrors(t *testing.T, s *suite) {
+	w := s.watcher("watcher", 0, 0)
+	m := NewMarker("marker", linemath.Vector2{X: 3})
+	s.add(w)
+	s.add(m)
+
+	s.expectErr("AddToAOI nil", s.aoi.AddToAOI(nil), aoi.ErrObjectInvalid)
+	s.expectErr("RemoveFromAOI nil", s.aoi.RemoveFromAOI(nil), aoi.ErrObjectInvalid)
+	s.expectErr("Move nil", s.aoi.Move(nil), aoi.ErrObjectInvalid)
+
+	s.expectErr("AddToAOI twice", s.aoi.AddToAOI(m), aoi.ErrObjectExisted)
+	s.expectErr("AddToAOI same ID", s.aoi.AddToAOI(NewMarker("marker", linemath.Vector2{})), aoi.ErrObjectExisted)
+
+	unknown := NewMarker("unknown", linemath.Vector2{X: 1})
+	s.expectErr("RemoveFromAOI unknown", s.aoi.RemoveFromAOI(unknown), aoi.ErrObjectNotExisted)
+	s.expectErr("Move unknown", s.aoi.Move(unknown), aoi.ErrObjectNotExisted)
+	s.expectErr("AddGlobalMarker unknown", s.aoi.AddGlobalMarker(unknown), aoi.ErrObjectNotExisted)
+	s.expectErr("RemoveGlobalMarker not global", s.aoi.RemoveGlobalMarker(m), aoi.ErrObjectNotExisted)
+
+	if !s.unlimited {
+		outside := NewMarker("outside", linemath.Vector2{X: MaxPos.X + 10})
+		s.expectErr("AddToAOI outside", s.aoi.AddToAOI(outside), aoi.ErrPosInvalid)
+		s.expectErr("RemoveFromAOI rejected", s.aoi.RemoveFromAOI(outside), aoi.ErrObjectNotExisted)
+
+		// 移动失败时保持原来的位置
+		setPos(m, linemath.Vector2{X: MinPos.X - 10})
+		s.expectErr("Move outside", s.aoi.Move(m), aoi.ErrPosInvalid)
+		setPos(m, linemath.Vector2{X: 3})
+		s.sees(w, "marker", true)
+		s.move(m, near, 0)
+		s.sees(w, "marker", true)
+
+		if err := s.aoi.AddGlobalMarker(m); err != nil {
+			t.Fatal(err)
+		}
+		setPos(m, linemath.Vector2{Y: MaxPos.Y + 10})
+		s.expectErr("RemoveGlobalMarker outside", s.aoi.RemoveGlobalMarker(m), aoi.ErrPosInvalid)
+		setPos(m, linemath.Vector2{X: 3})
+		if err := s.aoi.RemoveGlobalMarker(m); err != nil {
+			t.Fatal(err)
+		}
+		s.sees(w, "marker", true)
+	}
+
+	// 正好在地图边界上的位置是合法的
+	corner := s.watcher("corner", MaxPos.X, MaxPos.Y)
+	edge := NewMarker("edge", MaxPos)
+	s.add(corner)
+	s.add(edge)
+	s.sees(corner, "edge", true)
+	s.move(edge, MinPos.X, MinPos.Y)
+	s.sees(corner, "edge", false)
+	s.move(edge, MaxPos.X, MinPos.Y)
+	s.move(edge, MaxPos.X, MaxPos.Y)
+	s.sees(corner, "edge", true)
+	s.expectErr("AddGlobalMarker MaxPos", s.aoi.AddGlobalMarker(edge), nil)
+	setPos(edge, MinPos)
+	s.expectErr("RemoveGlobalMarker MinPos", s.aoi.RemoveGlobalMarker(edge), nil)
+	s.sees(corner, "edge", false)
+	s.expectErr("AddGlobalMarker MinPos", s.aoi.AddGlobalMarker(edge), nil)
+	setPos(edge, MaxPos)
+	s.expectErr("RemoveGlobalMarker MaxPos", s.aoi.RemoveGlobalMarker(edge), nil)
+	s.sees(corner, "edge", true)
+	s.remove(edge)
+	s.remove(corner)
+	s.checkWatchers()
+
+	const badGroup = 1 << 30
+	s.expectErr("DestroyGroup unknown", s.aoi.DestroyGroup(badGroup), aoi.ErrGroupNotExisted)
+	s.expectErr("AddToGroup unknown", s.aoi.AddToGroup(m, badGroup), aoi.ErrGroupNotExisted)
+	s.expectErr("RemoveFromGroup unknown", s.aoi.RemoveFromGroup(m, badGroup), aoi.ErrGroupNotExisted)
+
+	_, err := s.aoi.CreateGroup(nil)
+	s.expectErr("CreateGroup empty", err, aoi.ErrObjectInvalid)
+	_, err = s.aoi.CreateGroup([]aoi.IObject{m, unknown})
+	s.expectErr("CreateGroup unknown", err, aoi.ErrObjectNotExisted)
+
+	groupID, err := s.aoi.CreateGroup([]aoi.IObject{w})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.expectErr("AddToGroup unknown object", s.aoi.AddToGroup(unknown, groupID), aoi.ErrObjectNotExisted)
+	s.expectErr("RemoveFromGroup unknown object", s.aoi.RemoveFromGroup(unknown, groupID), aoi.ErrObjectNotExisted)
+}
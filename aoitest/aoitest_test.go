@@ -0,0 +1,41 @@
+package aoitest
+
+import (
+	"aoi"
+	"aoi/base/linemath"
+	"reflect"
+	"testing"
+)
+
+func TestWatcher_Record(t *testing.T) {
+	w := NewWatcher("watcher", linemath.Vector2{}, 10)
+	m1 := NewMarker("m1", linemath.Vector2{})
+	m2 := NewMarker("m2", linemath.Vector2{})
+
+	w.OnBatchEnter([]aoi.IObject{m2, m1})
+	w.OnBatchEnter(nil)
+	w.OnLayerObjectLeave(m1, 1)
+	if !reflect.DeepEqual(w.Enters, [][]string{{"m1", "m2"}}) || !reflect.DeepEqual(w.Leaves, [][]string{{"m1"}}) {
+		t.Fatal("record", w.Enters, w.Leaves)
+	}
+	if !reflect.DeepEqual(w.Visible(), []string{"m2"}) || w.Err() != nil {
+		t.Fatal("visible", w.Visible(), w.Err())
+	}
+
+	w.Reset()
+	if len(w.Enters) != 0 || len(w.Leaves) != 0 || !w.Sees("m2") {
+		t.Fatal("reset", w.Enters, w.Leaves, w.Visible())
+	}
+
+	// 不一致的通知
+	w.OnObjectEnter(m2)
+	if w.Err() == nil {
+		t.Fatal("entered twice")
+	}
+
+	w = NewWatcher("watcher", linemath.Vector2{}, 10)
+	w.OnLayerBatchLeave([]aoi.IObject{m1}, 1)
+	if w.Err() == nil {
+		t.Fatal("left without entering")
+	}
+}
@@ -0,0 +1,194 @@
+package aoitest
+
+import (
+	"aoi"
+	"aoi/base/linemath"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Marker 测试用的对象, 同时实现了layeraoi的GetLayerBits
+type Marker struct {
+	ID  string
+	Pos linemath.Vector2
+}
+
+func NewMarker(id string, pos linemath.Vector2) *Marker {
+	return &Marker{ID: id, Pos: pos}
+}
+
+func (m *Marker) GetAOIID() string {
+	return m.ID
+}
+
+func (m *Marker) GetCoordPos() linemath.Vector2 {
+	return m.Pos
+}
+
+func (m *Marker) GetLayerBits() uint64 {
+	return 1
+}
+
+// Watcher 记录收到的每一次进入和离开通知, 以及当前视野内的对象
+// 同时在结构上实现了layeraoi.ILayerWatcher, 所有层使用同一个视野, 分层的通知和普通通知记录在一起
+// 通知可能来自不同的协程, 通知和查询方法加锁, 直接读Enters和Leaves时需要等通知结束
+type Watcher struct {
+	ID     string
+	Pos    linemath.Vector2
+	Visual float32
+
+	Enters [][]string // 每次进入通知的对象ID, 已排序
+	Leaves [][]string // 每次离开通知的对象ID, 已排序
+
+	mu      sync.Mutex
+	visible map[string]aoi.IObject
+	err     error
+}
+
+func NewWatcher(id string, pos linemath.Vector2, visual float32) *Watcher {
+	return &Watcher{
+		ID:      id,
+		Pos:     pos,
+		Visual:  visual,
+		visible: make(map[string]aoi.IObject),
+	}
+}
+
+func (w *Watcher) GetAOIID() string {
+	return w.ID
+}
+
+func (w *Watcher) GetCoordPos() linemath.Vector2 {
+	return w.Pos
+}
+
+func (w *Watcher) GetVisual() float32 {
+	return w.Visual
+}
+
+func (w *Watcher) OnObjectEnter(obj aoi.IObject) {
+	w.OnBatchEnter([]aoi.IObject{obj})
+}
+
+func (w *Watcher) OnObjectLeave(obj aoi.IObject) {
+	w.OnBatchLeave([]aoi.IObject{obj})
+}
+
+// OnBatchEnter 空的通知不记录, 重复进入记录为错误
+func (w *Watcher) OnBatchEnter(objs []aoi.IObject) {
+	if len(objs) == 0 {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, o := range objs {
+		if _, ok := w.visible[o.GetAOIID()]; ok && w.err == nil {
+			w.err = fmt.Errorf("watcher %s: %s entered twice", w.ID, o.GetAOIID())
+		}
+		w.visible[o.GetAOIID()] = o
+	}
+	w.Enters = append(w.Enters, sortedIDs(objs))
+}
+
+// OnBatchLeave 空的通知不记录, 不在视野内的对象离开记录为错误
+func (w *Watcher) OnBatchLeave(objs []aoi.IObject) {
+	if len(objs) == 0 {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, o := range objs {
+		if _, ok := w.visible[o.GetAOIID()]; !ok && w.err == nil {
+			w.err = fmt.Errorf("watcher %s: %s left without entering", w.ID, o.GetAOIID())
+		}
+		delete(w.visible, o.GetAOIID())
+	}
+	w.Leaves = append(w.Leaves, sortedIDs(objs))
+}
+
+func (w *Watcher) GetLayerBits() uint64 {
+	return 1
+}
+
+func (w *Watcher) GetLayerVisual(layer int) float32 {
+	return w.Visual
+}
+
+func (w *Watcher) OnLayerObjectEnter(obj aoi.IObject, layer int) {
+	w.OnObjectEnter(obj)
+}
+
+func (w *Watcher) OnLayerObjectLeave(obj aoi.IObject, layer int) {
+	w.OnObjectLeave(obj)
+}
+
+func (w *Watcher) OnLayerBatchEnter(objs []aoi.IObject, layer int) {
+	w.OnBatchEnter(objs)
+}
+
+func (w *Watcher) OnLayerBatchLeave(objs []aoi.IObject, layer int) {
+	w.OnBatchLeave(objs)
+}
+
+// Sees 对象当前是否在视野内
+func (w *Watcher) Sees(id string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, ok := w.visible[id]
+	return ok
+}
+
+// Object 视野内ID为id的对象, 不在视野内时返回nil
+func (w *Watcher) Object(id string) aoi.IObject {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.visible[id]
+}
+
+// Visible 当前视野内的对象ID, 已排序
+func (w *Watcher) Visible() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ids := make([]string, 0, len(w.visible))
+	for id := range w.visible {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// Reset 清空记录的通知, 当前视野保留
+func (w *Watcher) Reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.Enters = nil
+	w.Leaves = nil
+}
+
+// Err 第一次不一致的通知(重复进入, 没有进入就离开)
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.err
+}
+
+func sortedIDs(objs []aoi.IObject) []string {
+	ids := make([]string, 0, len(objs))
+	for _, o := range objs {
+		ids = append(ids, o.GetAOIID())
+	}
+	sort.Strings(ids)
+
+	return ids
+}
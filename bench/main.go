@@ -3,7 +3,10 @@ package main
 import (
 	"aoi"
 	"aoi/base/linemath"
+	"aoi/crosslistaoi"
+	"aoi/metrics"
 	"aoi/toweraoi"
+	"flag"
 	"fmt"
 	"math/rand"
 	"net/http"
@@ -30,11 +33,32 @@ func (w *Watcher) OnObjectEnter(aoi.IObject)     {}
 func (w *Watcher) OnObjectLeave(aoi.IObject)     {}
 
 func main() {
+	algorithm := flag.String("aoi", "tower", "AOI算法: tower, crosslist")
+	withMetrics := flag.Bool("metrics", false, "开启tower的指标, 在/debug/vars中查看")
+	flag.Parse()
+
+	var collector *metrics.Collector
+	if *withMetrics {
+		var err error
+		if collector, err = metrics.New(&metrics.Config{Name: "aoi"}); err != nil {
+			panic(err)
+		}
+	}
+
 	minPos := linemath.Vector2{}
 	maxPos := linemath.Vector2{X: 8000, Y: 8000}
 	towerSize := float32(50)
 
-	t, err := toweraoi.New(&toweraoi.Config{MinPos: minPos, MaxPos: maxPos, TowerSize: towerSize})
+	var t aoi.IAOI
+	var err error
+	switch *algorithm {
+	case "tower":
+		t, err = toweraoi.New(&toweraoi.Config{MinPos: minPos, MaxPos: maxPos, TowerSize: towerSize, Metrics: collector})
+	case "crosslist":
+		t, err = crosslistaoi.New(&crosslistaoi.Config{MinPos: minPos, MaxPos: maxPos})
+	default:
+		err = fmt.Errorf("unknown aoi %s", *algorithm)
+	}
 	if err != nil {
 		panic(err)
 	}
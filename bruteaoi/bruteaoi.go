@@ -0,0 +1,415 @@
+package bruteaoi
+
+import (
+	"aoi"
+	"aoi/base/linemath"
+	"errors"
+	"math"
+	"sort"
+)
+
+// _BruteAOI 暴力实现的AOI, 每次操作后两两比较所有watcher和对象, O(n²)
+// 只用于测试, 作为其他实现的参考结果. 视野在加入时确定, 和toweraoi一样之后不再读取
+type _BruteAOI struct {
+	minPos    linemath.Vector2
+	maxPos    linemath.Vector2
+	towerSize float32
+
+	objs    map[string]*_Object
+	global  map[string]bool
+	groups  map[int]map[string]bool
+	visible map[string]map[string]aoi.IObject // watcher -> 视野内的对象
+
+	groupIDSeed int
+	batchDepth  int
+}
+
+type _Object struct {
+	obj     aoi.IObject
+	watcher aoi.IWatcher
+	pos     linemath.Vector2
+	visual  float32
+	groups  map[int]bool
+}
+
+type Config struct {
+	MinPos linemath.Vector2
+	MaxPos linemath.Vector2
+
+	// TowerSize 大于0时按灯塔规则判断视野: 对象所在的灯塔在watcher视野覆盖的灯塔圈数内,
+	// 和toweraoi(非精确视野)的近似相同. 否则按圆形视野判断, 和toweraoi的ExactVisual相同
+	TowerSize float32
+}
+
+var (
+	ErrBruteConfigInvalid = errors.New("config invalid")
+)
+
+func New(cfg *Config) (aoi.IAOI, error) {
+	if cfg.TowerSize < 0 || cfg.MinPos.X > cfg.MaxPos.X || cfg.MinPos.Y > cfg.MaxPos.Y {
+		return nil, ErrBruteConfigInvalid
+	}
+
+	return &_BruteAOI{
+		minPos:    cfg.MinPos,
+		maxPos:    cfg.MaxPos,
+		towerSize: cfg.TowerSize,
+		objs:      make(map[string]*_Object),
+		global:    make(map[string]bool),
+		groups:    make(map[int]map[string]bool),
+		visible:   make(map[string]map[string]aoi.IObject),
+	}, nil
+}
+
+func (b *_BruteAOI) AddToAOI(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	if _, ok := b.objs[obj.GetAOIID()]; ok {
+		return aoi.ErrObjectExisted
+	}
+
+	pos := obj.GetCoordPos()
+	if !b.isValid(pos) {
+		return aoi.ErrPosInvalid
+	}
+
+	o := &_Object{obj: obj, pos: pos, groups: make(map[int]bool)}
+	if w, ok := obj.(aoi.IWatcher); ok {
+		o.watcher = w
+		o.visual = w.GetVisual()
+		b.visible[obj.GetAOIID()] = make(map[string]aoi.IObject)
+	}
+	b.objs[obj.GetAOIID()] = o
+
+	b.update()
+
+	return nil
+}
+
+func (b *_BruteAOI) RemoveFromAOI(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	o, ok := b.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	for groupID := range o.groups {
+		b.leaveGroup(obj.GetAOIID(), groupID)
+	}
+	delete(b.objs, obj.GetAOIID())
+	delete(b.global, obj.GetAOIID())
+	delete(b.visible, obj.GetAOIID())
+
+	b.update()
+
+	return nil
+}
+
+func (b *_BruteAOI) Move(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	o, ok := b.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	pos := obj.GetCoordPos()
+	if !b.isValid(pos) {
+		return aoi.ErrPosInvalid
+	}
+	o.pos = pos
+
+	b.update()
+
+	return nil
+}
+
+func (b *_BruteAOI) Traversal(obj aoi.IObject, cb func(watcher aoi.IWatcher) bool) {
+	if obj == nil {
+		return
+	}
+
+	if _, ok := b.objs[obj.GetAOIID()]; !ok {
+		return
+	}
+
+	for _, id := range b.sortedWatchers() {
+		if _, ok := b.visible[id][obj.GetAOIID()]; ok {
+			if !cb(b.objs[id].watcher) {
+				return
+			}
+		}
+	}
+}
+
+func (b *_BruteAOI) AddGlobalMarker(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	if _, ok := b.objs[obj.GetAOIID()]; !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	if b.global[obj.GetAOIID()] {
+		return aoi.ErrObjectExisted
+	}
+
+	b.global[obj.GetAOIID()] = true
+	b.update()
+
+	return nil
+}
+
+// RemoveGlobalMarker 对象回到当前位置
+func (b *_BruteAOI) RemoveGlobalMarker(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	if !b.global[obj.GetAOIID()] {
+		return aoi.ErrObjectNotExisted
+	}
+
+	pos := obj.GetCoordPos()
+	if !b.isValid(pos) {
+		return aoi.ErrPosInvalid
+	}
+
+	delete(b.global, obj.GetAOIID())
+	b.objs[obj.GetAOIID()].pos = pos
+	b.update()
+
+	return nil
+}
+
+func (b *_BruteAOI) CreateGroup(objs []aoi.IObject) (int, error) {
+	if len(objs) == 0 {
+		return 0, aoi.ErrObjectInvalid
+	}
+
+	for _, o := range objs {
+		if o == nil {
+			return 0, aoi.ErrObjectInvalid
+		}
+		if _, ok := b.objs[o.GetAOIID()]; !ok {
+			return 0, aoi.ErrObjectNotExisted
+		}
+	}
+
+	b.groupIDSeed++
+	group := make(map[string]bool, len(objs))
+	b.groups[b.groupIDSeed] = group
+	for _, o := range objs {
+		group[o.GetAOIID()] = true
+		b.objs[o.GetAOIID()].groups[b.groupIDSeed] = true
+	}
+
+	b.update()
+
+	return b.groupIDSeed, nil
+}
+
+func (b *_BruteAOI) DestroyGroup(groupID int) error {
+	group, ok := b.groups[groupID]
+	if !ok {
+		return aoi.ErrGroupNotExisted
+	}
+
+	for id := range group {
+		delete(b.objs[id].groups, groupID)
+	}
+	delete(b.groups, groupID)
+
+	b.update()
+
+	return nil
+}
+
+func (b *_BruteAOI) AddToGroup(obj aoi.IObject, groupID int) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	o, ok := b.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	group, ok := b.groups[groupID]
+	if !ok {
+		return aoi.ErrGroupNotExisted
+	}
+
+	group[obj.GetAOIID()] = true
+	o.groups[groupID] = true
+
+	b.update()
+
+	return nil
+}
+
+func (b *_BruteAOI) RemoveFromGroup(obj aoi.IObject, groupID int) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	if _, ok := b.objs[obj.GetAOIID()]; !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	if _, ok := b.groups[groupID]; !ok {
+		return aoi.ErrGroupNotExisted
+	}
+
+	b.leaveGroup(obj.GetAOIID(), groupID)
+	b.update()
+
+	return nil
+}
+
+func (b *_BruteAOI) TraversalGroup(obj aoi.IObject, groupID int, cb func(watcher aoi.IWatcher) bool) {
+	if obj == nil {
+		return
+	}
+
+	group, ok := b.groups[groupID]
+	if !ok || !group[obj.GetAOIID()] {
+		return
+	}
+
+	for _, id := range b.sortedWatchers() {
+		if group[id] {
+			if !cb(b.objs[id].watcher) {
+				return
+			}
+		}
+	}
+}
+
+// Begin 之后的操作只在Commit时统一通知
+func (b *_BruteAOI) Begin() {
+	b.batchDepth++
+}
+
+func (b *_BruteAOI) Commit() {
+	if b.batchDepth == 0 {
+		return
+	}
+
+	b.batchDepth--
+	b.update()
+}
+
+// leaveGroup 和toweraoi一样, 群组没有成员后自动销毁
+func (b *_BruteAOI) leaveGroup(id string, groupID int) {
+	group := b.groups[groupID]
+	delete(group, id)
+	delete(b.objs[id].groups, groupID)
+	if len(group) == 0 {
+		delete(b.groups, groupID)
+	}
+}
+
+// update 重新计算所有watcher的视野, 按ID顺序通知差异
+func (b *_BruteAOI) update() {
+	if b.batchDepth > 0 {
+		return
+	}
+
+	for _, id := range b.sortedWatchers() {
+		w := b.objs[id]
+		old := b.visible[id]
+
+		now := make(map[string]aoi.IObject, len(old))
+		for objID, o := range b.objs {
+			if b.canSee(w, o) {
+				now[objID] = o.obj
+			}
+		}
+		b.visible[id] = now
+
+		var enters, leaves []aoi.IObject
+		for objID, o := range now {
+			if _, ok := old[objID]; !ok {
+				enters = append(enters, o)
+			}
+		}
+		for objID, o := range old {
+			if _, ok := now[objID]; !ok {
+				leaves = append(leaves, o)
+			}
+		}
+
+		if len(leaves) > 0 {
+			sortObjs(leaves)
+			w.watcher.OnBatchLeave(leaves)
+		}
+		if len(enters) > 0 {
+			sortObjs(enters)
+			w.watcher.OnBatchEnter(enters)
+		}
+	}
+}
+
+// canSee 全局对象和同群组的对象总是可见, 其他对象按视野判断, 包括watcher自己
+func (b *_BruteAOI) canSee(w, o *_Object) bool {
+	if b.global[o.obj.GetAOIID()] {
+		return true
+	}
+
+	for groupID := range w.groups {
+		if o.groups[groupID] {
+			return true
+		}
+	}
+
+	if w.visual <= 0 {
+		return false
+	}
+
+	if b.towerSize <= 0 {
+		return w.pos.Sub(o.pos).Len() <= w.visual
+	}
+
+	towerVisual := int(math.Ceil(float64(w.visual/b.towerSize))) - 1
+	wx, wy := b.transPos(w.pos)
+	ox, oy := b.transPos(o.pos)
+	return ox >= wx-towerVisual && ox <= wx+towerVisual && oy >= wy-towerVisual && oy <= wy+towerVisual
+}
+
+// transPos 正好在最大边界上的对象和toweraoi一样归到最后一个灯塔
+func (b *_BruteAOI) transPos(pos linemath.Vector2) (int, int) {
+	x := math.Floor(float64((pos.X - b.minPos.X) / b.towerSize))
+	y := math.Floor(float64((pos.Y - b.minPos.Y) / b.towerSize))
+	lastX := math.Ceil(float64((b.maxPos.X-b.minPos.X)/b.towerSize)) - 1
+	lastY := math.Ceil(float64((b.maxPos.Y-b.minPos.Y)/b.towerSize)) - 1
+	return int(math.Min(x, lastX)), int(math.Min(y, lastY))
+}
+
+func (b *_BruteAOI) isValid(pos linemath.Vector2) bool {
+	return pos.X >= b.minPos.X && pos.X <= b.maxPos.X && pos.Y >= b.minPos.Y && pos.Y <= b.maxPos.Y
+}
+
+func (b *_BruteAOI) sortedWatchers() []string {
+	ids := make([]string, 0, len(b.visible))
+	for id := range b.visible {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+func sortObjs(objs []aoi.IObject) {
+	sort.Slice(objs, func(i, j int) bool {
+		return objs[i].GetAOIID() < objs[j].GetAOIID()
+	})
+}
@@ -0,0 +1,361 @@
+package bruteaoi_test
+
+import (
+	"aoi"
+	"aoi/aoitest"
+	"aoi/base/linemath"
+	"aoi/bruteaoi"
+	"aoi/layeraoi"
+	"aoi/toweraoi"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// 差分测试: 同样的操作序列同时交给各个实现和暴力参考实现, 每一步之后比较所有watcher的视野
+
+const (
+	diffObjs   = 12
+	diffSpan   = 100
+	diffTowers = 10
+)
+
+var diffMin = linemath.Vector2{X: -diffSpan / 2, Y: -diffSpan / 2}
+
+type opKind int
+
+const (
+	opAdd opKind = iota
+	opRemove
+	opMove
+	opAddGlobal
+	opRemoveGlobal
+	opCreateGroup
+	opDestroyGroup
+	opAddToGroup
+	opRemoveFromGroup
+	opKindCount
+)
+
+var opNames = [opKindCount]string{"add", "remove", "move", "addGlobal", "removeGlobal", "createGroup", "destroyGroup", "addToGroup", "removeFromGroup"}
+
+// diffOp 一步操作, obj是对象编号, other是另一个对象或者群组的编号
+type diffOp struct {
+	kind  opKind
+	obj   int
+	other int
+	pos   linemath.Vector2
+}
+
+func (op diffOp) String() string {
+	return fmt.Sprintf("%s(obj:%d, %d, (%v, %v))", opNames[op.kind], op.obj, op.other, op.pos.X, op.pos.Y)
+}
+
+// randPos 按0.25对齐, 经常落在灯塔边界上, 包括地图的MinPos和MaxPos, 偶尔越界
+func randPos(r *rand.Rand) linemath.Vector2 {
+	switch r.Intn(50) {
+	case 0:
+		return linemath.Vector2{X: diffMin.X - 1, Y: 0}
+	case 1, 2:
+		// 地图的四个角
+		return linemath.Vector2{
+			X: diffMin.X + float32(r.Intn(2)*diffSpan),
+			Y: diffMin.Y + float32(r.Intn(2)*diffSpan),
+		}
+	}
+
+	return linemath.Vector2{
+		X: diffMin.X + float32(r.Intn(diffSpan*4+1))/4,
+		Y: diffMin.Y + float32(r.Intn(diffSpan*4+1))/4,
+	}
+}
+
+func randOps(r *rand.Rand, n int) []diffOp {
+	ops := make([]diffOp, 0, n)
+	for i := 0; i < n; i++ {
+		op := diffOp{obj: r.Intn(diffObjs), other: r.Intn(diffObjs), pos: randPos(r)}
+		switch p := r.Intn(100); {
+		case p < 25:
+			op.kind = opAdd
+		case p < 35:
+			op.kind = opRemove
+		case p < 75:
+			op.kind = opMove
+		default:
+			op.kind = opAddGlobal + opKind(r.Intn(int(opKindCount-opAddGlobal)))
+		}
+		ops = append(ops, op)
+	}
+
+	return ops
+}
+
+// visualOf 偶数编号是watcher, 视野在不同的灯塔圈数之间变化, 包括没有视野
+func visualOf(i int) float32 {
+	return []float32{0, 5, 10, 12.5, 25, 40}[i/2%6]
+}
+
+// subject 一个被测的实现和它自己的一组对象
+type subject struct {
+	name   string
+	aoi    aoi.IAOI
+	objs   []aoi.IObject
+	groups []int // 按创建顺序的群组ID, 失败的创建为-1
+}
+
+func newSubject(name string, a aoi.IAOI) *subject {
+	s := &subject{name: name, aoi: a}
+	for i := 0; i < diffObjs; i++ {
+		id := fmt.Sprintf("obj:%d", i)
+		if i%2 == 0 {
+			s.objs = append(s.objs, aoitest.NewWatcher(id, linemath.Vector2{}, visualOf(i)))
+		} else {
+			s.objs = append(s.objs, aoitest.NewMarker(id, linemath.Vector2{}))
+		}
+	}
+
+	return s
+}
+
+// setPos 设置对象的位置, 返回原来的位置
+func (s *subject) setPos(i int, pos linemath.Vector2) linemath.Vector2 {
+	old := s.objs[i].GetCoordPos()
+	switch o := s.objs[i].(type) {
+	case *aoitest.Watcher:
+		o.Pos = pos
+	case *aoitest.Marker:
+		o.Pos = pos
+	}
+
+	return old
+}
+
+// moveTo 先修改位置再调用f, 失败时恢复原来的位置, 精确视野会读取对象当前的位置
+func (s *subject) moveTo(i int, pos linemath.Vector2, f func(aoi.IObject) error) error {
+	old := s.setPos(i, pos)
+	err := f(s.objs[i])
+	if err != nil {
+		s.setPos(i, old)
+	}
+
+	return err
+}
+
+func (s *subject) group(i int) int {
+	if len(s.groups) == 0 {
+		return -1
+	}
+
+	return s.groups[i%len(s.groups)]
+}
+
+func (s *subject) apply(op diffOp) error {
+	obj := s.objs[op.obj]
+	switch op.kind {
+	case opAdd:
+		return s.moveTo(op.obj, op.pos, s.aoi.AddToAOI)
+	case opRemove:
+		return s.aoi.RemoveFromAOI(obj)
+	case opMove:
+		return s.moveTo(op.obj, op.pos, s.aoi.Move)
+	case opAddGlobal:
+		return s.aoi.AddGlobalMarker(obj)
+	case opRemoveGlobal:
+		return s.moveTo(op.obj, op.pos, s.aoi.RemoveGlobalMarker)
+	case opCreateGroup:
+		groupID, err := s.aoi.CreateGroup([]aoi.IObject{obj, s.objs[op.other]})
+		if err != nil {
+			groupID = -1
+		}
+		s.groups = append(s.groups, groupID)
+		return err
+	case opDestroyGroup:
+		return s.aoi.DestroyGroup(s.group(op.other))
+	case opAddToGroup:
+		return s.aoi.AddToGroup(obj, s.group(op.other))
+	case opRemoveFromGroup:
+		return s.aoi.RemoveFromGroup(obj, s.group(op.other))
+	}
+
+	return nil
+}
+
+// reset 换成一个新的watcher, 丢弃移除前的视野
+func (s *subject) reset(i int) {
+	if w, ok := s.objs[i].(*aoitest.Watcher); ok {
+		s.objs[i] = aoitest.NewWatcher(w.ID, w.Pos, w.Visual)
+	}
+}
+
+// visible 对象编号i(watcher)当前的视野, 排序后的ID
+func (s *subject) visible(i int) string {
+	w, ok := s.objs[i].(*aoitest.Watcher)
+	if !ok {
+		return ""
+	}
+
+	return strings.Join(w.Visible(), ",")
+}
+
+type factory struct {
+	name string
+	new  func() (aoi.IAOI, error)
+}
+
+// runDiff 执行操作序列, 返回第一个和参考实现不一致的地方
+func runDiff(ref factory, impls []factory, ops []diffOp) error {
+	newSubjectFrom := func(f factory) (*subject, error) {
+		a, err := f.new()
+		if err != nil {
+			return nil, err
+		}
+		return newSubject(f.name, a), nil
+	}
+
+	expected, err := newSubjectFrom(ref)
+	if err != nil {
+		return err
+	}
+	subjects := make([]*subject, 0, len(impls))
+	for _, f := range impls {
+		s, err := newSubjectFrom(f)
+		if err != nil {
+			return err
+		}
+		subjects = append(subjects, s)
+	}
+
+	added := make(map[int]bool)
+	for step, op := range ops {
+		refErr := expected.apply(op)
+		for _, s := range subjects {
+			if err := s.apply(op); err != refErr {
+				return fmt.Errorf("step %d %v: %s returned %v, expected %v", step, op, s.name, err, refErr)
+			}
+		}
+
+		// 移除时是否通知watcher离开由实现决定, 不比较, 清空后重新加入时从头开始
+		if refErr == nil && op.kind == opAdd {
+			added[op.obj] = true
+		} else if refErr == nil && op.kind == opRemove {
+			delete(added, op.obj)
+			expected.reset(op.obj)
+			for _, s := range subjects {
+				s.reset(op.obj)
+			}
+		}
+
+		for i := range added {
+			want := expected.visible(i)
+			for _, s := range subjects {
+				if got := s.visible(i); got != want {
+					return fmt.Errorf("step %d %v: %s obj:%d sees [%s], expected [%s]", step, op, s.name, i, got, want)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// shrink 反复删除操作(先大块后单个), 只要仍然失败就保留删除, 得到一个尽量短的失败序列
+func shrink(ops []diffOp, fails func([]diffOp) bool) []diffOp {
+	for chunk := len(ops) / 2; chunk >= 1; chunk /= 2 {
+		for i := 0; i+chunk <= len(ops); {
+			candidate := append(append([]diffOp(nil), ops[:i]...), ops[i+chunk:]...)
+			if fails(candidate) {
+				ops = candidate
+			} else {
+				i += chunk
+			}
+		}
+	}
+
+	return ops
+}
+
+func checkDiff(t *testing.T, ref factory, impls []factory, seeds int, steps int) {
+	for seed := 0; seed < seeds; seed++ {
+		ops := randOps(rand.New(rand.NewSource(int64(seed))), steps)
+		err := runDiff(ref, impls, ops)
+		if err == nil {
+			continue
+		}
+
+		ops = shrink(ops, func(ops []diffOp) bool {
+			return runDiff(ref, impls, ops) != nil
+		})
+		lines := make([]string, 0, len(ops))
+		for _, op := range ops {
+			lines = append(lines, op.String())
+		}
+		t.Fatalf("seed %d: %v\nshrunk to %d ops:\n%s\n%v", seed, err, len(ops), strings.Join(lines, "\n"), runDiff(ref, impls, ops))
+	}
+}
+
+func TestDiff_Tower(t *testing.T) {
+	maxPos := linemath.Vector2{X: diffSpan / 2, Y: diffSpan / 2}
+	ref := factory{"bruteaoi", func() (aoi.IAOI, error) {
+		return bruteaoi.New(&bruteaoi.Config{MinPos: diffMin, MaxPos: maxPos, TowerSize: diffTowers})
+	}}
+	impls := []factory{
+		{"toweraoi", func() (aoi.IAOI, error) {
+			return toweraoi.New(&toweraoi.Config{MinPos: diffMin, MaxPos: maxPos, TowerSize: diffTowers})
+		}},
+		{"layeraoi", func() (aoi.IAOI, error) {
+			ta, err := layeraoi.NewTowerAoi(&layeraoi.Config{MinPos: diffMin, MaxPos: maxPos, TowerSize: diffTowers})
+			if err != nil {
+				return nil, err
+			}
+			return ta.(aoi.IAOI), nil
+		}},
+	}
+
+	checkDiff(t, ref, impls, 200, 300)
+}
+
+func TestDiff_Exact(t *testing.T) {
+	maxPos := linemath.Vector2{X: diffSpan / 2, Y: diffSpan / 2}
+	ref := factory{"bruteaoi", func() (aoi.IAOI, error) {
+		return bruteaoi.New(&bruteaoi.Config{MinPos: diffMin, MaxPos: maxPos})
+	}}
+	impls := []factory{
+		{"toweraoi(exact)", func() (aoi.IAOI, error) {
+			return toweraoi.New(&toweraoi.Config{MinPos: diffMin, MaxPos: maxPos, TowerSize: diffTowers, ExactVisual: true})
+		}},
+	}
+
+	checkDiff(t, ref, impls, 200, 300)
+}
+
+// TestDiff_Shrink 人为制造的差异能被缩小到引起差异的操作
+func TestDiff_Shrink(t *testing.T) {
+	ops := randOps(rand.New(rand.NewSource(1)), 100)
+	bad := diffOp{kind: opAddGlobal, obj: 3}
+	ops = append(ops[:50], append([]diffOp{bad}, ops[50:]...)...)
+
+	shrunk := shrink(ops, func(ops []diffOp) bool {
+		for _, op := range ops {
+			if op == bad {
+				return true
+			}
+		}
+		return false
+	})
+	if len(shrunk) != 1 || shrunk[0] != bad {
+		t.Fatal("shrink", shrunk)
+	}
+}
+
+func TestBruteAOI_Conformance(t *testing.T) {
+	aoitest.RunConformance(t, aoitest.Factory{New: func() (aoi.IAOI, error) {
+		return bruteaoi.New(&bruteaoi.Config{MinPos: aoitest.MinPos, MaxPos: aoitest.MaxPos, TowerSize: 10})
+	}})
+
+	t.Run("Circle", func(t *testing.T) {
+		aoitest.RunConformance(t, aoitest.Factory{New: func() (aoi.IAOI, error) {
+			return bruteaoi.New(&bruteaoi.Config{MinPos: aoitest.MinPos, MaxPos: aoitest.MaxPos})
+		}})
+	})
+}
@@ -0,0 +1,149 @@
+package crosslistaoi
+
+const (
+	axisX = 0
+	axisY = 1
+)
+
+// _CrossList 十字链表, 对象分别按X和Y坐标排序
+type _CrossList struct {
+	head [2]*_Node // 哨兵
+}
+
+type _Node struct {
+	obj  *_CacheObject
+	prev [2]*_Node
+	next [2]*_Node
+}
+
+func newCrossList() *_CrossList {
+	return &_CrossList{
+		head: [2]*_Node{{}, {}},
+	}
+}
+
+func (n *_Node) coord(axis int) float32 {
+	if axis == axisX {
+		return n.obj.pos.X
+	}
+
+	return n.obj.pos.Y
+}
+
+// Insert 从链表头开始查找插入位置
+func (cl *_CrossList) Insert(n *_Node) {
+	for axis := axisX; axis <= axisY; axis++ {
+		c := n.coord(axis)
+		p := cl.head[axis]
+		for p.next[axis] != nil && p.next[axis].coord(axis) < c {
+			p = p.next[axis]
+		}
+		cl.link(n, p, axis)
+	}
+}
+
+func (cl *_CrossList) Remove(n *_Node) {
+	for axis := axisX; axis <= axisY; axis++ {
+		cl.unlink(n, axis)
+	}
+}
+
+// Update 坐标变化后向前或者向后移动到新的位置, 代价与经过的对象数量成正比
+func (cl *_CrossList) Update(n *_Node) {
+	for axis := axisX; axis <= axisY; axis++ {
+		c := n.coord(axis)
+
+		p := n.prev[axis]
+		for p != cl.head[axis] && p.coord(axis) > c {
+			p = p.prev[axis]
+		}
+		if p != n.prev[axis] {
+			cl.unlink(n, axis)
+			cl.link(n, p, axis)
+			continue
+		}
+
+		last := n
+		for last.next[axis] != nil && last.next[axis].coord(axis) < c {
+			last = last.next[axis]
+		}
+		if last != n {
+			cl.unlink(n, axis)
+			cl.link(n, last, axis)
+		}
+	}
+}
+
+// Around 遍历以n为中心, 某一个轴上距离不超过r的候选对象(包括n自己), 调用方需要再按实际距离过滤
+// 两条链表同时向外扩展, 使用先结束的那条, 即候选对象较少的那条, 另一个轴上的距离不保证
+func (cl *_CrossList) Around(n *_Node, r float32, cb func(obj *_CacheObject)) {
+	walkers := [2]*_Walker{newWalker(cl, n, axisX, r), newWalker(cl, n, axisY, r)}
+	for {
+		for _, w := range walkers {
+			if !w.step() {
+				cb(n.obj)
+				for _, found := range w.found {
+					cb(found.obj)
+				}
+				return
+			}
+		}
+	}
+}
+
+func (cl *_CrossList) link(n, prev *_Node, axis int) {
+	n.prev[axis] = prev
+	n.next[axis] = prev.next[axis]
+	if prev.next[axis] != nil {
+		prev.next[axis].prev[axis] = n
+	}
+	prev.next[axis] = n
+}
+
+func (cl *_CrossList) unlink(n *_Node, axis int) {
+	n.prev[axis].next[axis] = n.next[axis]
+	if n.next[axis] != nil {
+		n.next[axis].prev[axis] = n.prev[axis]
+	}
+	n.prev[axis] = nil
+	n.next[axis] = nil
+}
+
+// _Walker 在一条链表上从中心向两侧扩展
+type _Walker struct {
+	head       *_Node
+	axis       int
+	center, r  float32
+	prev, next *_Node
+	found      []*_Node
+}
+
+func newWalker(cl *_CrossList, n *_Node, axis int, r float32) *_Walker {
+	return &_Walker{
+		head:   cl.head[axis],
+		axis:   axis,
+		center: n.coord(axis),
+		r:      r,
+		prev:   n.prev[axis],
+		next:   n.next[axis],
+	}
+}
+
+// step 每侧前进一个节点, 两侧都结束时返回false
+func (w *_Walker) step() bool {
+	if w.prev != nil && w.prev != w.head && w.center-w.prev.coord(w.axis) <= w.r {
+		w.found = append(w.found, w.prev)
+		w.prev = w.prev.prev[w.axis]
+	} else {
+		w.prev = nil
+	}
+
+	if w.next != nil && w.next.coord(w.axis)-w.center <= w.r {
+		w.found = append(w.found, w.next)
+		w.next = w.next.next[w.axis]
+	} else {
+		w.next = nil
+	}
+
+	return w.prev != nil || w.next != nil
+}
@@ -0,0 +1,522 @@
+package crosslistaoi
+
+import (
+	"aoi"
+	"aoi/base/linemath"
+	"aoi/internal/tower"
+	"errors"
+
+	log "github.com/cihub/seelog"
+)
+
+// CrossListAOI 基于十字链表的AOI实现, 进出视野按照与watcher的实际距离判断
+// 移动的代价与对象在单个轴上±最大视野范围内的对象数量成正比, 一个视野很大的watcher会让所有对象的移动变慢
+type CrossListAOI struct {
+	list           *_CrossList
+	minPos, maxPos linemath.Vector2 // 地图边界
+	objs           map[string]*_CacheObject
+
+	// 所有watcher, 以及最大视野, 对象移动时用来查找可能看到它的watcher
+	watchers  map[string]*_CacheObject
+	maxVisual float32
+
+	// 全局列表
+	global *tower.Tower
+
+	// 群组
+	groups      map[int]*tower.Tower
+	groupIDSeed int
+
+	// 缓存需要清理的watcher
+	dirtyWatchers map[string]*tower.WrapWatcher
+}
+
+type _CacheObject struct {
+	obj         aoi.IObject
+	pos         linemath.Vector2 // 在链表中的位置
+	node        *_Node
+	global      bool // 全局对象仍然在链表中, 但不参与距离判断
+	wrapWatcher *tower.WrapWatcher
+	groups      []int
+
+	seenBy map[string]*_CacheObject // 视野内有这个对象的watcher
+	sees   map[string]*_CacheObject // 是watcher时, 视野内的对象
+}
+
+var (
+	ErrCrossListConfigInvalid = errors.New("config invalid")
+)
+
+type Config struct {
+	MinPos linemath.Vector2
+	MaxPos linemath.Vector2
+}
+
+func New(cfg *Config) (aoi.IAOI, error) {
+	if cfg.MinPos.X > cfg.MaxPos.X || cfg.MinPos.Y > cfg.MaxPos.Y {
+		return nil, ErrCrossListConfigInvalid
+	}
+
+	ca := &CrossListAOI{
+		list:          newCrossList(),
+		minPos:        cfg.MinPos,
+		maxPos:        cfg.MaxPos,
+		objs:          make(map[string]*_CacheObject),
+		watchers:      make(map[string]*_CacheObject),
+		global:        tower.NewTower(),
+		groups:        make(map[int]*tower.Tower),
+		dirtyWatchers: make(map[string]*tower.WrapWatcher),
+	}
+
+	return ca, nil
+}
+
+func (c *CrossListAOI) AddToAOI(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	if _, ok := c.objs[obj.GetAOIID()]; ok {
+		return aoi.ErrObjectExisted
+	}
+
+	pos := obj.GetCoordPos()
+	if !c.isInvalid(pos) {
+		return aoi.ErrPosInvalid
+	}
+
+	cacheObj := &_CacheObject{
+		obj:    obj,
+		pos:    pos,
+		seenBy: make(map[string]*_CacheObject),
+	}
+	cacheObj.node = &_Node{obj: cacheObj}
+	c.objs[obj.GetAOIID()] = cacheObj
+	c.list.Insert(cacheObj.node)
+
+	if w, ok := obj.(aoi.IWatcher); ok {
+		ww := tower.NewWrapWatcher(w, c.notifyDirty)
+		cacheObj.wrapWatcher = ww
+		cacheObj.sees = make(map[string]*_CacheObject)
+		c.watchers[w.GetAOIID()] = cacheObj
+		if visual := w.GetVisual(); visual > c.maxVisual {
+			c.maxVisual = visual
+		}
+
+		c.global.AddWatcher(ww)
+		c.refreshWatcher(cacheObj)
+	}
+
+	c.refreshObject(cacheObj)
+
+	c.flushWatchers()
+
+	return nil
+}
+
+func (c *CrossListAOI) RemoveFromAOI(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := c.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+	delete(c.objs, obj.GetAOIID())
+
+	// 从所有group中移除
+	for _, groupID := range cacheObj.groups {
+		if group, existed := c.groups[groupID]; existed {
+			if group.Existed(obj.GetAOIID()) {
+				group.Remove(obj)
+				if cacheObj.wrapWatcher != nil {
+					group.RemoveWatcher(cacheObj.wrapWatcher)
+				}
+
+				if group.GetObjsLen() == 0 && group.GetWatchersLen() == 0 {
+					delete(c.groups, groupID)
+				}
+			} else {
+				log.Debug("奇怪的事情发生了, 检查代码")
+			}
+		}
+	}
+
+	if c.global.Existed(obj.GetAOIID()) {
+		c.global.Remove(obj)
+	} else {
+		for _, watcherObj := range cacheObj.seenBy {
+			c.setSeen(watcherObj, cacheObj, false)
+		}
+	}
+
+	if ww := cacheObj.wrapWatcher; ww != nil {
+		for _, o := range cacheObj.sees {
+			c.setSeen(cacheObj, o, false)
+		}
+		c.global.RemoveWatcher(ww)
+
+		delete(c.watchers, ww.GetAOIID())
+		if ww.GetVisual() >= c.maxVisual {
+			c.resetMaxVisual()
+		}
+	}
+
+	c.list.Remove(cacheObj.node)
+
+	c.flushWatchers()
+
+	return nil
+}
+
+func (c *CrossListAOI) Move(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := c.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	pos := obj.GetCoordPos()
+	if !c.isInvalid(pos) {
+		return aoi.ErrPosInvalid
+	}
+
+	cacheObj.pos = pos
+	c.list.Update(cacheObj.node)
+	if !cacheObj.global {
+		c.refreshObject(cacheObj)
+	}
+
+	if cacheObj.wrapWatcher != nil {
+		c.refreshWatcher(cacheObj)
+	}
+
+	c.flushWatchers()
+
+	return nil
+}
+
+func (c *CrossListAOI) AddGlobalMarker(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := c.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	if c.global.Existed(obj.GetAOIID()) {
+		return aoi.ErrObjectExisted
+	}
+
+	c.global.Add(obj)
+
+	cacheObj.global = true
+	for _, watcherObj := range cacheObj.seenBy {
+		c.setSeen(watcherObj, cacheObj, false)
+	}
+
+	c.flushWatchers()
+
+	return nil
+}
+
+func (c *CrossListAOI) RemoveGlobalMarker(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	if !c.global.Existed(obj.GetAOIID()) {
+		return aoi.ErrObjectNotExisted
+	}
+
+	pos := obj.GetCoordPos()
+	if !c.isInvalid(pos) {
+		return aoi.ErrPosInvalid
+	}
+
+	cacheObj := c.objs[obj.GetAOIID()]
+	cacheObj.pos = pos
+	cacheObj.global = false
+	c.list.Update(cacheObj.node)
+	c.refreshObject(cacheObj)
+
+	c.global.Remove(obj)
+
+	c.flushWatchers()
+
+	return nil
+}
+
+func (c *CrossListAOI) Traversal(obj aoi.IObject, cb func(obj aoi.IWatcher) bool) {
+	if obj == nil {
+		return
+	}
+
+	cacheObject, ok := c.objs[obj.GetAOIID()]
+	if !ok {
+		return
+	}
+
+	// 全局对象, 直接遍历所有的watcher就可以
+	if c.global.Existed(obj.GetAOIID()) {
+		c.global.Traversal(obj, cb)
+		return
+	}
+
+	calledWatchers := make(map[string]bool)
+	for _, groupID := range cacheObject.groups {
+		c.TraversalGroup(obj, groupID, func(watcher aoi.IWatcher) bool {
+			if _, called := calledWatchers[watcher.GetAOIID()]; !called {
+				calledWatchers[watcher.GetAOIID()] = true
+				return cb(watcher)
+			}
+			return true
+		})
+	}
+
+	for _, watcherObj := range cacheObject.seenBy {
+		if _, called := calledWatchers[watcherObj.obj.GetAOIID()]; !called {
+			if !cb(watcherObj.wrapWatcher) {
+				return
+			}
+		}
+	}
+}
+
+func (c *CrossListAOI) CreateGroup(objs []aoi.IObject) (int, error) {
+	if len(objs) == 0 {
+		return 0, aoi.ErrObjectInvalid
+	}
+
+	// 检查所有objs是否在AOI中
+	for _, o := range objs {
+		if _, ok := c.objs[o.GetAOIID()]; !ok {
+			return 0, aoi.ErrObjectNotExisted
+		}
+	}
+
+	// 构建群组
+	group := tower.NewTower()
+	c.groupIDSeed++
+	c.groups[c.groupIDSeed] = group
+	for _, o := range objs {
+		group.Add(o)
+
+		cacheObj := c.objs[o.GetAOIID()]
+		cacheObj.groups = append(cacheObj.groups, c.groupIDSeed)
+
+		if cacheObj.wrapWatcher != nil {
+			group.AddWatcher(cacheObj.wrapWatcher)
+		}
+	}
+
+	c.flushWatchers()
+
+	return c.groupIDSeed, nil
+}
+
+func (c *CrossListAOI) DestroyGroup(groupID int) error {
+	group, ok := c.groups[groupID]
+	if !ok {
+		return aoi.ErrGroupNotExisted
+	}
+
+	for _, o := range group.GetObjs() {
+		cacheObj := c.objs[o.GetAOIID()]
+		cacheObj.groups = removeGroupID(cacheObj.groups, groupID)
+	}
+
+	group.Clear()
+	delete(c.groups, groupID)
+
+	c.flushWatchers()
+
+	return nil
+}
+
+func (c *CrossListAOI) AddToGroup(obj aoi.IObject, groupID int) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := c.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	group, ok := c.groups[groupID]
+	if !ok {
+		return aoi.ErrGroupNotExisted
+	}
+
+	if group.Existed(obj.GetAOIID()) {
+		return nil
+	}
+
+	group.Add(obj)
+	if cacheObj.wrapWatcher != nil {
+		group.AddWatcher(cacheObj.wrapWatcher)
+	}
+
+	cacheObj.groups = append(cacheObj.groups, groupID)
+
+	c.flushWatchers()
+
+	return nil
+}
+
+func (c *CrossListAOI) RemoveFromGroup(obj aoi.IObject, groupID int) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := c.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	group, ok := c.groups[groupID]
+	if !ok {
+		return aoi.ErrGroupNotExisted
+	}
+
+	if !group.Existed(obj.GetAOIID()) {
+		return nil
+	}
+
+	group.Remove(obj)
+	if cacheObj.wrapWatcher != nil {
+		group.RemoveWatcher(cacheObj.wrapWatcher)
+	}
+
+	cacheObj.groups = removeGroupID(cacheObj.groups, groupID)
+
+	if group.GetWatchersLen() == 0 && group.GetObjsLen() == 0 {
+		delete(c.groups, groupID)
+	}
+
+	c.flushWatchers()
+
+	return nil
+}
+
+func (c *CrossListAOI) TraversalGroup(obj aoi.IObject, groupID int, cb func(watcher aoi.IWatcher) bool) {
+	if obj == nil {
+		return
+	}
+
+	if _, ok := c.objs[obj.GetAOIID()]; !ok {
+		return
+	}
+
+	group, ok := c.groups[groupID]
+	if !ok {
+		return
+	}
+
+	group.Traversal(obj, cb)
+}
+
+// refreshObject 对象位置变化后, 重新判断哪些watcher能看到它
+// 任何watcher都可能看到这个对象, 只能按最大视野扫描; 对象自己能看到什么由refreshWatcher按自己的视野扫描
+func (c *CrossListAOI) refreshObject(cacheObj *_CacheObject) {
+	for _, watcherObj := range cacheObj.seenBy {
+		if !c.inVisual(watcherObj, cacheObj.pos) {
+			c.setSeen(watcherObj, cacheObj, false)
+		}
+	}
+
+	if c.maxVisual <= 0 {
+		return
+	}
+	c.list.Around(cacheObj.node, c.maxVisual, func(watcherObj *_CacheObject) {
+		if watcherObj.wrapWatcher != nil && c.inVisual(watcherObj, cacheObj.pos) {
+			c.setSeen(watcherObj, cacheObj, true)
+		}
+	})
+}
+
+// refreshWatcher watcher位置变化后, 重新计算它视野内的对象
+func (c *CrossListAOI) refreshWatcher(watcherObj *_CacheObject) {
+	for _, o := range watcherObj.sees {
+		if !c.inVisual(watcherObj, o.pos) {
+			c.setSeen(watcherObj, o, false)
+		}
+	}
+
+	visual := watcherObj.wrapWatcher.GetVisual()
+	if visual <= 0 {
+		return
+	}
+	c.list.Around(watcherObj.node, visual, func(o *_CacheObject) {
+		if !o.global && c.inVisual(watcherObj, o.pos) {
+			c.setSeen(watcherObj, o, true)
+		}
+	})
+}
+
+func (c *CrossListAOI) inVisual(watcherObj *_CacheObject, pos linemath.Vector2) bool {
+	return pos.Sub(watcherObj.pos).Len() <= watcherObj.wrapWatcher.GetVisual()
+}
+
+// setSeen 修改watcher对对象的空间可见性, 重复设置忽略
+func (c *CrossListAOI) setSeen(watcherObj *_CacheObject, cacheObj *_CacheObject, seen bool) {
+	watcherID, id := watcherObj.obj.GetAOIID(), cacheObj.obj.GetAOIID()
+	if _, ok := cacheObj.seenBy[watcherID]; ok == seen {
+		return
+	}
+
+	if seen {
+		cacheObj.seenBy[watcherID] = watcherObj
+		watcherObj.sees[id] = cacheObj
+		watcherObj.wrapWatcher.OnObjectEnter(cacheObj.obj)
+	} else {
+		delete(cacheObj.seenBy, watcherID)
+		delete(watcherObj.sees, id)
+		watcherObj.wrapWatcher.OnObjectLeave(cacheObj.obj)
+	}
+}
+
+func (c *CrossListAOI) resetMaxVisual() {
+	c.maxVisual = 0
+	for _, watcherObj := range c.watchers {
+		if visual := watcherObj.wrapWatcher.GetVisual(); visual > c.maxVisual {
+			c.maxVisual = visual
+		}
+	}
+}
+
+func (c *CrossListAOI) isInvalid(pos linemath.Vector2) bool {
+	if pos.X < c.minPos.X || pos.X > c.maxPos.X || pos.Y < c.minPos.Y || pos.Y > c.maxPos.Y {
+		return false
+	}
+
+	return true
+}
+
+func (c *CrossListAOI) notifyDirty(ww *tower.WrapWatcher) {
+	c.dirtyWatchers[ww.GetAOIID()] = ww
+}
+
+func (c *CrossListAOI) flushWatchers() {
+	for id, w := range c.dirtyWatchers {
+		w.Flush()
+		delete(c.dirtyWatchers, id)
+	}
+}
+
+func removeGroupID(groups []int, groupID int) []int {
+	for i := range groups {
+		if groups[i] == groupID {
+			return append(groups[:i], groups[i+1:]...)
+		}
+	}
+
+	return groups
+}
@@ -0,0 +1,197 @@
+package crosslistaoi
+
+import (
+	"aoi"
+	"aoi/aoitest"
+	"aoi/base/linemath"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func newTestAOI(t testing.TB) *CrossListAOI {
+	ca, err := New(&Config{
+		MinPos: linemath.Vector2{X: -100, Y: -100},
+		MaxPos: linemath.Vector2{X: 100, Y: 100},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ca.(*CrossListAOI)
+}
+
+func TestCrossList_Order(t *testing.T) {
+	ca := newTestAOI(t)
+
+	objs := make([]*aoi.TestMarker, 0, 50)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		o := &aoi.TestMarker{ID: fmt.Sprintf("obj:%d", i), Pos: linemath.Vector2{X: r.Float32() * 100, Y: r.Float32() * 100}}
+		ca.AddToAOI(o)
+		objs = append(objs, o)
+	}
+	for i := 0; i < 200; i++ {
+		o := objs[r.Intn(len(objs))]
+		o.Pos = linemath.Vector2{X: r.Float32() * 100, Y: r.Float32() * 100}
+		ca.Move(o)
+	}
+	for _, o := range objs[:25] {
+		ca.RemoveFromAOI(o)
+	}
+
+	for axis := axisX; axis <= axisY; axis++ {
+		count := 0
+		for n := ca.list.head[axis].next[axis]; n != nil; n = n.next[axis] {
+			if n.prev[axis].next[axis] != n {
+				t.Fatal("broken link")
+			}
+			if n.prev[axis] != ca.list.head[axis] && n.prev[axis].coord(axis) > n.coord(axis) {
+				t.Fatal("list not sorted", axis)
+			}
+			count++
+		}
+		if count != 25 {
+			t.Fatal("list length", axis, count)
+		}
+	}
+}
+
+func TestCrossListAOI_Visual(t *testing.T) {
+	ca := newTestAOI(t)
+
+	w := aoitest.NewWatcher("watcher", linemath.Vector2{}, 10)
+	o := &aoi.TestMarker{ID: "obj", Pos: linemath.Vector2{X: 8, Y: 0}}
+	corner := &aoi.TestMarker{ID: "corner", Pos: linemath.Vector2{X: 8, Y: 8}}
+	ca.AddToAOI(o)
+	ca.AddToAOI(w)
+	ca.AddToAOI(corner)
+	if !w.Sees("obj") || !w.Sees("watcher") || w.Sees("corner") {
+		t.Fatal("visible", w.Visible())
+	}
+
+	w.Pos = linemath.Vector2{X: 8, Y: 5}
+	ca.Move(w)
+	if !w.Sees("obj") || !w.Sees("corner") {
+		t.Fatal("watcher moved", w.Visible())
+	}
+
+	o.Pos = linemath.Vector2{X: 50, Y: 50}
+	ca.Move(o)
+	if w.Sees("obj") {
+		t.Fatal("obj moved out")
+	}
+
+	ca.AddGlobalMarker(o)
+	if !w.Sees("obj") {
+		t.Fatal("global marker should be visible")
+	}
+	ca.RemoveGlobalMarker(o)
+	if w.Sees("obj") {
+		t.Fatal("global marker removed")
+	}
+
+	groupID, _ := ca.CreateGroup([]aoi.IObject{w, o})
+	if !w.Sees("obj") {
+		t.Fatal("group member should be visible")
+	}
+	ca.DestroyGroup(groupID)
+	if w.Sees("obj") {
+		t.Fatal("group destroyed")
+	}
+
+	if ca.AddToAOI(&aoi.TestMarker{ID: "out", Pos: linemath.Vector2{X: 1000}}) != aoi.ErrPosInvalid {
+		t.Fatal("pos invalid")
+	}
+
+	ca.RemoveFromAOI(w)
+	if len(w.Visible()) != 0 {
+		t.Fatal("removed watcher still sees", w.Visible())
+	}
+}
+
+func TestCrossListAOI_Random(t *testing.T) {
+	ca := newTestAOI(t)
+	r := rand.New(rand.NewSource(1))
+	randPos := func() linemath.Vector2 {
+		return linemath.Vector2{X: r.Float32()*200 - 100, Y: r.Float32()*200 - 100}
+	}
+
+	var watchers []*aoitest.Watcher
+	var all []aoi.IObject
+	for i := 0; i < 100; i++ {
+		if i%4 == 0 {
+			w := aoitest.NewWatcher(fmt.Sprintf("watcher:%d", i), randPos(), 5+r.Float32()*30)
+			watchers = append(watchers, w)
+			all = append(all, w)
+			ca.AddToAOI(w)
+		} else {
+			o := &aoi.TestMarker{ID: fmt.Sprintf("obj:%d", i), Pos: randPos()}
+			all = append(all, o)
+			ca.AddToAOI(o)
+		}
+	}
+
+	for i := 0; i < 2000; i++ {
+		switch o := all[r.Intn(len(all))].(type) {
+		case *aoitest.Watcher:
+			o.Pos = randPos()
+			ca.Move(o)
+		case *aoi.TestMarker:
+			o.Pos = randPos()
+			ca.Move(o)
+		}
+	}
+
+	for _, w := range watchers {
+		for _, o := range all {
+			in := o.GetCoordPos().Sub(w.Pos).Len() <= w.Visual
+			if in != w.Sees(o.GetAOIID()) {
+				t.Fatal(w.ID, "visible mismatch", o.GetAOIID(), in)
+			}
+		}
+	}
+}
+
+func BenchmarkCrossListAOI_20000_1000_Move(b *testing.B) {
+	b.ReportAllocs()
+
+	ca, err := New(&Config{
+		MinPos: linemath.Vector2{},
+		MaxPos: linemath.Vector2{X: 8000, Y: 8000},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	objs := make(map[int]*aoi.TestMarker)
+	for i := 0; i < 20000; i++ {
+		o := &aoi.TestMarker{ID: fmt.Sprintf("obj:%d", i)}
+		o.Pos.X = rand.Float32() * 8000
+		o.Pos.Y = rand.Float32() * 8000
+		ca.AddToAOI(o)
+
+		objs[i] = o
+	}
+
+	for i := 0; i < 1000; i++ {
+		w := &aoi.TestWatcher{ID: fmt.Sprintf("watcher:%d", i), Visual: 100}
+		w.Pos.X = rand.Float32() * 8000
+		w.Pos.Y = rand.Float32() * 8000
+		ca.AddToAOI(w)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index := rand.Intn(20000)
+		objs[index].Pos.X = linemath.Clamp32(objs[index].Pos.X+rand.Float32()*20-10, 0, 8000)
+		objs[index].Pos.Y = linemath.Clamp32(objs[index].Pos.Y+rand.Float32()*20-10, 0, 8000)
+		ca.Move(objs[index])
+	}
+}
+
+func TestCrossListAOI_Conformance(t *testing.T) {
+	aoitest.RunConformance(t, aoitest.Factory{New: func() (aoi.IAOI, error) {
+		return New(&Config{MinPos: aoitest.MinPos, MaxPos: aoitest.MaxPos})
+	}})
+}
@@ -1,17 +1,28 @@
 package defaultaoi
 
-import "aoi"
+import (
+	"aoi"
+	"aoi/base/linemath"
+	"sort"
+)
 
 // _DefaultAOI 最基础的AOI, 全局AOI
+// 所有watcher都能看到所有对象, 移动, 全局对象和群组不改变可见性, 只维护状态和错误返回
 type _DefaultAOI struct {
 	objs     map[string]aoi.IObject
 	watchers map[string]aoi.IWatcher
+	global   map[string]bool
+	groups   map[int]map[string]aoi.IObject
+
+	groupIDSeed int
 }
 
 func New() aoi.IAOI {
 	return &_DefaultAOI{
 		objs:     make(map[string]aoi.IObject),
 		watchers: make(map[string]aoi.IWatcher),
+		global:   make(map[string]bool),
+		groups:   make(map[int]map[string]aoi.IObject),
 	}
 }
 
@@ -56,6 +67,14 @@ func (da *_DefaultAOI) RemoveFromAOI(obj aoi.IObject) error {
 	}
 
 	delete(da.objs, obj.GetAOIID())
+	delete(da.global, obj.GetAOIID())
+
+	// 从所有group中移除
+	for groupID, group := range da.groups {
+		if _, ok := group[obj.GetAOIID()]; ok {
+			da.leaveGroup(obj, groupID)
+		}
+	}
 
 	for _, w := range da.watchers {
 		if w.GetAOIID() == obj.GetAOIID() {
@@ -86,16 +105,198 @@ func (da *_DefaultAOI) Traversal(obj aoi.IObject, cb func(watcher aoi.IWatcher)
 	}
 
 	for _, w := range da.watchers {
-		cb(w)
+		if !cb(w) {
+			return
+		}
+	}
+}
+
+// Move 所有对象总是可见, 只检查对象
+func (da *_DefaultAOI) Move(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	if _, ok := da.objs[obj.GetAOIID()]; !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	return nil
+}
+
+func (da *_DefaultAOI) AddGlobalMarker(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	if _, ok := da.objs[obj.GetAOIID()]; !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	if da.global[obj.GetAOIID()] {
+		return aoi.ErrObjectExisted
+	}
+
+	da.global[obj.GetAOIID()] = true
+
+	return nil
+}
+
+func (da *_DefaultAOI) RemoveGlobalMarker(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	if !da.global[obj.GetAOIID()] {
+		return aoi.ErrObjectNotExisted
+	}
+
+	delete(da.global, obj.GetAOIID())
+
+	return nil
+}
+
+func (da *_DefaultAOI) CreateGroup(objs []aoi.IObject) (int, error) {
+	if len(objs) == 0 {
+		return 0, aoi.ErrObjectInvalid
+	}
+
+	// 检查所有objs是否在AOI中
+	for _, o := range objs {
+		if o == nil {
+			return 0, aoi.ErrObjectInvalid
+		}
+		if _, ok := da.objs[o.GetAOIID()]; !ok {
+			return 0, aoi.ErrObjectNotExisted
+		}
+	}
+
+	da.groupIDSeed++
+	group := make(map[string]aoi.IObject, len(objs))
+	for _, o := range objs {
+		group[o.GetAOIID()] = o
+	}
+	da.groups[da.groupIDSeed] = group
+
+	return da.groupIDSeed, nil
+}
+
+func (da *_DefaultAOI) DestroyGroup(groupID int) error {
+	if _, ok := da.groups[groupID]; !ok {
+		return aoi.ErrGroupNotExisted
+	}
+
+	delete(da.groups, groupID)
+
+	return nil
+}
+
+func (da *_DefaultAOI) AddToGroup(obj aoi.IObject, groupID int) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	if _, ok := da.objs[obj.GetAOIID()]; !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	group, ok := da.groups[groupID]
+	if !ok {
+		return aoi.ErrGroupNotExisted
+	}
+
+	group[obj.GetAOIID()] = obj
+
+	return nil
+}
+
+func (da *_DefaultAOI) RemoveFromGroup(obj aoi.IObject, groupID int) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	if _, ok := da.objs[obj.GetAOIID()]; !ok {
+		return aoi.ErrObjectNotExisted
 	}
+
+	if _, ok := da.groups[groupID]; !ok {
+		return aoi.ErrGroupNotExisted
+	}
+
+	da.leaveGroup(obj, groupID)
+
+	return nil
 }
 
-func (da *_DefaultAOI) Move(obj aoi.IObject) error                         { return nil }
-func (da *_DefaultAOI) AddGlobalMarker(obj aoi.IObject) error              { return nil }
-func (da *_DefaultAOI) RemoveGlobalMarker(obj aoi.IObject) error           { return nil }
-func (da *_DefaultAOI) CreateGroup([]aoi.IObject) (int, error)             { return 0, nil }
-func (da *_DefaultAOI) DestroyGroup(groupID int) error                     { return nil }
-func (da *_DefaultAOI) AddToGroup(obj aoi.IObject, groupID int) error      { return nil }
-func (da *_DefaultAOI) RemoveFromGroup(obj aoi.IObject, groupID int) error { return nil }
 func (da *_DefaultAOI) TraversalGroup(obj aoi.IObject, groupID int, cb func(watcher aoi.IWatcher) bool) {
+	if obj == nil {
+		return
+	}
+
+	group, ok := da.groups[groupID]
+	if !ok {
+		return
+	}
+
+	if _, ok := group[obj.GetAOIID()]; !ok {
+		return
+	}
+
+	for id := range group {
+		if w, ok := da.watchers[id]; ok && !cb(w) {
+			return
+		}
+	}
+}
+
+// leaveGroup 和其他实现一样, 群组没有成员后自动销毁
+func (da *_DefaultAOI) leaveGroup(obj aoi.IObject, groupID int) {
+	group := da.groups[groupID]
+	delete(group, obj.GetAOIID())
+	if len(group) == 0 {
+		delete(da.groups, groupID)
+	}
+}
+
+// QueryRadius 遍历圆形范围内的对象, cb返回false时停止
+func (da *_DefaultAOI) QueryRadius(pos linemath.Vector2, radius float32, cb func(obj aoi.IObject) bool) {
+	for _, o := range da.objs {
+		if o.GetCoordPos().Sub(pos).Len() <= radius && !cb(o) {
+			return
+		}
+	}
+}
+
+// QueryRect 遍历矩形范围内的对象, cb返回false时停止
+func (da *_DefaultAOI) QueryRect(minPos, maxPos linemath.Vector2, cb func(obj aoi.IObject) bool) {
+	for _, o := range da.objs {
+		p := o.GetCoordPos()
+		if p.X < minPos.X || p.X > maxPos.X || p.Y < minPos.Y || p.Y > maxPos.Y {
+			continue
+		}
+		if !cb(o) {
+			return
+		}
+	}
+}
+
+// QueryKNearest 距离最近的k个对象, 由近到远排列
+func (da *_DefaultAOI) QueryKNearest(pos linemath.Vector2, k int) []aoi.IObject {
+	if k <= 0 {
+		return nil
+	}
+
+	objs := make([]aoi.IObject, 0, len(da.objs))
+	for _, o := range da.objs {
+		objs = append(objs, o)
+	}
+
+	sort.Slice(objs, func(i, j int) bool {
+		return objs[i].GetCoordPos().Sub(pos).Len() < objs[j].GetCoordPos().Sub(pos).Len()
+	})
+	if len(objs) > k {
+		objs = objs[:k]
+	}
+
+	return objs
 }
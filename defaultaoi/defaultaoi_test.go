@@ -2,6 +2,8 @@ package defaultaoi
 
 import (
 	"aoi"
+	"aoi/aoitest"
+	"aoi/base/linemath"
 	"testing"
 )
 
@@ -23,3 +25,45 @@ func TestDefaultAOI_AddRemove(t *testing.T) {
 	t.Log("RemoveFromAOI o2")
 	da.RemoveFromAOI(o2)
 }
+
+func TestDefaultAOI_Query(t *testing.T) {
+	da := New()
+
+	da.AddToAOI(&aoi.TestMarker{ID: "obj:1", Pos: linemath.Vector2{X: 1, Y: 1}})
+	da.AddToAOI(&aoi.TestMarker{ID: "obj:2", Pos: linemath.Vector2{X: 5, Y: 5}})
+	da.AddToAOI(&aoi.TestMarker{ID: "obj:3", Pos: linemath.Vector2{X: 10, Y: 10}})
+
+	q := da.(aoi.IAOIQuery)
+
+	count := 0
+	q.QueryRadius(linemath.Vector2{}, 8, func(obj aoi.IObject) bool {
+		count++
+		return true
+	})
+	if count != 2 {
+		t.Fatal("QueryRadius", count)
+	}
+
+	count = 0
+	q.QueryRect(linemath.Vector2{X: 4, Y: 4}, linemath.Vector2{X: 10, Y: 10}, func(obj aoi.IObject) bool {
+		count++
+		return true
+	})
+	if count != 2 {
+		t.Fatal("QueryRect", count)
+	}
+
+	nearest := q.QueryKNearest(linemath.Vector2{X: 9, Y: 9}, 2)
+	if len(nearest) != 2 || nearest[0].GetAOIID() != "obj:3" || nearest[1].GetAOIID() != "obj:2" {
+		t.Fatal("QueryKNearest", nearest)
+	}
+}
+
+func TestDefaultAOI_Conformance(t *testing.T) {
+	aoitest.RunConformance(t, aoitest.Factory{
+		New: func() (aoi.IAOI, error) {
+			return New(), nil
+		},
+		Unlimited: true,
+	})
+}
@@ -28,6 +28,22 @@ type IAOI interface {
 	TraversalGroup(obj IObject, groupID int, cb func(watcher IWatcher) bool)
 }
 
+// IAOIQuery 空间查询接口, 查询位置不需要有对象加入AOI
+type IAOIQuery interface {
+	// QueryRadius 遍历圆形范围内的对象, cb返回false时停止
+	QueryRadius(pos linemath.Vector2, radius float32, cb func(obj IObject) bool)
+	// QueryRect 遍历矩形范围内的对象, cb返回false时停止
+	QueryRect(minPos, maxPos linemath.Vector2, cb func(obj IObject) bool)
+	// QueryKNearest 距离最近的k个对象, 由近到远排列
+	QueryKNearest(pos linemath.Vector2, k int) []IObject
+}
+
+// IAOIBatch 批量操作接口, Begin与Commit之间的操作只在Commit时统一通知watcher
+type IAOIBatch interface {
+	Begin()
+	Commit()
+}
+
 type IObject interface {
 	GetAOIID() string
 	GetCoordPos() linemath.Vector2
@@ -0,0 +1,94 @@
+package hexaoi
+
+import (
+	"aoi/base/linemath"
+	"math"
+)
+
+var sqrt3 = math.Sqrt(3)
+
+// _Hex 六边形格子的轴坐标(axial coordinates), 尖顶朝上
+type _Hex struct {
+	Q, R int
+}
+
+// Distance 两个格子之间的六边形距离, 即中间隔了几圈
+func (h _Hex) Distance(o _Hex) int {
+	dq := h.Q - o.Q
+	dr := h.R - o.R
+	return (abs(dq) + abs(dr) + abs(dq+dr)) / 2
+}
+
+// toOffset 转换成奇数行右移的偏移坐标(odd-r), 用来索引二维数组
+func (h _Hex) toOffset() (int, int) {
+	col := h.Q + (h.R-(h.R&1))/2
+	return col, h.R
+}
+
+func fromOffset(col, row int) _Hex {
+	return _Hex{Q: col - (row-(row&1))/2, R: row}
+}
+
+// pixelToHex 相对于原点的坐标所在的格子, size是格子中心到顶点的距离
+func pixelToHex(pos linemath.Vector2, size float32) _Hex {
+	x, y := float64(pos.X), float64(pos.Y)
+	q := (sqrt3/3*x - y/3) / float64(size)
+	r := (2.0 / 3 * y) / float64(size)
+
+	return cubeRound(q, r)
+}
+
+// hexToPixel 格子中心相对于原点的坐标
+func hexToPixel(h _Hex, size float32) linemath.Vector2 {
+	x := float64(size) * sqrt3 * (float64(h.Q) + float64(h.R)/2)
+	y := float64(size) * 1.5 * float64(h.R)
+	return linemath.Vector2{X: float32(x), Y: float32(y)}
+}
+
+func cubeRound(q, r float64) _Hex {
+	s := -q - r
+	rq, rr, rs := math.Round(q), math.Round(r), math.Round(s)
+	dq, dr, ds := math.Abs(rq-q), math.Abs(rr-r), math.Abs(rs-s)
+	if dq > dr && dq > ds {
+		rq = -rr - rs
+	} else if dr > ds {
+		rr = -rq - rs
+	}
+
+	return _Hex{Q: int(rq), R: int(rr)}
+}
+
+// traversalRange 遍历距离center不超过n的所有格子
+func traversalRange(center _Hex, n int, cb func(h _Hex)) {
+	for dq := -n; dq <= n; dq++ {
+		start, end := -n, n
+		if -dq-n > start {
+			start = -dq - n
+		}
+		if -dq+n < end {
+			end = -dq + n
+		}
+		for dr := start; dr <= end; dr++ {
+			cb(_Hex{Q: center.Q + dq, R: center.R + dr})
+		}
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+
+	return v
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+
+	return v
+}
@@ -0,0 +1,489 @@
+package hexaoi
+
+import (
+	"aoi"
+	"aoi/base/linemath"
+	"aoi/internal/tower"
+	"errors"
+	"math"
+
+	log "github.com/cihub/seelog"
+)
+
+// HexAOI 基于六边形格子的AOI实现, 每个格子是一个灯塔
+// watcher关注六边形距离不超过视野圈数的格子, 覆盖范围是六边形, 不会像正方形那样在对角线方向多出视野
+type HexAOI struct {
+	// 格子相关, 按偏移坐标存储, 列下标偏移1
+	cells          [][]*tower.Tower
+	minPos, maxPos linemath.Vector2 // 地图边界
+	hexSize        float32          // 格子中心到顶点的距离
+	cols, rows     int
+	objs           map[string]*_CacheObject
+
+	// 全局列表
+	global *tower.Tower
+
+	// 群组
+	groups      map[int]*tower.Tower
+	groupIDSeed int
+
+	// 缓存需要清理的watcher
+	dirtyWatchers map[string]*tower.WrapWatcher
+}
+
+type _CacheObject struct {
+	hex         _Hex
+	wrapWatcher *tower.WrapWatcher
+	groups      []int
+}
+
+var (
+	ErrHexConfigInvalid = errors.New("config invalid")
+)
+
+type Config struct {
+	MinPos linemath.Vector2
+	MaxPos linemath.Vector2
+
+	// HexSize 格子中心到顶点的距离, 相邻格子中心相距 HexSize*sqrt(3)
+	HexSize float32
+}
+
+func New(cfg *Config) (aoi.IAOI, error) {
+	if cfg.MinPos.X > cfg.MaxPos.X || cfg.MinPos.Y > cfg.MaxPos.Y || cfg.HexSize <= 0 {
+		return nil, ErrHexConfigInvalid
+	}
+
+	ha := &HexAOI{
+		minPos:        cfg.MinPos,
+		maxPos:        cfg.MaxPos,
+		hexSize:       cfg.HexSize,
+		objs:          make(map[string]*_CacheObject),
+		global:        tower.NewTower(),
+		groups:        make(map[int]*tower.Tower),
+		dirtyWatchers: make(map[string]*tower.WrapWatcher),
+	}
+
+	// 边界上的点所在格子的中心可能在地图外, 列向左多留一列, 行和列向后多留一行
+	ha.cols = int(math.Ceil(float64(cfg.MaxPos.X-cfg.MinPos.X)/(float64(cfg.HexSize)*sqrt3))) + 2
+	ha.rows = int(math.Ceil(float64((cfg.MaxPos.Y-cfg.MinPos.Y)/(cfg.HexSize*1.5)))) + 1
+
+	ha.cells = make([][]*tower.Tower, ha.cols)
+	for col := 0; col < ha.cols; col++ {
+		ha.cells[col] = make([]*tower.Tower, ha.rows)
+		for row := 0; row < ha.rows; row++ {
+			ha.cells[col][row] = tower.NewTower()
+		}
+	}
+
+	return ha, nil
+}
+
+func (h *HexAOI) AddToAOI(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	if _, ok := h.objs[obj.GetAOIID()]; ok {
+		return aoi.ErrObjectExisted
+	}
+
+	pos := obj.GetCoordPos()
+	if !h.isInvalid(pos) {
+		return aoi.ErrPosInvalid
+	}
+
+	hex := h.transPos(pos)
+	h.getCell(hex).Add(obj)
+	h.objs[obj.GetAOIID()] = &_CacheObject{
+		hex: hex,
+	}
+
+	if w, ok := obj.(aoi.IWatcher); ok {
+		ww := tower.NewWrapWatcher(w, h.notifyDirty)
+		h.objs[obj.GetAOIID()].wrapWatcher = ww
+		if visual := w.GetVisual(); visual > 0 {
+			h.traversalCellByVisual(hex, h.getHexVisual(visual), func(cell *tower.Tower) {
+				cell.AddWatcher(ww)
+			})
+		}
+
+		h.global.AddWatcher(ww)
+	}
+
+	h.flushWatchers()
+
+	return nil
+}
+
+func (h *HexAOI) RemoveFromAOI(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := h.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+	delete(h.objs, obj.GetAOIID())
+
+	// 从所有group中移除
+	for _, groupID := range cacheObj.groups {
+		if group, existed := h.groups[groupID]; existed {
+			if group.Existed(obj.GetAOIID()) {
+				group.Remove(obj)
+				if cacheObj.wrapWatcher != nil {
+					group.RemoveWatcher(cacheObj.wrapWatcher)
+				}
+
+				if group.GetObjsLen() == 0 && group.GetWatchersLen() == 0 {
+					delete(h.groups, groupID)
+				}
+			} else {
+				log.Debug("奇怪的事情发生了, 检查代码")
+			}
+		}
+	}
+
+	if h.global.Existed(obj.GetAOIID()) {
+		h.global.Remove(obj)
+	} else {
+		h.getCell(cacheObj.hex).Remove(obj)
+	}
+
+	if cacheObj.wrapWatcher != nil {
+		h.global.RemoveWatcher(cacheObj.wrapWatcher)
+		if visual := cacheObj.wrapWatcher.GetVisual(); visual > 0 {
+			h.traversalCellByVisual(cacheObj.hex, h.getHexVisual(visual), func(cell *tower.Tower) {
+				cell.RemoveWatcher(cacheObj.wrapWatcher)
+			})
+		}
+	}
+
+	h.flushWatchers()
+
+	return nil
+}
+
+func (h *HexAOI) Move(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := h.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+	oldHex := cacheObj.hex
+
+	pos := obj.GetCoordPos()
+	if !h.isInvalid(pos) {
+		return aoi.ErrPosInvalid
+	}
+	newHex := h.transPos(pos)
+	if oldHex == newHex {
+		return nil
+	}
+	cacheObj.hex = newHex
+
+	if !h.global.Existed(obj.GetAOIID()) {
+		h.getCell(oldHex).Remove(obj)
+		h.getCell(newHex).Add(obj)
+	}
+
+	if cacheObj.wrapWatcher != nil {
+		if visual := cacheObj.wrapWatcher.GetVisual(); visual > 0 {
+			hexVisual := h.getHexVisual(visual)
+			h.traversalCellByVisual(oldHex, hexVisual, func(cell *tower.Tower) {
+				cell.RemoveWatcher(cacheObj.wrapWatcher)
+			})
+			h.traversalCellByVisual(newHex, hexVisual, func(cell *tower.Tower) {
+				cell.AddWatcher(cacheObj.wrapWatcher)
+			})
+		}
+	}
+
+	h.flushWatchers()
+
+	return nil
+}
+
+func (h *HexAOI) AddGlobalMarker(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := h.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	if h.global.Existed(obj.GetAOIID()) {
+		return aoi.ErrObjectExisted
+	}
+
+	h.getCell(cacheObj.hex).Remove(obj)
+
+	h.global.Add(obj)
+
+	h.flushWatchers()
+
+	return nil
+}
+
+func (h *HexAOI) RemoveGlobalMarker(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	if !h.global.Existed(obj.GetAOIID()) {
+		return aoi.ErrObjectNotExisted
+	}
+
+	pos := obj.GetCoordPos()
+	if !h.isInvalid(pos) {
+		return aoi.ErrPosInvalid
+	}
+
+	// 位置可能变了, watcher的关注范围也要跟着移动
+	h.global.Remove(obj)
+	h.getCell(h.objs[obj.GetAOIID()].hex).Add(obj)
+	h.Move(obj)
+
+	h.flushWatchers()
+
+	return nil
+}
+
+func (h *HexAOI) Traversal(obj aoi.IObject, cb func(obj aoi.IWatcher) bool) {
+	if obj == nil {
+		return
+	}
+
+	cacheObject, ok := h.objs[obj.GetAOIID()]
+	if !ok {
+		return
+	}
+
+	// 全局对象, 直接遍历所有的watcher就可以
+	if h.global.Existed(obj.GetAOIID()) {
+		h.global.Traversal(obj, cb)
+		return
+	}
+
+	calledWatchers := make(map[string]bool)
+	for _, groupID := range cacheObject.groups {
+		h.TraversalGroup(obj, groupID, func(watcher aoi.IWatcher) bool {
+			if _, called := calledWatchers[watcher.GetAOIID()]; !called {
+				calledWatchers[watcher.GetAOIID()] = true
+				return cb(watcher)
+			}
+			return true
+		})
+	}
+
+	for _, w := range h.getCell(cacheObject.hex).GetWatchers() {
+		if _, called := calledWatchers[w.GetAOIID()]; !called {
+			if !cb(w) {
+				return
+			}
+		}
+	}
+}
+
+func (h *HexAOI) CreateGroup(objs []aoi.IObject) (int, error) {
+	if len(objs) == 0 {
+		return 0, aoi.ErrObjectInvalid
+	}
+
+	// 检查所有objs是否在AOI中
+	for _, o := range objs {
+		if _, ok := h.objs[o.GetAOIID()]; !ok {
+			return 0, aoi.ErrObjectNotExisted
+		}
+	}
+
+	// 构建群组
+	group := tower.NewTower()
+	h.groupIDSeed++
+	h.groups[h.groupIDSeed] = group
+	for _, o := range objs {
+		group.Add(o)
+
+		cacheObj := h.objs[o.GetAOIID()]
+		cacheObj.groups = append(cacheObj.groups, h.groupIDSeed)
+
+		if cacheObj.wrapWatcher != nil {
+			group.AddWatcher(cacheObj.wrapWatcher)
+		}
+	}
+
+	h.flushWatchers()
+
+	return h.groupIDSeed, nil
+}
+
+func (h *HexAOI) DestroyGroup(groupID int) error {
+	group, ok := h.groups[groupID]
+	if !ok {
+		return aoi.ErrGroupNotExisted
+	}
+
+	for _, o := range group.GetObjs() {
+		cacheObj := h.objs[o.GetAOIID()]
+		cacheObj.groups = removeGroupID(cacheObj.groups, groupID)
+	}
+
+	group.Clear()
+	delete(h.groups, groupID)
+
+	h.flushWatchers()
+
+	return nil
+}
+
+func (h *HexAOI) AddToGroup(obj aoi.IObject, groupID int) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := h.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	group, ok := h.groups[groupID]
+	if !ok {
+		return aoi.ErrGroupNotExisted
+	}
+
+	if group.Existed(obj.GetAOIID()) {
+		return nil
+	}
+
+	group.Add(obj)
+	if cacheObj.wrapWatcher != nil {
+		group.AddWatcher(cacheObj.wrapWatcher)
+	}
+
+	cacheObj.groups = append(cacheObj.groups, groupID)
+
+	h.flushWatchers()
+
+	return nil
+}
+
+func (h *HexAOI) RemoveFromGroup(obj aoi.IObject, groupID int) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := h.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	group, ok := h.groups[groupID]
+	if !ok {
+		return aoi.ErrGroupNotExisted
+	}
+
+	if !group.Existed(obj.GetAOIID()) {
+		return nil
+	}
+
+	group.Remove(obj)
+	if cacheObj.wrapWatcher != nil {
+		group.RemoveWatcher(cacheObj.wrapWatcher)
+	}
+
+	cacheObj.groups = removeGroupID(cacheObj.groups, groupID)
+
+	if group.GetWatchersLen() == 0 && group.GetObjsLen() == 0 {
+		delete(h.groups, groupID)
+	}
+
+	h.flushWatchers()
+
+	return nil
+}
+
+func (h *HexAOI) TraversalGroup(obj aoi.IObject, groupID int, cb func(watcher aoi.IWatcher) bool) {
+	if obj == nil {
+		return
+	}
+
+	if _, ok := h.objs[obj.GetAOIID()]; !ok {
+		return
+	}
+
+	group, ok := h.groups[groupID]
+	if !ok {
+		return
+	}
+
+	group.Traversal(obj, cb)
+}
+
+func (h *HexAOI) isInvalid(pos linemath.Vector2) bool {
+	if pos.X < h.minPos.X || pos.X > h.maxPos.X || pos.Y < h.minPos.Y || pos.Y > h.maxPos.Y {
+		return false
+	}
+
+	return true
+}
+
+// transPos 坐标所在的格子, 以MinPos为(0, 0)格子的中心
+// 浮点误差可能导致边界上的点算到网格外, 这时取最近的格子
+func (h *HexAOI) transPos(pos linemath.Vector2) _Hex {
+	col, row := pixelToHex(pos.Sub(h.minPos), h.hexSize).toOffset()
+	col = clamp(col, -1, h.cols-2)
+	row = clamp(row, 0, h.rows-1)
+
+	return fromOffset(col, row)
+}
+
+// getCell 格子在地图外时返回nil
+func (h *HexAOI) getCell(hex _Hex) *tower.Tower {
+	col, row := hex.toOffset()
+	col++
+	if col < 0 || col >= h.cols || row < 0 || row >= h.rows {
+		return nil
+	}
+
+	return h.cells[col][row]
+}
+
+// getHexVisual 视野对应的格子圈数, 相邻两圈格子中心相距 HexSize*sqrt(3)
+func (h *HexAOI) getHexVisual(visual float32) int {
+	return int(math.Floor(float64(visual) / (float64(h.hexSize) * sqrt3)))
+}
+
+func (h *HexAOI) traversalCellByVisual(hex _Hex, hexVisual int, cb func(cell *tower.Tower)) {
+	traversalRange(hex, hexVisual, func(hex _Hex) {
+		if cell := h.getCell(hex); cell != nil {
+			cb(cell)
+		}
+	})
+}
+
+func (h *HexAOI) notifyDirty(ww *tower.WrapWatcher) {
+	h.dirtyWatchers[ww.GetAOIID()] = ww
+}
+
+func (h *HexAOI) flushWatchers() {
+	for id, w := range h.dirtyWatchers {
+		w.Flush()
+		delete(h.dirtyWatchers, id)
+	}
+}
+
+func removeGroupID(groups []int, groupID int) []int {
+	for i := range groups {
+		if groups[i] == groupID {
+			return append(groups[:i], groups[i+1:]...)
+		}
+	}
+
+	return groups
+}
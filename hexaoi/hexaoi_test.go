@@ -0,0 +1,165 @@
+package hexaoi
+
+import (
+	"aoi"
+	"aoi/aoitest"
+	"aoi/base/linemath"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func newTestAOI(t *testing.T) *HexAOI {
+	ha, err := New(&Config{
+		MinPos:  linemath.Vector2{X: -50, Y: -50},
+		MaxPos:  linemath.Vector2{X: 50, Y: 50},
+		HexSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ha.(*HexAOI)
+}
+
+// hexPos 格子中心的坐标
+func hexPos(ha *HexAOI, q, r int) linemath.Vector2 {
+	return hexToPixel(_Hex{Q: q, R: r}, ha.hexSize).Add(ha.minPos)
+}
+
+func TestHex_Coord(t *testing.T) {
+	for q := -5; q <= 5; q++ {
+		for r := -5; r <= 5; r++ {
+			hex := _Hex{Q: q, R: r}
+			if got := pixelToHex(hexToPixel(hex, 2), 2); got != hex {
+				t.Fatal("round trip", hex, got)
+			}
+			if col, row := hex.toOffset(); fromOffset(col, row) != hex {
+				t.Fatal("offset", hex, col, row)
+			}
+		}
+	}
+
+	for n := 0; n <= 4; n++ {
+		count := 0
+		traversalRange(_Hex{Q: 3, R: -2}, n, func(h _Hex) {
+			count++
+			if h.Distance(_Hex{Q: 3, R: -2}) > n {
+				t.Fatal("out of range", h)
+			}
+		})
+		if count != 3*n*(n+1)+1 {
+			t.Fatal("range count", n, count)
+		}
+	}
+}
+
+func TestHexAOI_Visual(t *testing.T) {
+	ha := newTestAOI(t)
+
+	// 视野1.8, 正好覆盖一圈格子
+	w := aoitest.NewWatcher("watcher", hexPos(ha, 10, 10), 1.8)
+	neighbour := &aoi.TestMarker{ID: "neighbour", Pos: hexPos(ha, 11, 9)}
+	// 正方形覆盖会包含的对角格子, 六边形距离是2
+	diagonal := &aoi.TestMarker{ID: "diagonal", Pos: hexPos(ha, 11, 11)}
+	ha.AddToAOI(w)
+	ha.AddToAOI(neighbour)
+	ha.AddToAOI(diagonal)
+	if !w.Sees("watcher") || !w.Sees("neighbour") || w.Sees("diagonal") {
+		t.Fatal("visible", w.Visible())
+	}
+
+	w.Pos = hexPos(ha, 11, 10)
+	ha.Move(w)
+	if !w.Sees("neighbour") || !w.Sees("diagonal") {
+		t.Fatal("watcher moved", w.Visible())
+	}
+
+	neighbour.Pos = hexPos(ha, 20, 20)
+	ha.Move(neighbour)
+	if w.Sees("neighbour") {
+		t.Fatal("neighbour moved out")
+	}
+
+	ha.AddGlobalMarker(neighbour)
+	if !w.Sees("neighbour") {
+		t.Fatal("global marker should be visible")
+	}
+	ha.RemoveGlobalMarker(neighbour)
+	if w.Sees("neighbour") {
+		t.Fatal("global marker removed")
+	}
+
+	groupID, _ := ha.CreateGroup([]aoi.IObject{w, neighbour})
+	if !w.Sees("neighbour") {
+		t.Fatal("group member should be visible")
+	}
+	ha.DestroyGroup(groupID)
+	if w.Sees("neighbour") {
+		t.Fatal("group destroyed")
+	}
+
+	if ha.AddToAOI(&aoi.TestMarker{ID: "out", Pos: linemath.Vector2{X: 1000}}) != aoi.ErrPosInvalid {
+		t.Fatal("pos invalid")
+	}
+
+	ha.RemoveFromAOI(w)
+	if len(w.Visible()) != 0 {
+		t.Fatal("removed watcher still sees", w.Visible())
+	}
+}
+
+func TestHexAOI_Random(t *testing.T) {
+	ha := newTestAOI(t)
+	r := rand.New(rand.NewSource(1))
+	randPos := func() linemath.Vector2 {
+		return linemath.Vector2{X: r.Float32()*100 - 50, Y: r.Float32()*100 - 50}
+	}
+
+	var watchers []*aoitest.Watcher
+	var all []aoi.IObject
+	for i := 0; i < 200; i++ {
+		if i%4 == 0 {
+			w := aoitest.NewWatcher(fmt.Sprintf("watcher:%d", i), randPos(), r.Float32()*20)
+			watchers = append(watchers, w)
+			all = append(all, w)
+			ha.AddToAOI(w)
+		} else {
+			o := &aoi.TestMarker{ID: fmt.Sprintf("obj:%d", i), Pos: randPos()}
+			all = append(all, o)
+			ha.AddToAOI(o)
+		}
+	}
+
+	// 包括地图边界上的点
+	edges := []linemath.Vector2{{X: -50, Y: -50}, {X: 50, Y: 50}, {X: -50, Y: 50}, {X: 50, Y: -50}}
+	for i := 0; i < 3000; i++ {
+		pos := randPos()
+		if i%100 == 0 {
+			pos = edges[i/100%len(edges)]
+		}
+		switch o := all[r.Intn(len(all))].(type) {
+		case *aoitest.Watcher:
+			o.Pos = pos
+			ha.Move(o)
+		case *aoi.TestMarker:
+			o.Pos = pos
+			ha.Move(o)
+		}
+	}
+
+	for _, w := range watchers {
+		for _, o := range all {
+			in := ha.transPos(w.Pos).Distance(ha.transPos(o.GetCoordPos())) <= ha.getHexVisual(w.Visual)
+			if in != w.Sees(o.GetAOIID()) {
+				t.Fatal(w.ID, "visible mismatch", o.GetAOIID(), in)
+			}
+		}
+	}
+}
+
+func TestHexAOI_Conformance(t *testing.T) {
+	aoitest.RunConformance(t, aoitest.Factory{New: func() (aoi.IAOI, error) {
+		return New(&Config{MinPos: aoitest.MinPos, MaxPos: aoitest.MaxPos, HexSize: 5})
+	}})
+}
@@ -0,0 +1,130 @@
+package tower
+
+import (
+	"aoi"
+	"sync"
+
+	log "github.com/cihub/seelog"
+)
+
+// WrapWatcher 合并同一个对象从多个灯塔进出的通知, Flush时才回调真正的watcher
+type WrapWatcher struct {
+	aoi.IWatcher
+
+	notify func(watcher *WrapWatcher)
+
+	mu   sync.Mutex // 保护info, 分片加锁时不同分片的操作可能同时修改同一个watcher
+	info map[string]*_CacheInfo
+
+	flushMu sync.Mutex // 保证同一个watcher的回调串行
+}
+
+type _CacheInfo struct {
+	obj     aoi.IObject
+	count   int
+	entered bool
+}
+
+// NewWrapWatcher 通知数量变化时调用notify, 由使用者决定何时Flush
+func NewWrapWatcher(w aoi.IWatcher, notify func(watcher *WrapWatcher)) *WrapWatcher {
+	wo := &WrapWatcher{}
+	wo.IWatcher = w
+	wo.notify = notify
+	wo.info = make(map[string]*_CacheInfo)
+
+	return wo
+}
+
+func (wo *WrapWatcher) OnObjectEnter(obj aoi.IObject) {
+	wo.mu.Lock()
+	wo.enter(obj)
+	wo.mu.Unlock()
+
+	wo.notify(wo)
+}
+
+func (wo *WrapWatcher) OnObjectLeave(obj aoi.IObject) {
+	wo.mu.Lock()
+	ok := wo.leave(obj)
+	wo.mu.Unlock()
+
+	if ok {
+		wo.notify(wo)
+	}
+}
+
+func (wo *WrapWatcher) OnBatchEnter(objs []aoi.IObject) {
+	if len(objs) == 0 {
+		return
+	}
+
+	wo.mu.Lock()
+	for _, o := range objs {
+		wo.enter(o)
+	}
+	wo.mu.Unlock()
+
+	wo.notify(wo)
+}
+
+func (wo *WrapWatcher) OnBatchLeave(objs []aoi.IObject) {
+	if len(objs) == 0 {
+		return
+	}
+
+	wo.mu.Lock()
+	for _, o := range objs {
+		wo.leave(o)
+	}
+	wo.mu.Unlock()
+
+	wo.notify(wo)
+}
+
+func (wo *WrapWatcher) enter(obj aoi.IObject) {
+	if _, ok := wo.info[obj.GetAOIID()]; !ok {
+		wo.info[obj.GetAOIID()] = &_CacheInfo{obj: obj}
+	}
+	wo.info[obj.GetAOIID()].count++
+}
+
+func (wo *WrapWatcher) leave(obj aoi.IObject) bool {
+	info, ok := wo.info[obj.GetAOIID()]
+	if !ok {
+		log.Debug("非常奇怪的事情发生了, 检查代码和日志!")
+		return false
+	}
+
+	info.count--
+	return true
+}
+
+// Flush 把合并后的进出回调给真正的watcher, 回调时不持有info的锁
+func (wo *WrapWatcher) Flush() {
+	wo.flushMu.Lock()
+	defer wo.flushMu.Unlock()
+
+	enterList := make([]aoi.IObject, 0, 1)
+	leaveList := make([]aoi.IObject, 0, 1)
+
+	wo.mu.Lock()
+	for _, info := range wo.info {
+		if info.count > 0 && !info.entered {
+			enterList = append(enterList, info.obj)
+			info.entered = true
+		} else if info.count <= 0 {
+			if info.entered {
+				leaveList = append(leaveList, info.obj)
+			}
+			delete(wo.info, info.obj.GetAOIID())
+		}
+	}
+	wo.mu.Unlock()
+
+	if len(enterList) > 0 {
+		wo.IWatcher.OnBatchEnter(enterList)
+	}
+	if len(leaveList) > 0 {
+		wo.IWatcher.OnBatchLeave(leaveList)
+	}
+}
@@ -1,7 +1,770 @@
 package layeraoi
 
-import "testing"
+import (
+	"aoi"
+	"aoi/aoitest"
+	"aoi/base/linemath"
+	"bytes"
+	"fmt"
+	"image/png"
+	"math"
+	"math/rand"
+	"testing"
+)
 
-func TestLayerAOI_Base(t *testing.T)  {
+type testLayerMarker struct {
+	aoi.TestMarker
+	bits uint64
+}
 
-}
\ No newline at end of file
+func (m *testLayerMarker) GetLayerBits() uint64 {
+	return m.bits
+}
+
+// testLayerWatcher 所有层使用同一个视野, 记录当前视野内的对象
+type testLayerWatcher struct {
+	aoi.TestWatcher
+	bits    uint64
+	visible map[string]bool
+}
+
+func newTestLayerWatcher(id string, visual float32, pos linemath.Vector2) *testLayerWatcher {
+	return &testLayerWatcher{
+		TestWatcher: aoi.TestWatcher{ID: id, Visual: visual, Pos: pos},
+		bits:        1,
+		visible:     make(map[string]bool),
+	}
+}
+
+func (w *testLayerWatcher) GetLayerBits() uint64 {
+	return w.bits
+}
+
+func (w *testLayerWatcher) GetLayerVisual(layer int) float32 {
+	return w.Visual
+}
+
+func (w *testLayerWatcher) OnLayerObjectEnter(obj aoi.IObject, layer int) {}
+
+func (w *testLayerWatcher) OnLayerObjectLeave(obj aoi.IObject, layer int) {}
+
+func (w *testLayerWatcher) OnLayerBatchEnter(objs []aoi.IObject, layer int) {
+	for _, o := range objs {
+		w.visible[o.GetAOIID()] = true
+	}
+}
+
+func (w *testLayerWatcher) OnLayerBatchLeave(objs []aoi.IObject, layer int) {
+	for _, o := range objs {
+		delete(w.visible, o.GetAOIID())
+	}
+}
+
+func TestLayerAOI_Base(t *testing.T) {
+
+}
+
+func TestTowerAOILayer_Query(t *testing.T) {
+	ta, err := NewTowerAoi(&Config{
+		MinPos:    linemath.Vector2{X: -10, Y: -10},
+		MaxPos:    linemath.Vector2{X: 10, Y: 10},
+		TowerSize: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		ta.AddToAOI(&testLayerMarker{TestMarker: aoi.TestMarker{ID: fmt.Sprintf("obj:%d", i), Pos: linemath.Vector2{X: float32(i), Y: 0}}, bits: 1})
+	}
+
+	// 额外的稀疏子层
+	tal := ta.(*TowerAOILayer)
+	tal.AddLayer(tal.nextLayerID(), MapLayer)
+	other := &testLayerMarker{TestMarker: aoi.TestMarker{ID: "other", Pos: linemath.Vector2{X: -5, Y: -5}}, bits: 1}
+	x, y := tal.transPos(other.Pos)
+	tal.towerLayers[tal.layerID].getTower(x, y).Add(other, tal.GetLayer())
+
+	q := ta.(aoi.IAOIQuery)
+
+	var ids []string
+	q.QueryRadius(linemath.Vector2{X: 0.5, Y: 0}, 1.5, func(obj aoi.IObject) bool {
+		ids = append(ids, obj.GetAOIID())
+		return true
+	})
+	if len(ids) != 3 {
+		t.Fatal("QueryRadius", ids)
+	}
+
+	count := 0
+	q.QueryRect(linemath.Vector2{X: -10, Y: -10}, linemath.Vector2{X: 3, Y: 0}, func(obj aoi.IObject) bool {
+		count++
+		return true
+	})
+	if count != 5 {
+		t.Fatal("QueryRect", count)
+	}
+
+	nearest := q.QueryKNearest(linemath.Vector2{X: -6, Y: -6}, 2)
+	if len(nearest) != 2 || nearest[0].GetAOIID() != "other" || nearest[1].GetAOIID() != "obj:0" {
+		t.Fatal("QueryKNearest", nearest)
+	}
+
+	// 地图外很远的位置从边缘的灯塔开始找, 不能逐圈扩展过去
+	nearest = q.QueryKNearest(linemath.Vector2{X: 1e7, Y: 1}, 2)
+	if len(nearest) != 2 || nearest[0].GetAOIID() != "obj:9" || nearest[1].GetAOIID() != "obj:8" {
+		t.Fatal("QueryKNearest far away", nearest)
+	}
+	if nearest := q.QueryKNearest(linemath.Vector2{X: float32(math.NaN())}, 1); len(nearest) != 0 {
+		t.Fatal("QueryKNearest NaN", nearest)
+	}
+}
+
+func TestTowerAOILayer_UpdateVisual(t *testing.T) {
+	ta, err := NewTowerAoi(&Config{
+		MinPos:    linemath.Vector2{X: -100, Y: -100},
+		MaxPos:    linemath.Vector2{X: 100, Y: 100},
+		TowerSize: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tal := ta.(*TowerAOILayer)
+
+	w := newTestLayerWatcher("watcher", 10, linemath.Vector2{X: 5, Y: 5})
+	near := &testLayerMarker{TestMarker: aoi.TestMarker{ID: "near", Pos: linemath.Vector2{X: 8, Y: 5}}, bits: 1}
+	far := &testLayerMarker{TestMarker: aoi.TestMarker{ID: "far", Pos: linemath.Vector2{X: 35, Y: 5}}, bits: 1}
+	ta.AddToAOI(w)
+	ta.AddToAOI(near)
+	ta.AddToAOI(far)
+	if !w.visible["near"] || w.visible["far"] {
+		t.Fatal("visible", w.visible)
+	}
+
+	w.Visual = 40
+	if err := tal.UpdateVisual(w); err != nil {
+		t.Fatal(err)
+	}
+	if !w.visible["near"] || !w.visible["far"] {
+		t.Fatal("visual grow", w.visible)
+	}
+
+	w.Visual = 0
+	tal.UpdateVisual(w)
+	if len(w.visible) != 0 {
+		t.Fatal("visual zero", w.visible)
+	}
+
+	// 视野变化后没有调用UpdateVisual, 移除时也不能留下关注
+	w.Visual = 10
+	tal.UpdateVisual(w)
+	w.Visual = 60
+	ta.RemoveFromAOI(w)
+	for _, layer := range tal.towerLayers {
+		layer.traversal(layer, func(x, y int, _ towerLayer, tower *Tower) {
+			if _, ok := tower.watchers["watcher"]; ok {
+				t.Fatal("stale watcher in tower", x, y)
+			}
+		})
+	}
+
+	if tal.UpdateVisual(w) != aoi.ErrObjectNotExisted {
+		t.Fatal("removed watcher")
+	}
+}
+
+func TestTowerAOILayer_CanSee(t *testing.T) {
+	hidden := make(map[string]bool)
+	ta, err := NewTowerAoi(&Config{
+		MinPos:    linemath.Vector2{X: -100, Y: -100},
+		MaxPos:    linemath.Vector2{X: 100, Y: 100},
+		TowerSize: 10,
+		CanSee: func(watcher aoi.IWatcher, obj aoi.IObject) bool {
+			return !hidden[obj.GetAOIID()]
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tal := ta.(*TowerAOILayer)
+
+	w := newTestLayerWatcher("watcher", 20, linemath.Vector2{})
+	hidden["stealth"] = true
+	stealth := &testLayerMarker{TestMarker: aoi.TestMarker{ID: "stealth", Pos: linemath.Vector2{X: 2}}, bits: 1}
+	ta.AddToAOI(w)
+	ta.AddToAOI(stealth)
+	if w.visible["stealth"] {
+		t.Fatal("hidden", w.visible)
+	}
+
+	count := 0
+	ta.Traversal(stealth, func(aoi.IWatcher) bool {
+		count++
+		return true
+	})
+	if count != 0 {
+		t.Fatal("Traversal", count)
+	}
+
+	hidden["stealth"] = false
+	tal.Refresh(stealth)
+	if !w.visible["stealth"] {
+		t.Fatal("stealth broken", w.visible)
+	}
+
+	hidden["stealth"] = true
+	tal.Refresh(stealth)
+	if w.visible["stealth"] {
+		t.Fatal("stealth again", w.visible)
+	}
+}
+
+func TestTowerAOILayer_Snapshot(t *testing.T) {
+	newAOI := func() *TowerAOILayer {
+		ta, err := NewTowerAoi(&Config{
+			MinPos:    linemath.Vector2{X: -50, Y: -50},
+			MaxPos:    linemath.Vector2{X: 50, Y: 50},
+			TowerSize: 5,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ta.(*TowerAOILayer)
+	}
+
+	ta := newAOI()
+	w1 := newTestLayerWatcher("w1", 8, linemath.Vector2{})
+	o1 := &testLayerMarker{TestMarker: aoi.TestMarker{ID: "o1", Pos: linemath.Vector2{X: 3}}, bits: 1}
+	g := &testLayerMarker{TestMarker: aoi.TestMarker{ID: "global", Pos: linemath.Vector2{X: -40, Y: -40}}, bits: 1}
+	ta.AddToAOI(w1)
+	ta.AddToAOI(o1)
+	ta.AddToAOI(g)
+	ta.AddGlobalMarker(g)
+
+	// 之后的对象按负载分到稀疏子层
+	ta.AddLayer(ta.nextLayerID(), MapLayer)
+	o2 := &testLayerMarker{TestMarker: aoi.TestMarker{ID: "o2", Pos: linemath.Vector2{X: 20, Y: 20}}, bits: 1}
+	w2 := newTestLayerWatcher("w2", 8, linemath.Vector2{X: 22, Y: 20})
+	ta.AddToAOI(o2)
+	ta.AddToAOI(w2)
+	groupID, _ := ta.CreateGroup([]aoi.IObject{w1, o2})
+
+	var buf bytes.Buffer
+	if err := ta.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// 热重启后的对象, 客户端已经知道的视野保持不变
+	copyWatcher := func(w *testLayerWatcher) *testLayerWatcher {
+		rw := newTestLayerWatcher(w.ID, w.Visual, w.Pos)
+		for id := range w.visible {
+			rw.visible[id] = true
+		}
+		return rw
+	}
+	r1, r2 := copyWatcher(w1), copyWatcher(w2)
+	restored := map[string]aoi.IObject{"w1": r1, "w2": r2, "o1": o1, "o2": o2, "global": g}
+	resolve := func(id string) aoi.IObject {
+		return restored[id]
+	}
+
+	rt := newAOI()
+	if err := rt.Restore(bytes.NewReader(data), resolve); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(r1.visible) != fmt.Sprint(w1.visible) || fmt.Sprint(r2.visible) != fmt.Sprint(w2.visible) {
+		t.Fatal("spurious events", r1.visible, r2.visible)
+	}
+
+	var again bytes.Buffer
+	rt.Snapshot(&again)
+	if !bytes.Equal(data, again.Bytes()) {
+		t.Fatal("snapshot changed after restore")
+	}
+	if rt.findObjLayerByXY(rt.objs["o2"].X, rt.objs["o2"].Y, "o2") != rt.layerID {
+		t.Fatal("layer", rt.layerID)
+	}
+
+	rt.DestroyGroup(groupID)
+	if r1.visible["o2"] || !r1.visible["o1"] || !r1.visible["global"] {
+		t.Fatal("group destroyed", r1.visible)
+	}
+
+	if rt.Restore(bytes.NewReader(data), resolve) != aoi.ErrObjectExisted {
+		t.Fatal("restore into non-empty aoi")
+	}
+	bad := append([]byte(nil), data...)
+	bad[0] = 'T'
+	if newAOI().Restore(bytes.NewReader(bad), resolve) != ErrSnapshotInvalid {
+		t.Fatal("magic")
+	}
+}
+
+type snapshotObj struct {
+	id                     string
+	flags                  uint8
+	x, y, visual, layerIdx int32
+}
+
+type snapshotGroup struct {
+	id      int64
+	members []string
+}
+
+// encodeSnapshot 按快照格式直接写入, 只有一个编号为1的子层, 记录的对象数量是layerNum
+func encodeSnapshot(layerNum int32, objs []snapshotObj, groups []snapshotGroup) []byte {
+	var buf bytes.Buffer
+	e := &_Encoder{w: &buf}
+	e.write(snapshotMagic)
+	e.write(snapshotVersion)
+	e.write(int64(0))
+	e.write(int32(1))
+	e.write(uint32(1))
+	e.write(int32(1))
+	e.write(uint32(ArrayLayer))
+	e.write(layerNum)
+	e.write(uint32(len(objs)))
+	for _, o := range objs {
+		e.writeString(o.id)
+		e.write(o.flags)
+		e.write(o.x)
+		e.write(o.y)
+		e.write(o.visual)
+		e.write(o.layerIdx)
+	}
+	e.write(uint32(len(groups)))
+	for _, g := range groups {
+		e.write(g.id)
+		e.write(uint32(len(g.members)))
+		for _, id := range g.members {
+			e.writeString(id)
+		}
+	}
+	e.write(uint32(0))
+
+	return buf.Bytes()
+}
+
+func TestTowerAOILayer_SnapshotCorrupt(t *testing.T) {
+	objs := map[string]aoi.IObject{
+		"o": &testLayerMarker{TestMarker: aoi.TestMarker{ID: "o", Pos: linemath.Vector2{X: 1, Y: 1}}, bits: 1},
+		"p": &testLayerMarker{TestMarker: aoi.TestMarker{ID: "p", Pos: linemath.Vector2{X: 1, Y: 1}}, bits: 1},
+		// 视野8, 灯塔边长5, 关注周围1圈
+		"w": newTestLayerWatcher("w", 8, linemath.Vector2{X: 1, Y: 1}),
+	}
+	restore := func(data []byte) (*TowerAOILayer, error) {
+		a, _ := NewTowerAoi(&Config{
+			MinPos:    linemath.Vector2{X: -50, Y: -50},
+			MaxPos:    linemath.Vector2{X: 50, Y: 50},
+			TowerSize: 5,
+		})
+		ta := a.(*TowerAOILayer)
+		return ta, ta.Restore(bytes.NewReader(data), func(id string) aoi.IObject { return objs[id] })
+	}
+
+	o := snapshotObj{id: "o", x: 10, y: 10, layerIdx: 1}
+	p := snapshotObj{id: "p", x: 10, y: 10, layerIdx: 1}
+	w := snapshotObj{id: "w", flags: snapshotFlagWatcher, x: 10, y: 10, visual: 1, layerIdx: 1}
+
+	// 快照中的子层对象数量不可信, 按恢复的对象重新计算
+	ta, err := restore(encodeSnapshot(100, []snapshotObj{o, p, w}, []snapshotGroup{{1, []string{"o", "w"}}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ta.layersNums[1] != 3 {
+		t.Fatal("layersNums", ta.layersNums)
+	}
+	if err := ta.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	badVisual := w
+	badVisual.visual = 5
+	cases := map[string][]byte{
+		"duplicate object": encodeSnapshot(2, []snapshotObj{o, o}, nil),
+		"duplicate group":  encodeSnapshot(2, []snapshotObj{o, p}, []snapshotGroup{{1, []string{"o"}}, {1, []string{"p"}}}),
+		"duplicate member": encodeSnapshot(1, []snapshotObj{o}, []snapshotGroup{{1, []string{"o", "o"}}}),
+		"visual mismatch":  encodeSnapshot(1, []snapshotObj{badVisual}, nil),
+	}
+	for name, data := range cases {
+		if _, err := restore(data); err != ErrSnapshotInvalid {
+			t.Fatal(name, err)
+		}
+	}
+}
+
+func TestTowerAOILayer_TowerLoads(t *testing.T) {
+	ta, err := NewTowerAoi(&Config{
+		MinPos:     linemath.Vector2{X: -10, Y: -10},
+		MaxPos:     linemath.Vector2{X: 10, Y: 10},
+		TowerSize:  5,
+		TowerLimit: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tal := ta.(*TowerAOILayer)
+
+	// 左下角的灯塔放4个对象, 超过TowerLimit
+	for i := 0; i < 4; i++ {
+		ta.AddToAOI(&testLayerMarker{TestMarker: aoi.TestMarker{ID: fmt.Sprintf("hot:%d", i), Pos: linemath.Vector2{X: -9, Y: -9}}, bits: 1})
+	}
+	ta.AddToAOI(&testLayerMarker{TestMarker: aoi.TestMarker{ID: "cold", Pos: linemath.Vector2{X: 9, Y: 9}}, bits: 1})
+
+	// 稀疏子层中的灯塔同样统计
+	tal.AddLayer(tal.nextLayerID(), MapLayer)
+	ta.AddToAOI(newTestLayerWatcher("watcher", 1, linemath.Vector2{X: 9, Y: 9}))
+
+	loads := tal.TowerLoads(0)
+	if len(loads) != 3 || loads[0].X != 0 || loads[0].Y != 0 || loads[0].Objs != 4 || !loads[0].Hot {
+		t.Fatal("loads", loads)
+	}
+	if loads[1].Hot || loads[2].Hot || loads[1].Layer == loads[2].Layer {
+		t.Fatal("layers", loads)
+	}
+	if hot := tal.HotTowers(0); len(hot) != 1 {
+		t.Fatal("hot", hot)
+	}
+	if hot := tal.HotTowers(10); len(hot) != 0 {
+		t.Fatal("threshold", hot)
+	}
+
+	var buf bytes.Buffer
+	if err := tal.ExportHeatmap(&buf, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 4x4个灯塔, 左下角是热点, 右上角有一个对象
+	if b := img.Bounds(); b.Dx() != 8 || b.Dy() != 8 {
+		t.Fatal("size", b)
+	}
+	if r, g, b, _ := img.At(0, 7).RGBA(); r != 0xffff || g != 0xffff || b != 0xffff {
+		t.Fatal("hot pixel", r, g, b)
+	}
+	if r, _, _, _ := img.At(7, 0).RGBA(); r == 0 {
+		t.Fatal("cold pixel")
+	}
+	if r, _, _, _ := img.At(7, 7).RGBA(); r != 0 {
+		t.Fatal("empty pixel")
+	}
+}
+
+func TestTowerAOILayer_GroupDuplicate(t *testing.T) {
+	ta, err := NewTowerAoi(&Config{
+		MinPos:    linemath.Vector2{X: -100, Y: -100},
+		MaxPos:    linemath.Vector2{X: 100, Y: 100},
+		TowerSize: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tal := ta.(*TowerAOILayer)
+
+	w := newTestLayerWatcher("watcher", 10, linemath.Vector2{X: -90, Y: -90})
+	m := &testLayerMarker{TestMarker: aoi.TestMarker{ID: "member", Pos: linemath.Vector2{X: 90, Y: 90}}, bits: 1}
+	ta.AddToAOI(w)
+	ta.AddToAOI(m)
+
+	// 重复的对象只加入一次, 销毁后不能留下计数
+	groupID, err := tal.CreateGroup([]aoi.IObject{w, m, m, w})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !w.visible["member"] {
+		t.Fatal("group member not visible")
+	}
+
+	if err := tal.DestroyGroup(groupID); err != nil {
+		t.Fatal(err)
+	}
+	if w.visible["member"] {
+		t.Fatal("group member visible after destroy")
+	}
+}
+
+func TestTowerAOILayer_RemoveFromGroup(t *testing.T) {
+	ta, err := NewTowerAoi(&Config{
+		MinPos:    linemath.Vector2{X: -100, Y: -100},
+		MaxPos:    linemath.Vector2{X: 100, Y: 100},
+		TowerSize: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tal := ta.(*TowerAOILayer)
+
+	w := newTestLayerWatcher("watcher", 10, linemath.Vector2{X: -90, Y: -90})
+	m := &testLayerMarker{TestMarker: aoi.TestMarker{ID: "member", Pos: linemath.Vector2{X: 90, Y: 90}}, bits: 1}
+	ta.AddToAOI(w)
+	ta.AddToAOI(m)
+
+	// 对象同时在两个组中, 从第一个组中移除后第二个组不受影响
+	first, err := tal.CreateGroup([]aoi.IObject{w, m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := tal.CreateGroup([]aoi.IObject{w, m})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tal.RemoveFromGroup(m, first); err != nil {
+		t.Fatal(err)
+	}
+	if !w.visible["member"] {
+		t.Fatal("member in second group not visible")
+	}
+	if err := tal.RemoveFromGroup(m, second); err != nil {
+		t.Fatal(err)
+	}
+	if w.visible["member"] {
+		t.Fatal("member visible after leaving all groups")
+	}
+}
+
+func TestTowerAOILayer_RemoveWatcher(t *testing.T) {
+	ta, err := NewTowerAoi(&Config{
+		MinPos:    linemath.Vector2{X: -100, Y: -100},
+		MaxPos:    linemath.Vector2{X: 100, Y: 100},
+		TowerSize: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tal := ta.(*TowerAOILayer)
+
+	// 全局watcher移除后不能再收到所在位置附近的通知
+	gw := newTestLayerWatcher("global", 20, linemath.Vector2{X: -50, Y: -50})
+	ta.AddToAOI(gw)
+	if err := tal.AddGlobalMarker(gw); err != nil {
+		t.Fatal(err)
+	}
+	if err := ta.RemoveFromAOI(gw); err != nil {
+		t.Fatal(err)
+	}
+	ta.AddToAOI(&testLayerMarker{TestMarker: aoi.TestMarker{ID: "near", Pos: linemath.Vector2{X: -52, Y: -52}}, bits: 1})
+	if gw.visible["near"] {
+		t.Fatal("removed global watcher notified")
+	}
+
+	// 普通watcher移除后不能再看到新的全局对象
+	w := newTestLayerWatcher("watcher", 20, linemath.Vector2{X: 50, Y: 50})
+	ta.AddToAOI(w)
+	if err := ta.RemoveFromAOI(w); err != nil {
+		t.Fatal(err)
+	}
+	m := &testLayerMarker{TestMarker: aoi.TestMarker{ID: "marker", Pos: linemath.Vector2{X: -90, Y: 90}}, bits: 1}
+	ta.AddToAOI(m)
+	if err := tal.AddGlobalMarker(m); err != nil {
+		t.Fatal(err)
+	}
+	if w.visible["marker"] {
+		t.Fatal("removed watcher notified by global marker")
+	}
+}
+
+func TestTowerAOILayer_MoveGlobalWatcher(t *testing.T) {
+	ta, err := NewTowerAoi(&Config{
+		MinPos:    linemath.Vector2{X: -100, Y: -100},
+		MaxPos:    linemath.Vector2{X: 100, Y: 100},
+		TowerSize: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tal := ta.(*TowerAOILayer)
+
+	gw := newTestLayerWatcher("global", 20, linemath.Vector2{X: -50, Y: -50})
+	ta.AddToAOI(gw)
+	ta.AddToAOI(&testLayerMarker{TestMarker: aoi.TestMarker{ID: "old", Pos: linemath.Vector2{X: -52, Y: -52}}, bits: 1})
+	ta.AddToAOI(&testLayerMarker{TestMarker: aoi.TestMarker{ID: "new", Pos: linemath.Vector2{X: 52, Y: 52}}, bits: 1})
+	if err := tal.AddGlobalMarker(gw); err != nil {
+		t.Fatal(err)
+	}
+	if !gw.visible["old"] || gw.visible["new"] {
+		t.Fatal("before move", gw.visible)
+	}
+
+	// 全局watcher移动后视野跟着移动
+	gw.Pos = linemath.Vector2{X: 50, Y: 50}
+	if err := ta.Move(gw); err != nil {
+		t.Fatal(err)
+	}
+	if gw.visible["old"] || !gw.visible["new"] {
+		t.Fatal("after move", gw.visible)
+	}
+}
+
+func TestTowerAOILayer_GlobalLayerNums(t *testing.T) {
+	ta, err := NewTowerAoi(&Config{
+		MinPos:    linemath.Vector2{X: -100, Y: -100},
+		MaxPos:    linemath.Vector2{X: 100, Y: 100},
+		TowerSize: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tal := ta.(*TowerAOILayer)
+	total := func() int {
+		n := 0
+		for _, v := range tal.layersNums {
+			n += v
+		}
+		return n
+	}
+
+	w := newTestLayerWatcher("watcher", 20, linemath.Vector2{X: -50, Y: -50})
+	m := &testLayerMarker{TestMarker: aoi.TestMarker{ID: "marker", Pos: linemath.Vector2{X: 50, Y: 50}}, bits: 1}
+	ta.AddToAOI(w)
+	ta.AddToAOI(m)
+
+	// 全局对象不占用层的数量, 取消全局后重新计数
+	for _, obj := range []aoi.IObject{w, m} {
+		if err := tal.AddGlobalMarker(obj); err != nil {
+			t.Fatal(err)
+		}
+		if total() != 1 {
+			t.Fatal("AddGlobalMarker", obj.GetAOIID(), tal.layersNums)
+		}
+		if err := tal.RemoveGlobalMarker(obj); err != nil {
+			t.Fatal(err)
+		}
+		if total() != 2 {
+			t.Fatal("RemoveGlobalMarker", obj.GetAOIID(), tal.layersNums)
+		}
+	}
+
+	if err := tal.AddGlobalMarker(m); err != nil {
+		t.Fatal(err)
+	}
+	ta.RemoveFromAOI(m)
+	ta.RemoveFromAOI(w)
+	if total() != 0 {
+		t.Fatal("RemoveFromAOI", tal.layersNums)
+	}
+}
+
+func TestTowerAOILayer_RemoveGlobalWatcher(t *testing.T) {
+	ta, err := NewTowerAoi(&Config{
+		MinPos:    linemath.Vector2{X: -100, Y: -100},
+		MaxPos:    linemath.Vector2{X: 100, Y: 100},
+		TowerSize: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tal := ta.(*TowerAOILayer)
+
+	gw := newTestLayerWatcher("global", 20, linemath.Vector2{X: -50, Y: -50})
+	ta.AddToAOI(gw)
+	ta.AddToAOI(&testLayerMarker{TestMarker: aoi.TestMarker{ID: "old", Pos: linemath.Vector2{X: -52, Y: -52}}, bits: 1})
+	ta.AddToAOI(&testLayerMarker{TestMarker: aoi.TestMarker{ID: "new", Pos: linemath.Vector2{X: 52, Y: 52}}, bits: 1})
+	if err := tal.AddGlobalMarker(gw); err != nil {
+		t.Fatal(err)
+	}
+
+	// 取消全局时位置已经变化, 视野按新的位置计算
+	gw.Pos = linemath.Vector2{X: 50, Y: 50}
+	if err := tal.RemoveGlobalMarker(gw); err != nil {
+		t.Fatal(err)
+	}
+	if gw.visible["old"] || !gw.visible["new"] {
+		t.Fatal("after remove global", gw.visible)
+	}
+}
+
+func TestTowerAOILayer_Validate(t *testing.T) {
+	ta, err := NewTowerAoi(&Config{
+		MinPos:    linemath.Vector2{X: -50, Y: -50},
+		MaxPos:    linemath.Vector2{X: 50, Y: 50},
+		TowerSize: 5,
+		Debug:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tal := ta.(*TowerAOILayer)
+
+	r := rand.New(rand.NewSource(1))
+	randPos := func() linemath.Vector2 {
+		return linemath.Vector2{X: r.Float32()*100 - 50, Y: r.Float32()*100 - 50}
+	}
+
+	var objs []aoi.IObject
+	for i := 0; i < 50; i++ {
+		// 中途增加稀疏子层, 之后的对象按负载分配到不同的层
+		if i == 25 {
+			tal.AddLayer(tal.nextLayerID(), MapLayer)
+		}
+		if i%3 == 0 {
+			objs = append(objs, newTestLayerWatcher(fmt.Sprintf("watcher:%d", i), r.Float32()*20, randPos()))
+		} else {
+			objs = append(objs, &testLayerMarker{TestMarker: aoi.TestMarker{ID: fmt.Sprintf("obj:%d", i), Pos: randPos()}, bits: 1})
+		}
+		ta.AddToAOI(objs[i])
+	}
+
+	var groupIDs []int
+	for i := 0; i < 2000; i++ {
+		o := objs[r.Intn(len(objs))]
+		switch op := r.Intn(10); {
+		case op < 5:
+			switch v := o.(type) {
+			case *testLayerWatcher:
+				v.Pos = randPos()
+			case *testLayerMarker:
+				v.Pos = randPos()
+			}
+			ta.Move(o)
+		case op == 5:
+			if ta.RemoveFromAOI(o) != nil {
+				ta.AddToAOI(o)
+			}
+		case op == 6:
+			if tal.AddGlobalMarker(o) == aoi.ErrObjectExisted {
+				tal.RemoveGlobalMarker(o)
+			}
+		case op == 7:
+			if groupID, err := tal.CreateGroup([]aoi.IObject{o, objs[r.Intn(len(objs))]}); err == nil {
+				groupIDs = append(groupIDs, groupID)
+			}
+		case op == 8 && len(groupIDs) > 0:
+			groupID := groupIDs[r.Intn(len(groupIDs))]
+			if tal.AddToGroup(o, groupID) == nil && r.Intn(2) == 0 {
+				tal.RemoveFromGroup(o, groupID)
+			}
+		case op == 9:
+			if w, ok := o.(*testLayerWatcher); ok {
+				w.Visual = r.Float32() * 20
+				tal.UpdateVisual(w)
+			}
+		}
+
+		if err := tal.Validate(); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+
+	// 破坏内部状态后能检查出来
+	for idx := range tal.layersNums {
+		tal.layersNums[idx]++
+		break
+	}
+	if tal.Validate() == nil {
+		t.Fatal("corrupted layersNums")
+	}
+}
+
+func TestTowerAOILayer_Conformance(t *testing.T) {
+	aoitest.RunConformance(t, aoitest.Factory{New: func() (aoi.IAOI, error) {
+		ta, err := NewTowerAoi(&Config{MinPos: aoitest.MinPos, MaxPos: aoitest.MaxPos, TowerSize: 10})
+		if err != nil {
+			return nil, err
+		}
+		return ta.(*TowerAOILayer), nil
+	}})
+}
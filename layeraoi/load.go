@@ -0,0 +1,129 @@
+package layeraoi
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"sort"
+)
+
+// TowerLoad 一个灯塔的负载
+type TowerLoad struct {
+	Layer    int // 子层编号
+	X, Y     int // 灯塔坐标
+	Objs     int
+	Watchers int
+	Hot      bool // 对象和watcher总数超过阈值
+}
+
+// TowerLoads 遍历所有子层(数组和map)中非空的灯塔, 按对象和watcher总数从高到低排列
+// threshold不大于0时使用配置的TowerLimit, 都没有设置时不标记热点
+func (t *TowerAOILayer) TowerLoads(threshold int) []TowerLoad {
+	var loads []TowerLoad
+	for idx, layer := range t.towerLayers {
+		layer.traversal(layer, func(x, y int, layer towerLayer, tower *Tower) {
+			if tower.GetObjsLen() == 0 && tower.GetWatchersLen() == 0 {
+				return
+			}
+
+			loads = append(loads, TowerLoad{
+				Layer:    idx,
+				X:        x,
+				Y:        y,
+				Objs:     tower.GetObjsLen(),
+				Watchers: tower.GetWatchersLen(),
+				Hot:      t.checkTowerLoad(tower, threshold),
+			})
+		})
+	}
+
+	sort.Slice(loads, func(i, j int) bool {
+		li, lj := loads[i].Objs+loads[i].Watchers, loads[j].Objs+loads[j].Watchers
+		if li != lj {
+			return li > lj
+		}
+		if loads[i].Layer != loads[j].Layer {
+			return loads[i].Layer < loads[j].Layer
+		}
+		if loads[i].X != loads[j].X {
+			return loads[i].X < loads[j].X
+		}
+		return loads[i].Y < loads[j].Y
+	})
+
+	return loads
+}
+
+// HotTowers 超过阈值的灯塔, 按负载从高到低排列
+func (t *TowerAOILayer) HotTowers(threshold int) []TowerLoad {
+	var hot []TowerLoad
+	for _, load := range t.TowerLoads(threshold) {
+		if load.Hot {
+			hot = append(hot, load)
+		}
+	}
+
+	return hot
+}
+
+// ExportHeatmap 把每个灯塔位置所有子层的对象数量画成PNG热力图, 每个灯塔占scale*scale个像素
+// 颜色从黑(没有对象)经过红色到黄色(最多), 超过阈值的灯塔画成白色. 图片上方是地图Y轴的最大值
+func (t *TowerAOILayer) ExportHeatmap(w io.Writer, scale int, threshold int) error {
+	if scale <= 0 {
+		scale = 1
+	}
+
+	density := make([][]int, t.towerSizeX)
+	hot := make([][]bool, t.towerSizeX)
+	for x := range density {
+		density[x] = make([]int, t.towerSizeY)
+		hot[x] = make([]bool, t.towerSizeY)
+	}
+
+	maxDensity := 0
+	for _, load := range t.TowerLoads(threshold) {
+		if load.X < 0 || load.X >= t.towerSizeX || load.Y < 0 || load.Y >= t.towerSizeY {
+			continue
+		}
+
+		density[load.X][load.Y] += load.Objs
+		hot[load.X][load.Y] = hot[load.X][load.Y] || load.Hot
+		if density[load.X][load.Y] > maxDensity {
+			maxDensity = density[load.X][load.Y]
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, t.towerSizeX*scale, t.towerSizeY*scale))
+	for x := 0; x < t.towerSizeX; x++ {
+		for y := 0; y < t.towerSizeY; y++ {
+			c := heatColor(density[x][y], maxDensity)
+			if hot[x][y] {
+				c = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+			}
+
+			row := t.towerSizeY - 1 - y
+			for i := 0; i < scale; i++ {
+				for j := 0; j < scale; j++ {
+					img.SetRGBA(x*scale+i, row*scale+j, c)
+				}
+			}
+		}
+	}
+
+	return png.Encode(w, img)
+}
+
+// heatColor 前半段黑到红, 后半段红到黄
+func heatColor(v, max int) color.RGBA {
+	if v <= 0 || max <= 0 {
+		return color.RGBA{A: 255}
+	}
+
+	ratio := float64(v) / float64(max)
+	if ratio <= 0.5 {
+		return color.RGBA{R: uint8(ratio * 2 * 255), A: 255}
+	}
+
+	return color.RGBA{R: 255, G: uint8((ratio - 0.5) * 2 * 255), A: 255}
+}
@@ -0,0 +1,389 @@
+package layeraoi
+
+import (
+	"aoi"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sort"
+)
+
+// 快照格式版本, 格式变化时递增, 旧版本的快照需要兼容读取
+const snapshotVersion uint32 = 1
+
+var snapshotMagic = [4]byte{'L', 'A', 'O', 'I'}
+
+const (
+	snapshotFlagGlobal  = 1 << 0
+	snapshotFlagWatcher = 1 << 1
+)
+
+var (
+	ErrSnapshotInvalid = errors.New("snapshot invalid")
+	ErrSnapshotVersion = errors.New("snapshot version not supported")
+)
+
+// Snapshot 保存子层, 所有对象所在的子层和灯塔, 全局标记, 群组以及每个watcher视野内的对象, 用于热重启
+// 格式: 头部(magic, 版本), 群组ID种子, 子层列表, 对象列表, 群组列表, 视野列表, 整数都是小端
+// 所在的层由LayerAOI分配, 不保存
+func (t *TowerAOILayer) Snapshot(w io.Writer) error {
+	e := &_Encoder{w: w}
+	e.write(snapshotMagic)
+	e.write(snapshotVersion)
+	e.write(int64(t.groupIDSeed))
+	e.write(int32(t.layerID))
+
+	layerIdxs := make([]int, 0, len(t.towerLayers))
+	for idx := range t.towerLayers {
+		layerIdxs = append(layerIdxs, idx)
+	}
+	sort.Ints(layerIdxs)
+
+	e.write(uint32(len(layerIdxs)))
+	for _, idx := range layerIdxs {
+		e.write(int32(idx))
+		e.write(uint32(t.towerLayers[idx].getLayerType()))
+		e.write(int32(t.layersNums[idx]))
+	}
+
+	ids := make([]string, 0, len(t.objs))
+	for id := range t.objs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	e.write(uint32(len(ids)))
+	for _, id := range ids {
+		cacheObj := t.objs[id]
+		var flags uint8
+		if t.global.Existed(id) {
+			flags |= snapshotFlagGlobal
+		}
+		if cacheObj.wrapWatcher != nil {
+			flags |= snapshotFlagWatcher
+		}
+
+		e.writeString(id)
+		e.write(flags)
+		e.write(int32(cacheObj.X))
+		e.write(int32(cacheObj.Y))
+		e.write(int32(cacheObj.towerVisual))
+		// 全局对象并且没有关注灯塔时不在任何子层中, 为math.MinInt32
+		e.write(int32(t.findObjLayerByXY(cacheObj.X, cacheObj.Y, id)))
+	}
+
+	groupIDs := make([]int, 0, len(t.groups))
+	for groupID := range t.groups {
+		groupIDs = append(groupIDs, groupID)
+	}
+	sort.Ints(groupIDs)
+
+	e.write(uint32(len(groupIDs)))
+	for _, groupID := range groupIDs {
+		e.write(int64(groupID))
+		e.writeIDs(t.groups[groupID].GetObjs())
+	}
+
+	var watcherIDs []string
+	for _, id := range ids {
+		if t.objs[id].wrapWatcher != nil {
+			watcherIDs = append(watcherIDs, id)
+		}
+	}
+
+	e.write(uint32(len(watcherIDs)))
+	for _, id := range watcherIDs {
+		entered := make(map[string]aoi.IObject)
+		for infoID, info := range t.objs[id].wrapWatcher.info {
+			if info.entered {
+				entered[infoID] = info.obj
+			}
+		}
+
+		e.writeString(id)
+		e.writeIDs(entered)
+	}
+
+	return e.err
+}
+
+// Restore 从快照恢复到一个空的AOI, 替换原有的子层, resolve根据ID返回对象, 对象的位置以快照中的灯塔为准
+// 快照中已经在视野内的对象不会再通知进入, 只通知快照之后发生的变化
+// 重复的对象, 群组或者群组成员都视为损坏的快照, 返回ErrSnapshotInvalid
+// 返回错误时AOI处于部分恢复的状态, 应该丢弃
+func (t *TowerAOILayer) Restore(r io.Reader, resolve func(id string) aoi.IObject) error {
+	if len(t.objs) != 0 || len(t.groups) != 0 {
+		return aoi.ErrObjectExisted
+	}
+
+	d := &_Decoder{r: r}
+	var magic [4]byte
+	var version uint32
+	d.read(&magic)
+	d.read(&version)
+	if d.err != nil {
+		return d.err
+	}
+	if magic != snapshotMagic {
+		return ErrSnapshotInvalid
+	}
+	if version != snapshotVersion {
+		return ErrSnapshotVersion
+	}
+
+	var groupIDSeed int64
+	var layerID int32
+	var layerCount uint32
+	d.read(&groupIDSeed)
+	d.read(&layerID)
+	d.read(&layerCount)
+	if d.err != nil {
+		return d.err
+	}
+
+	t.towerLayers = make(map[int]towerLayer)
+	t.layersNums = make(map[int]int)
+	for i := uint32(0); i < layerCount && d.err == nil; i++ {
+		// 子层的对象数量按恢复的对象重新计算, 不使用快照中的值
+		var idx, num int32
+		var layerType uint32
+		d.read(&idx)
+		d.read(&layerType)
+		d.read(&num)
+		if d.err != nil {
+			break
+		}
+
+		if LayerType(layerType) != ArrayLayer && LayerType(layerType) != MapLayer {
+			return ErrSnapshotInvalid
+		}
+		if !t.AddLayer(int(idx), LayerType(layerType)) {
+			return ErrSnapshotInvalid
+		}
+	}
+	t.layerID = int(layerID)
+
+	var objCount uint32
+	d.read(&objCount)
+	restored := make(map[string]aoi.IObject)
+	for i := uint32(0); i < objCount && d.err == nil; i++ {
+		var flags uint8
+		var x, y, towerVisual, layerIdx int32
+		id := d.readString()
+		d.read(&flags)
+		d.read(&x)
+		d.read(&y)
+		d.read(&towerVisual)
+		d.read(&layerIdx)
+		if d.err != nil {
+			break
+		}
+
+		obj := resolve(id)
+		if err := t.restoreObject(obj, id, flags, int(x), int(y), int(towerVisual), int(layerIdx)); err != nil {
+			return err
+		}
+		restored[id] = obj
+	}
+
+	var groupCount uint32
+	d.read(&groupCount)
+	for i := uint32(0); i < groupCount && d.err == nil; i++ {
+		var groupID int64
+		d.read(&groupID)
+		members := d.readIDs()
+		if d.err != nil {
+			break
+		}
+
+		if _, ok := t.groups[int(groupID)]; ok {
+			return ErrSnapshotInvalid
+		}
+
+		group := NewTower()
+		t.groups[int(groupID)] = group
+		for _, id := range members {
+			cacheObj, ok := t.objs[id]
+			if !ok || group.Existed(id) {
+				return ErrSnapshotInvalid
+			}
+
+			group.Add(restored[id], t.GetLayer())
+			cacheObj.groups = append(cacheObj.groups, int(groupID))
+			if cacheObj.wrapWatcher != nil {
+				group.AddWatcher(cacheObj.wrapWatcher, t.GetLayer())
+			}
+		}
+	}
+	t.groupIDSeed = int(groupIDSeed)
+
+	var watcherCount uint32
+	d.read(&watcherCount)
+	for i := uint32(0); i < watcherCount && d.err == nil; i++ {
+		id := d.readString()
+		entered := d.readIDs()
+		if d.err != nil {
+			break
+		}
+
+		cacheObj, ok := t.objs[id]
+		if !ok || cacheObj.wrapWatcher == nil {
+			return ErrSnapshotInvalid
+		}
+
+		// 标记为已经进入, Flush时只会通知差异; 快照中可见但现在不在灯塔内的对象会收到离开
+		ww := cacheObj.wrapWatcher
+		for _, infoID := range entered {
+			info, ok := ww.info[infoID]
+			if !ok {
+				obj := resolve(infoID)
+				if obj == nil {
+					continue
+				}
+				info = &_CacheInfo{obj: obj}
+				ww.info[infoID] = info
+			}
+			info.entered = true
+		}
+		t.notifyDirty(ww)
+	}
+
+	if d.err != nil {
+		return d.err
+	}
+
+	t.flushWatchers()
+
+	return nil
+}
+
+func (t *TowerAOILayer) restoreObject(obj aoi.IObject, id string, flags uint8, x, y, towerVisual, layerIdx int) error {
+	if obj == nil || obj.GetAOIID() != id {
+		return aoi.ErrObjectNotExisted
+	}
+
+	if _, ok := t.objs[id]; ok {
+		return ErrSnapshotInvalid
+	}
+
+	w, isWatcher := obj.(ILayerWatcher)
+	if isWatcher != (flags&snapshotFlagWatcher != 0) {
+		return ErrSnapshotInvalid
+	}
+
+	// 不在灯塔中的只有没有关注灯塔的全局对象
+	layer, ok := t.towerLayers[layerIdx]
+	if !ok && (layerIdx != math.MinInt32 || flags&snapshotFlagGlobal == 0 || (isWatcher && towerVisual >= 0)) {
+		return ErrSnapshotInvalid
+	}
+	if x < 0 || x >= t.towerSizeX || y < 0 || y >= t.towerSizeY {
+		return ErrSnapshotInvalid
+	}
+
+	// 灯塔圈数必须和对象当前的视野一致, 损坏的快照可能让watcher关注错误的灯塔
+	expected := 0
+	if isWatcher {
+		expected = t.getWatcherTowerVisual(w)
+	}
+	if towerVisual != expected {
+		return ErrSnapshotInvalid
+	}
+
+	cacheObj := &_CacheObject{X: x, Y: y, towerVisual: towerVisual}
+	t.objs[id] = cacheObj
+	if flags&snapshotFlagGlobal != 0 {
+		t.global.Add(obj, t.GetLayer())
+	} else {
+		layer.getTower(x, y).Add(obj, t.GetLayer())
+		t.layersNums[layerIdx]++
+	}
+
+	if isWatcher {
+		ww := newWrapWatcher(w, t.notifyDirty)
+		if t.canSee != nil {
+			ww.filter = t.isVisible
+		}
+		cacheObj.wrapWatcher = ww
+		t.traversalTowerByVisual(x, y, towerVisual, layer, func(towerX, towerY int, tower *Tower) {
+			tower.AddWatcher(ww, t.GetLayer())
+		})
+		t.global.AddWatcher(ww, t.GetLayer())
+	}
+
+	return nil
+}
+
+// _Encoder 记录第一个错误, 之后的写入全部忽略
+type _Encoder struct {
+	w   io.Writer
+	err error
+}
+
+func (e *_Encoder) write(v interface{}) {
+	if e.err == nil {
+		e.err = binary.Write(e.w, binary.LittleEndian, v)
+	}
+}
+
+func (e *_Encoder) writeString(s string) {
+	e.write(uint32(len(s)))
+	if e.err == nil {
+		_, e.err = io.WriteString(e.w, s)
+	}
+}
+
+// writeIDs 按ID排序写入, 保证同样的状态得到同样的快照
+func (e *_Encoder) writeIDs(objs map[string]aoi.IObject) {
+	ids := make([]string, 0, len(objs))
+	for id := range objs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	e.write(uint32(len(ids)))
+	for _, id := range ids {
+		e.writeString(id)
+	}
+}
+
+// 单个ID的最大长度, 防止损坏的快照申请过大的内存
+const maxSnapshotIDLen = 1 << 16
+
+type _Decoder struct {
+	r   io.Reader
+	err error
+}
+
+func (d *_Decoder) read(v interface{}) {
+	if d.err == nil {
+		d.err = binary.Read(d.r, binary.LittleEndian, v)
+	}
+}
+
+func (d *_Decoder) readString() string {
+	var n uint32
+	d.read(&n)
+	if d.err != nil {
+		return ""
+	}
+	if n > maxSnapshotIDLen {
+		d.err = ErrSnapshotInvalid
+		return ""
+	}
+
+	buf := make([]byte, n)
+	_, d.err = io.ReadFull(d.r, buf)
+	return string(buf)
+}
+
+func (d *_Decoder) readIDs() []string {
+	var n uint32
+	d.read(&n)
+	var ids []string
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		ids = append(ids, d.readString())
+	}
+
+	return ids
+}
@@ -3,8 +3,10 @@ package layeraoi
 import (
 	"aoi"
 	"aoi/base/linemath"
+	"aoi/metrics"
 	"errors"
 	"math"
+	"strconv"
 	"time"
 
 	log "github.com/cihub/seelog"
@@ -47,6 +49,7 @@ type arrayTowerLayer [][]*Tower
 type towerLayer interface {
 	getLayerType() LayerType
 	getTower(x, y int) *Tower
+	findTower(x, y int) *Tower
 	traversal(layer towerLayer, f func(x, y int, layer towerLayer, tower *Tower))
 }
 
@@ -62,6 +65,15 @@ func (mt *mapTowerLayer) getTower(x, y int) *Tower {
 	return (*mt)[x][y]
 }
 
+// findTower 只查找不创建, 不存在返回nil
+func (mt *mapTowerLayer) findTower(x, y int) *Tower {
+	if m, ok := (*mt)[x]; ok {
+		return m[y]
+	}
+
+	return nil
+}
+
 func (mt *mapTowerLayer) traversal(layer towerLayer, f func(x, y int, layer towerLayer, tower *Tower)) {
 	for x, m := range *mt {
 		for y, v := range m {
@@ -94,6 +106,14 @@ func (at *arrayTowerLayer) getTower(x, y int) *Tower {
 	return (*at)[x][y]
 }
 
+func (at *arrayTowerLayer) findTower(x, y int) *Tower {
+	if x < 0 || x >= len(*at) || y < 0 || y >= len((*at)[x]) {
+		return nil
+	}
+
+	return (*at)[x][y]
+}
+
 func (at *arrayTowerLayer) getLayerType() LayerType {
 	return ArrayLayer
 }
@@ -134,6 +154,14 @@ type TowerAOILayer struct {
 	layerID int //增加layer时+1，layer合并时-1
 	lastAdjustment time.Time //上一次层调整时间，每次调整单层，从上至下
 	loadBalancing interface{}
+
+	// 可见性策略, 为空时不限制
+	canSee func(watcher aoi.IWatcher, obj aoi.IObject) bool
+
+	metrics *metrics.Collector
+
+	// 每次操作后检查内部状态
+	debug bool
 }
 
 func (t *TowerAOILayer)nextLayerID()int{
@@ -144,6 +172,7 @@ func (t *TowerAOILayer)nextLayerID()int{
 type _CacheObject struct {
 	X, Y        int
 	wrapWatcher *_WrapWatcher
+	towerVisual int // 当前关注的灯塔圈数, 小于0表示没有关注灯塔, 视野变化后要按这个取消关注
 	groups      []int
 }
 
@@ -157,6 +186,19 @@ type Config struct {
 	TowerSize float32
 	LoadBalanceCfg *LoadBalanceConfig
 	LayerLimit int
+
+	// TowerLimit 单个灯塔的对象和watcher总数超过时作为热点, 见TowerLoads
+	TowerLimit int
+
+	// CanSee 可见性策略(隐身, 阵营, 权限等), 返回false时即使在视野内也不可见,
+	// 对全局对象和群组同样生效. 策略依赖的状态变化后调用Refresh重新判断
+	CanSee func(watcher aoi.IWatcher, obj aoi.IObject) bool
+
+	// Metrics 不为空时记录操作次数, 耗时, 通知数量, 并定期采样灯塔负载和子层数量
+	Metrics *metrics.Collector
+
+	// Debug 每次操作后调用Validate, 发现错误时记录日志. 开销很大, 只用于调试
+	Debug bool
 }
 
 func NewTowerAoi(cfg *Config) (ILayerAOIBase, error) {
@@ -182,6 +224,10 @@ func NewTowerAoi(cfg *Config) (ILayerAOIBase, error) {
 	ta.global = NewTower()
 	ta.groups = make(map[int]*Tower)
 	ta.layerLimit = cfg.LayerLimit
+	ta.towerLimit = cfg.TowerLimit
+	ta.canSee = cfg.CanSee
+	ta.metrics = cfg.Metrics
+	ta.debug = cfg.Debug
 	if cfg.LoadBalanceCfg != nil{
 		ta.loadBalancing = cfg.LoadBalanceCfg.MethodObj
 	}
@@ -262,6 +308,8 @@ func (t *TowerAOILayer) layerLoadBalancing() (int,int) {
 }
 
 func (t *TowerAOILayer) AddToAOI(obj aoi.IObject) error {
+	defer t.done(metrics.OpAdd, t.metrics.Start())
+
 	if obj == nil {
 		return aoi.ErrObjectInvalid
 	}
@@ -298,13 +346,15 @@ func (t *TowerAOILayer) AddToAOI(obj aoi.IObject) error {
 
 	if w, ok := obj.(ILayerWatcher); ok {
 		ww := newWrapWatcher(w, t.notifyDirty)
-		t.objs[obj.GetAOIID()].wrapWatcher = ww
-		if visual := w.GetLayerVisual(t.GetLayer()); visual > 0 {
-			towerVisual := int(math.Ceil(float64(visual/t.towerSize))) - 1
-			t.traversalTowerByVisual(x, y, towerVisual, towerLayer, func(towerX, towerY int, tower *Tower) {
-				tower.AddWatcher(ww, t.GetLayer())
-			})
+		if t.canSee != nil {
+			ww.filter = t.isVisible
 		}
+		t.objs[obj.GetAOIID()].wrapWatcher = ww
+		towerVisual := t.getWatcherTowerVisual(w)
+		t.objs[obj.GetAOIID()].towerVisual = towerVisual
+		t.traversalTowerByVisual(x, y, towerVisual, towerLayer, func(towerX, towerY int, tower *Tower) {
+			tower.AddWatcher(ww, t.GetLayer())
+		})
 
 		t.global.AddWatcher(ww, t.GetLayer())
 	}
@@ -331,6 +381,8 @@ func (t *TowerAOILayer) findObjLayerByXY(x, y int, aoiID string) int {
 }
 
 func (t *TowerAOILayer) RemoveFromAOI(obj aoi.IObject) error {
+	defer t.done(metrics.OpRemove, t.metrics.Start())
+
 	if obj == nil {
 		return aoi.ErrObjectInvalid
 	}
@@ -359,28 +411,26 @@ func (t *TowerAOILayer) RemoveFromAOI(obj aoi.IObject) error {
 		}
 	}
 
+	// 对象可能已经移动但还没有调用Move, 按缓存的位置查找, 全局watcher同样关注着所在层的灯塔
+	layerIdx := t.findObjLayerByXY(cacheObj.X, cacheObj.Y, obj.GetAOIID())
 	if t.global.Existed(obj.GetAOIID()) {
 		// 如果是全局object, 从全局系统中移除
 		t.global.Remove(obj, t.GetLayer())
-		if cacheObj.wrapWatcher != nil {
-			t.global.RemoveWatcher(cacheObj.wrapWatcher, t.GetLayer())
-		}
 	} else {
 		// 从灯塔系统中移除
-		layerIdx := t.findObjLayer(obj)
 		if layerIdx == math.MinInt32 {
 			return errors.New("Not Found Obj " + obj.GetAOIID())
 		}
-		x, y := t.transPos(obj.GetCoordPos())
 		t.layersNums[layerIdx] -= 1
-		t.towerLayers[layerIdx].getTower(x, y).Remove(obj, t.GetLayer())
-		if cacheObj.wrapWatcher != nil {
-			if visual := cacheObj.wrapWatcher.GetLayerVisual(t.GetLayer()); visual > 0 {
-				towerVisual := int(math.Ceil(float64(visual/t.towerSize))) - 1
-				t.traversalTowerByVisual(cacheObj.X, cacheObj.Y, towerVisual, t.towerLayers[layerIdx], func(towerX, towerY int, tower *Tower) {
-					tower.RemoveWatcher(cacheObj.wrapWatcher, t.GetLayer())
-				})
-			}
+		t.towerLayers[layerIdx].getTower(cacheObj.X, cacheObj.Y).Remove(obj, t.GetLayer())
+	}
+
+	if cacheObj.wrapWatcher != nil {
+		t.global.RemoveWatcher(cacheObj.wrapWatcher, t.GetLayer())
+		if layerIdx != math.MinInt32 {
+			t.traversalTowerByVisual(cacheObj.X, cacheObj.Y, cacheObj.towerVisual, t.towerLayers[layerIdx], func(towerX, towerY int, tower *Tower) {
+				tower.RemoveWatcher(cacheObj.wrapWatcher, t.GetLayer())
+			})
 		}
 	}
 
@@ -389,13 +439,18 @@ func (t *TowerAOILayer) RemoveFromAOI(obj aoi.IObject) error {
 	return nil
 }
 
-func (t *TowerAOILayer)checkTowerLoad(x,y,layer int,tower *Tower){
-	if len(tower.watchers) + len(tower.objs) > t.towerLimit{
-
+// checkTowerLoad 灯塔的对象和watcher总数超过threshold时为热点, threshold不大于0时使用配置的TowerLimit
+func (t *TowerAOILayer) checkTowerLoad(tower *Tower, threshold int) bool {
+	if threshold <= 0 {
+		threshold = t.towerLimit
 	}
+
+	return threshold > 0 && len(tower.watchers)+len(tower.objs) > threshold
 }
 
 func (t *TowerAOILayer) Move(obj aoi.IObject) error {
+	defer t.done(metrics.OpMove, t.metrics.Start())
+
 	if obj == nil {
 		return aoi.ErrObjectInvalid
 	}
@@ -424,10 +479,15 @@ func (t *TowerAOILayer) Move(obj aoi.IObject) error {
 	}
 	cacheObj.X = newX
 	cacheObj.Y = newY
+	layerIdx := t.findObjLayerByXY(oldX, oldY, obj.GetAOIID())
 	if t.global.Existed(obj.GetAOIID()) {
+		// 全局watcher在原来的层中移动关注的灯塔
+		if cacheObj.wrapWatcher != nil && layerIdx != math.MinInt32 {
+			t.moveWatcher(cacheObj, oldX, oldY, t.towerLayers[layerIdx], t.towerLayers[layerIdx])
+			t.flushWatchers()
+		}
 		return nil
 	}
-	layerIdx := t.findObjLayerByXY(oldX, oldY, obj.GetAOIID())
 	minLayerIdx,minLoad := t.layerLoadBalancing()
 	if t.layersNums[layerIdx] - minLoad > 1 { //int(float32(t.layerLimit) * 0.1)
 		t.layersNums[minLayerIdx]++
@@ -442,15 +502,65 @@ func (t *TowerAOILayer) Move(obj aoi.IObject) error {
 	newTower.Add(obj, t.GetLayer())
 
 	if cacheObj.wrapWatcher != nil {
-		if visual := cacheObj.wrapWatcher.GetLayerVisual(t.GetLayer()); visual > 0 {
-			towerVisual := int(math.Ceil(float64(visual/t.towerSize))) - 1
-			t.traversalTowerByVisual(oldX, oldY, towerVisual, t.towerLayers[layerIdx], func(towerX, towerY int, tower *Tower) {
-				tower.RemoveWatcher(cacheObj.wrapWatcher, t.GetLayer())
-			})
-			t.traversalTowerByVisual(newX, newY, towerVisual, t.towerLayers[minLayerIdx], func(towerX, towerY int, tower *Tower) {
-				tower.AddWatcher(cacheObj.wrapWatcher, t.GetLayer())
-			})
+		t.moveWatcher(cacheObj, oldX, oldY, t.towerLayers[layerIdx], t.towerLayers[minLayerIdx])
+	}
+
+	t.flushWatchers()
+
+	return nil
+}
+
+// moveWatcher watcher从旧位置旧层关注的灯塔移动到新位置(cacheObj.X/Y)新层的灯塔
+// 视野可能已经变化但还没有调用UpdateVisual, 按当前关注的圈数移动
+func (t *TowerAOILayer) moveWatcher(cacheObj *_CacheObject, oldX, oldY int, oldLayer, newLayer towerLayer) {
+	t.traversalTowerByVisual(oldX, oldY, cacheObj.towerVisual, oldLayer, func(towerX, towerY int, tower *Tower) {
+		tower.RemoveWatcher(cacheObj.wrapWatcher, t.GetLayer())
+	})
+	t.traversalTowerByVisual(cacheObj.X, cacheObj.Y, cacheObj.towerVisual, newLayer, func(towerX, towerY int, tower *Tower) {
+		tower.AddWatcher(cacheObj.wrapWatcher, t.GetLayer())
+	})
+}
+
+// UpdateVisual watcher的视野变化后调用, 按新旧视野的差异增减关注的灯塔, 通知进入和离开
+func (t *TowerAOILayer) UpdateVisual(watcher ILayerWatcher) error {
+	if watcher == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := t.objs[watcher.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	ww := cacheObj.wrapWatcher
+	if ww == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	// watcher关注的是自己所在层的灯塔, 全局watcher原来没有关注灯塔时找不到层, 使用负载最低的层
+	layerIdx := t.findObjLayerByXY(cacheObj.X, cacheObj.Y, watcher.GetAOIID())
+	if layerIdx == math.MinInt32 {
+		if cacheObj.towerVisual >= 0 {
+			return errors.New("Not Found Obj " + watcher.GetAOIID())
 		}
+		layerIdx, _ = t.layerLoadBalancing()
+	}
+	layer := t.towerLayers[layerIdx]
+
+	oldVisual, newVisual := cacheObj.towerVisual, t.getWatcherTowerVisual(ww)
+	cacheObj.towerVisual = newVisual
+	if newVisual > oldVisual {
+		t.traversalTowerByVisual(cacheObj.X, cacheObj.Y, newVisual, layer, func(towerX, towerY int, tower *Tower) {
+			if !inTowerRange(cacheObj.X, cacheObj.Y, oldVisual, towerX, towerY) {
+				tower.AddWatcher(ww, t.GetLayer())
+			}
+		})
+	} else if newVisual < oldVisual {
+		t.traversalTowerByVisual(cacheObj.X, cacheObj.Y, oldVisual, layer, func(towerX, towerY int, tower *Tower) {
+			if !inTowerRange(cacheObj.X, cacheObj.Y, newVisual, towerX, towerY) {
+				tower.RemoveWatcher(ww, t.GetLayer())
+			}
+		})
 	}
 
 	t.flushWatchers()
@@ -459,6 +569,8 @@ func (t *TowerAOILayer) Move(obj aoi.IObject) error {
 }
 
 func (t *TowerAOILayer) AddGlobalMarker(obj aoi.IObject) error {
+	defer t.done(metrics.OpAddGlobal, t.metrics.Start())
+
 	if obj == nil {
 		return aoi.ErrObjectInvalid
 	}
@@ -471,11 +583,12 @@ func (t *TowerAOILayer) AddGlobalMarker(obj aoi.IObject) error {
 	if t.global.Existed(obj.GetAOIID()) {
 		return aoi.ErrObjectExisted
 	}
-	layerIdx := t.findObjLayer(obj)
+	layerIdx := t.findObjLayerByXY(cacheObj.X, cacheObj.Y, obj.GetAOIID())
 	if layerIdx == math.MinInt32 {
 		return errors.New("Not Found obj " + obj.GetAOIID())
 	}
 	t.towerLayers[layerIdx].getTower(cacheObj.X, cacheObj.Y).Remove(obj, t.GetLayer())
+	t.layersNums[layerIdx] -= 1
 
 	t.global.Add(obj, t.GetLayer())
 
@@ -485,6 +598,8 @@ func (t *TowerAOILayer) AddGlobalMarker(obj aoi.IObject) error {
 }
 
 func (t *TowerAOILayer) RemoveGlobalMarker(obj aoi.IObject) error {
+	defer t.done(metrics.OpRemoveGlobal, t.metrics.Start())
+
 	if obj == nil {
 		return aoi.ErrObjectInvalid
 	}
@@ -500,12 +615,21 @@ func (t *TowerAOILayer) RemoveGlobalMarker(obj aoi.IObject) error {
 
 	t.global.Remove(obj, t.GetLayer())
 
-	x, y := t.transPos(pos)
-	layerIdx := t.findObjLayer(obj)
+	// watcher回到关注的灯塔所在的层, 否则放到负载最低的层
+	cacheObj := t.objs[obj.GetAOIID()]
+	oldX, oldY := cacheObj.X, cacheObj.Y
+	layerIdx := t.findObjLayerByXY(oldX, oldY, obj.GetAOIID())
+	if layerIdx == math.MinInt32 {
+		layerIdx, _ = t.layerLoadBalancing()
+	}
 	layer := t.towerLayers[layerIdx]
-	layer.getTower(x, y).Add(obj, t.GetLayer())
-	t.objs[obj.GetAOIID()].X = x
-	t.objs[obj.GetAOIID()].Y = y
+
+	cacheObj.X, cacheObj.Y = t.transPos(pos)
+	layer.getTower(cacheObj.X, cacheObj.Y).Add(obj, t.GetLayer())
+	t.layersNums[layerIdx] += 1
+	if cacheObj.wrapWatcher != nil && (oldX != cacheObj.X || oldY != cacheObj.Y) {
+		t.moveWatcher(cacheObj, oldX, oldY, layer, layer)
+	}
 
 	t.flushWatchers()
 
@@ -522,6 +646,8 @@ func (t *TowerAOILayer) Traversal(obj aoi.IObject, cb func(obj aoi.IWatcher) boo
 		return
 	}
 
+	cb = t.filterTraversal(obj, cb)
+
 	// 全局对象, 直接遍历所有的watcher就可以
 	if t.global.Existed(obj.GetAOIID()) {
 		t.global.Traversal(obj, cb)
@@ -552,6 +678,8 @@ func (t *TowerAOILayer) Traversal(obj aoi.IObject, cb func(obj aoi.IWatcher) boo
 }
 
 func (t *TowerAOILayer) CreateGroup(objs []aoi.IObject) (int, error) {
+	defer t.done(metrics.OpCreateGroup, t.metrics.Start())
+
 	if len(objs) == 0 {
 		return 0, aoi.ErrObjectInvalid
 	}
@@ -568,7 +696,10 @@ func (t *TowerAOILayer) CreateGroup(objs []aoi.IObject) (int, error) {
 	t.groupIDSeed++
 	t.groups[t.groupIDSeed] = group
 	for _, o := range objs {
-		group.Add(o, t.GetLayer())
+		// 重复的对象只加入一次
+		if group.Add(o, t.GetLayer()) != nil {
+			continue
+		}
 
 		cacheObj := t.objs[o.GetAOIID()]
 		if cacheObj.groups == nil {
@@ -587,6 +718,8 @@ func (t *TowerAOILayer) CreateGroup(objs []aoi.IObject) (int, error) {
 }
 
 func (t *TowerAOILayer) DestroyGroup(groupID int) error {
+	defer t.done(metrics.OpDestroyGroup, t.metrics.Start())
+
 	group, ok := t.groups[groupID]
 	if !ok {
 		return aoi.ErrGroupNotExisted
@@ -597,6 +730,7 @@ func (t *TowerAOILayer) DestroyGroup(groupID int) error {
 		for i, v := range cacheObj.groups {
 			if v == groupID {
 				cacheObj.groups = append(cacheObj.groups[:i], cacheObj.groups[i+1:]...)
+				break
 			}
 		}
 	}
@@ -610,6 +744,8 @@ func (t *TowerAOILayer) DestroyGroup(groupID int) error {
 }
 
 func (t *TowerAOILayer) AddToGroup(obj aoi.IObject, groupID int) error {
+	defer t.done(metrics.OpAddToGroup, t.metrics.Start())
+
 	if obj == nil {
 		return aoi.ErrObjectInvalid
 	}
@@ -641,6 +777,8 @@ func (t *TowerAOILayer) AddToGroup(obj aoi.IObject, groupID int) error {
 }
 
 func (t *TowerAOILayer) RemoveFromGroup(obj aoi.IObject, groupID int) error {
+	defer t.done(metrics.OpRemoveFromGroup, t.metrics.Start())
+
 	if obj == nil {
 		return aoi.ErrObjectInvalid
 	}
@@ -667,6 +805,7 @@ func (t *TowerAOILayer) RemoveFromGroup(obj aoi.IObject, groupID int) error {
 	for i := range cacheObj.groups {
 		if cacheObj.groups[i] == groupID {
 			cacheObj.groups = append(cacheObj.groups[:i], cacheObj.groups[i+1:]...)
+			break
 		}
 	}
 
@@ -693,7 +832,70 @@ func (t *TowerAOILayer) TraversalGroup(obj aoi.IObject, groupID int, cb func(wat
 		return
 	}
 
-	group.Traversal(obj, cb)
+	group.Traversal(obj, t.filterTraversal(obj, cb))
+}
+
+// Refresh 对象的可见性策略依赖的状态变化后调用, 重新判断能看到它的watcher, 以及它(如果是watcher)能看到的对象
+func (t *TowerAOILayer) Refresh(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := t.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	if t.global.Existed(obj.GetAOIID()) {
+		t.markWatchersDirty(t.global)
+	} else if layerIdx := t.findObjLayerByXY(cacheObj.X, cacheObj.Y, obj.GetAOIID()); layerIdx != math.MinInt32 {
+		t.markWatchersDirty(t.towerLayers[layerIdx].getTower(cacheObj.X, cacheObj.Y))
+	}
+
+	for _, groupID := range cacheObj.groups {
+		if group, ok := t.groups[groupID]; ok {
+			t.markWatchersDirty(group)
+		}
+	}
+
+	if cacheObj.wrapWatcher != nil {
+		t.notifyDirty(cacheObj.wrapWatcher)
+	}
+
+	t.flushWatchers()
+
+	return nil
+}
+
+func (t *TowerAOILayer) markWatchersDirty(tower *Tower) {
+	for _, w := range tower.GetWatchers() {
+		if ww, ok := w.(*_WrapWatcher); ok {
+			t.notifyDirty(ww)
+		}
+	}
+}
+
+// isVisible 灯塔粗筛之后按可见性策略过滤
+func (t *TowerAOILayer) isVisible(ww *_WrapWatcher, info *_CacheInfo) bool {
+	return t.canSee(ww.ILayerWatcher, info.obj)
+}
+
+// filterTraversal 遍历watcher时跳过可见性策略不允许看到obj的watcher
+func (t *TowerAOILayer) filterTraversal(obj aoi.IObject, cb func(watcher aoi.IWatcher) bool) func(watcher aoi.IWatcher) bool {
+	if t.canSee == nil {
+		return cb
+	}
+
+	return func(watcher aoi.IWatcher) bool {
+		w := watcher
+		if ww, ok := watcher.(*_WrapWatcher); ok {
+			w = ww.ILayerWatcher
+		}
+		if !t.canSee(w, obj) {
+			return true
+		}
+		return cb(watcher)
+	}
 }
 
 func (t *TowerAOILayer) isInvalid(pos linemath.Vector2) bool {
@@ -705,12 +907,40 @@ func (t *TowerAOILayer) isInvalid(pos linemath.Vector2) bool {
 }
 
 func (t *TowerAOILayer) transPos(pos linemath.Vector2) (int, int) {
-	x := math.Floor(float64((pos.X - t.minPos.X) / t.towerSize))
-	y := math.Floor(float64((pos.Y - t.minPos.Y) / t.towerSize))
-	return int(x), int(y)
+	x := int(math.Floor(float64((pos.X - t.minPos.X) / t.towerSize)))
+	y := int(math.Floor(float64((pos.Y - t.minPos.Y) / t.towerSize)))
+	// 正好在最大边界上的对象归到最后一个灯塔
+	if x > t.towerSizeX-1 {
+		x = t.towerSizeX - 1
+	}
+	if y > t.towerSizeY-1 {
+		y = t.towerSizeY - 1
+	}
+
+	return x, y
 }
 
+// getWatcherTowerVisual watcher当前视野对应的灯塔圈数, 视野不大于0时返回-1, 不关注任何灯塔
+func (t *TowerAOILayer) getWatcherTowerVisual(w ILayerWatcher) int {
+	visual := w.GetLayerVisual(t.GetLayer())
+	if visual <= 0 {
+		return -1
+	}
+
+	return int(math.Ceil(float64(visual/t.towerSize))) - 1
+}
+
+// inTowerRange 灯塔(towerX, towerY)是否在以(x, y)为中心visual圈的范围内
+func inTowerRange(x, y, visual, towerX, towerY int) bool {
+	return towerX >= x-visual && towerX <= x+visual && towerY >= y-visual && towerY <= y+visual
+}
+
+// traversalTowerByVisual visual小于0时不遍历
 func (t *TowerAOILayer) traversalTowerByVisual(x, y, visual int, towerLayer towerLayer, cb func(towerX, towerY int, tower *Tower)) {
+	if visual < 0 {
+		return
+	}
+
 	startX, endX, startY, endY := t.getTowerRange(x, y, visual)
 	for i := startX; i <= endX; i++ {
 		for j := startY; j <= endY; j++ {
@@ -740,13 +970,41 @@ func (t *TowerAOILayer) getTowerRange(x, y, i int) (int, int, int, int) {
 	return startX, endX, startY, endY
 }
 
+// done 操作结束时记录指标, 定期采样所有子层的灯塔负载和子层的对象数量
+func (t *TowerAOILayer) done(op metrics.Op, start time.Time) {
+	if t.metrics == nil {
+		return
+	}
+
+	t.metrics.Done(op, start)
+	if t.metrics.ShouldSample() {
+		t.metrics.SampleTowers(func(observe func(objs int)) {
+			for _, layer := range t.towerLayers {
+				layer.traversal(layer, func(x, y int, layer towerLayer, tower *Tower) {
+					observe(tower.GetObjsLen())
+				})
+			}
+		})
+
+		nums := make(map[string]int, len(t.layersNums))
+		for idx, num := range t.layersNums {
+			nums[strconv.Itoa(idx)] = num
+		}
+		t.metrics.SampleLayers(nums)
+	}
+}
+
 func (t *TowerAOILayer) notifyDirty(ww *_WrapWatcher) {
 	t.dirtyWatchers[ww.GetAOIID()] = ww
 }
 
 func (t *TowerAOILayer) flushWatchers() {
 	for id, w := range t.dirtyWatchers {
-		w.Flush(t.GetLayer())
+		t.metrics.Flush(w.Flush(t.GetLayer()))
 		delete(t.dirtyWatchers, id)
 	}
+
+	if t.debug {
+		t.debugValidate()
+	}
 }
@@ -10,6 +10,9 @@ type _WrapWatcher struct {
 
 	notify func(watcher *_WrapWatcher)
 	info   map[string]*_CacheInfo
+
+	// filter 灯塔粗筛之后的过滤, 为空时灯塔内的对象全部可见
+	filter func(watcher *_WrapWatcher, info *_CacheInfo) bool
 }
 
 type _CacheInfo struct {
@@ -75,21 +78,29 @@ func (wo *_WrapWatcher) OnLayerBatchLeave(objs []aoi.IObject,layer int) {
 	wo.notify(wo)
 }
 
-func (wo *_WrapWatcher) Flush(layer int) {
+// Flush 通知进入和离开, 返回通知的数量
+func (wo *_WrapWatcher) Flush(layer int) (int, int) {
 	enterList := make([]aoi.IObject, 0, 1)
 	leaveList := make([]aoi.IObject, 0, 1)
 
 	for _, info := range wo.info {
-		if info.count > 0 && !info.entered {
-			//wo.IWatcher.OnObjectEnter(info.obj)
-			enterList = append(enterList, info.obj)
-			info.entered = true
-		} else if info.count <= 0 {
+		if info.count <= 0 {
 			if info.entered {
 				//wo.IWatcher.OnObjectLeave(info.obj,layer)
 				leaveList = append(leaveList, info.obj)
 			}
 			delete(wo.info, info.obj.GetAOIID())
+			continue
+		}
+
+		visible := wo.filter == nil || wo.filter(wo, info)
+		if visible && !info.entered {
+			//wo.IWatcher.OnObjectEnter(info.obj)
+			enterList = append(enterList, info.obj)
+			info.entered = true
+		} else if !visible && info.entered {
+			leaveList = append(leaveList, info.obj)
+			info.entered = false
 		}
 	}
 
@@ -99,4 +110,6 @@ func (wo *_WrapWatcher) Flush(layer int) {
 	if len(leaveList) > 0 {
 		wo.ILayerWatcher.OnLayerBatchLeave(leaveList,layer)
 	}
+
+	return len(enterList), len(leaveList)
 }
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"encoding/json"
+	"sync/atomic"
+)
+
+// Histogram 分桶计数, 实现了expvar.Var, 可以并发读写
+// 第i个桶统计不大于bounds[i]的值, 最后一个桶统计大于所有bounds的值
+type Histogram struct {
+	bounds []int64
+	counts []int64
+	sum    int64
+	count  int64
+}
+
+func NewHistogram(bounds []int64) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]int64, len(bounds)+1),
+	}
+}
+
+func (h *Histogram) Observe(v int64) {
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, v)
+	atomic.AddInt64(&h.count, 1)
+}
+
+// Count 记录的总次数
+func (h *Histogram) Count() int64 {
+	return atomic.LoadInt64(&h.count)
+}
+
+// Sum 记录的值之和
+func (h *Histogram) Sum() int64 {
+	return atomic.LoadInt64(&h.sum)
+}
+
+// Buckets 每个桶的计数, 比bounds多一个
+func (h *Histogram) Buckets() []int64 {
+	counts := make([]int64, len(h.counts))
+	for i := range h.counts {
+		counts[i] = atomic.LoadInt64(&h.counts[i])
+	}
+
+	return counts
+}
+
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Bounds []int64 `json:"bounds"`
+		Counts []int64 `json:"counts"`
+		Sum    int64   `json:"sum"`
+		Count  int64   `json:"count"`
+	}{h.bounds, h.Buckets(), h.Sum(), h.Count()})
+}
+
+func (h *Histogram) String() string {
+	b, _ := h.MarshalJSON()
+	return string(b)
+}
@@ -0,0 +1,199 @@
+package metrics
+
+import (
+	"errors"
+	"expvar"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Collector AOI的运行指标, 通过标准库expvar导出, 可以在/debug/vars中查看
+//
+// 所有方法都可以用nil调用, 没有开启指标时不产生任何开销.
+// 写入只在AOI所在的协程进行, 读取(expvar的HTTP请求)可以在任意协程, 所有的值都是原子读写的
+type Collector struct {
+	vars *expvar.Map
+
+	ops [opCount]_OpStats
+
+	enters    *expvar.Int
+	leaves    *expvar.Int
+	flushSize *Histogram // 每次Flush通知的进入+离开数量
+
+	// 定期采样的灯塔负载和子层数量, 整个替换
+	towerObjs atomic.Value // *Histogram
+	layers    atomic.Value // map[string]int
+
+	sampleInterval int
+	opsSinceSample int
+}
+
+type _OpStats struct {
+	count   *expvar.Int
+	latency *Histogram // 微秒
+}
+
+// Op 统计的操作类型
+type Op int
+
+const (
+	OpAdd Op = iota
+	OpMove
+	OpRemove
+	OpAddGlobal
+	OpRemoveGlobal
+	OpCreateGroup
+	OpDestroyGroup
+	OpAddToGroup
+	OpRemoveFromGroup
+	opCount
+)
+
+var opNames = [opCount]string{
+	"add", "move", "remove",
+	"add_global", "remove_global",
+	"create_group", "destroy_group", "add_to_group", "remove_from_group",
+}
+
+var (
+	ErrMetricsConfigInvalid = errors.New("config invalid")
+)
+
+// publishMu 检查名字和发布之间不能插入其他的发布, expvar重复发布会panic
+var publishMu sync.Mutex
+
+var (
+	// 操作耗时的分桶, 单位微秒
+	latencyBuckets = []int64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 5000}
+	// 每次Flush通知数量的分桶
+	flushBuckets = []int64{1, 2, 4, 8, 16, 32, 64, 128, 256}
+	// 每个非空灯塔对象数量的分桶
+	towerBuckets = []int64{1, 2, 4, 8, 16, 32, 64, 128, 256, 1024}
+)
+
+type Config struct {
+	// Name expvar中发布的名字, 同一个进程中不能重复
+	Name string
+
+	// SampleInterval 每多少次操作采样一次灯塔负载和子层数量, 默认1000
+	SampleInterval int
+}
+
+func New(cfg *Config) (*Collector, error) {
+	if cfg.Name == "" || cfg.SampleInterval < 0 {
+		return nil, ErrMetricsConfigInvalid
+	}
+
+	c := &Collector{
+		vars:           new(expvar.Map).Init(),
+		enters:         new(expvar.Int),
+		leaves:         new(expvar.Int),
+		flushSize:      NewHistogram(flushBuckets),
+		sampleInterval: cfg.SampleInterval,
+	}
+	if c.sampleInterval == 0 {
+		c.sampleInterval = 1000
+	}
+
+	for i := range c.ops {
+		c.ops[i] = _OpStats{count: new(expvar.Int), latency: NewHistogram(latencyBuckets)}
+		c.vars.Set(opNames[i], c.ops[i].count)
+		c.vars.Set(opNames[i]+"_latency_us", c.ops[i].latency)
+	}
+
+	c.towerObjs.Store(NewHistogram(towerBuckets))
+	c.layers.Store(map[string]int{})
+
+	c.vars.Set("enter", c.enters)
+	c.vars.Set("leave", c.leaves)
+	c.vars.Set("flush_size", c.flushSize)
+	c.vars.Set("tower_objs", expvar.Func(func() interface{} {
+		return c.towerObjs.Load()
+	}))
+	c.vars.Set("layers", expvar.Func(func() interface{} {
+		return c.layers.Load()
+	}))
+
+	publishMu.Lock()
+	defer publishMu.Unlock()
+	if expvar.Get(cfg.Name) != nil {
+		return nil, ErrMetricsConfigInvalid
+	}
+	expvar.Publish(cfg.Name, c.vars)
+
+	return c, nil
+}
+
+// Start 操作开始, 和Done配合统计耗时: defer c.Done(OpAdd, c.Start())
+func (c *Collector) Start() time.Time {
+	if c == nil {
+		return time.Time{}
+	}
+
+	return time.Now()
+}
+
+// Done 操作结束, 记录次数和耗时
+func (c *Collector) Done(op Op, start time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.ops[op].count.Add(1)
+	c.ops[op].latency.Observe(time.Since(start).Microseconds())
+}
+
+// Flush 记录一次watcher通知的进入和离开数量
+func (c *Collector) Flush(enters, leaves int) {
+	if c == nil || enters+leaves == 0 {
+		return
+	}
+
+	c.enters.Add(int64(enters))
+	c.leaves.Add(int64(leaves))
+	c.flushSize.Observe(int64(enters + leaves))
+}
+
+// ShouldSample 每SampleInterval次调用返回一次true, 这时AOI调用SampleTowers和SampleLayers
+func (c *Collector) ShouldSample() bool {
+	if c == nil {
+		return false
+	}
+
+	c.opsSinceSample++
+	if c.opsSinceSample < c.sampleInterval {
+		return false
+	}
+
+	c.opsSinceSample = 0
+	return true
+}
+
+// SampleTowers 遍历灯塔, traversal对每个灯塔调用一次observe, 参数是灯塔中的对象数量, 空灯塔不统计
+func (c *Collector) SampleTowers(traversal func(observe func(objs int))) {
+	if c == nil {
+		return
+	}
+
+	h := NewHistogram(towerBuckets)
+	traversal(func(objs int) {
+		if objs > 0 {
+			h.Observe(int64(objs))
+		}
+	})
+	c.towerObjs.Store(h)
+}
+
+// SampleLayers 记录每个子层的对象数量, key是子层的编号
+func (c *Collector) SampleLayers(nums map[string]int) {
+	if c == nil {
+		return
+	}
+
+	layers := make(map[string]int, len(nums))
+	for k, v := range nums {
+		layers[k] = v
+	}
+	c.layers.Store(layers)
+}
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]int64{1, 10})
+	for _, v := range []int64{0, 1, 5, 10, 11, 100} {
+		h.Observe(v)
+	}
+
+	buckets := h.Buckets()
+	if len(buckets) != 3 || buckets[0] != 2 || buckets[1] != 2 || buckets[2] != 2 {
+		t.Fatal("buckets", buckets)
+	}
+	if h.Count() != 6 || h.Sum() != 127 {
+		t.Fatal("count sum", h.Count(), h.Sum())
+	}
+
+	var v struct {
+		Counts []int64 `json:"counts"`
+	}
+	if err := json.Unmarshal([]byte(h.String()), &v); err != nil || len(v.Counts) != 3 {
+		t.Fatal("json", h.String(), err)
+	}
+}
+
+func TestCollector(t *testing.T) {
+	var nilCollector *Collector
+	nilCollector.Done(OpAdd, nilCollector.Start())
+	nilCollector.Flush(1, 1)
+	if nilCollector.ShouldSample() {
+		t.Fatal("nil collector sampled")
+	}
+
+	c, err := New(&Config{Name: "metrics_test", SampleInterval: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(&Config{Name: "metrics_test"}); err != ErrMetricsConfigInvalid {
+		t.Fatal("duplicate name", err)
+	}
+
+	c.Done(OpMove, time.Now())
+	c.Done(OpMove, time.Now())
+	c.Flush(3, 1)
+	c.Flush(0, 0)
+	if c.ShouldSample() || !c.ShouldSample() {
+		t.Fatal("sample interval")
+	}
+	c.SampleTowers(func(observe func(objs int)) {
+		observe(0)
+		observe(3)
+	})
+	c.SampleLayers(map[string]int{"1": 5})
+
+	// 通过expvar读取
+	var vars struct {
+		Move      int64                 `json:"move"`
+		Enter     int64                 `json:"enter"`
+		Leave     int64                 `json:"leave"`
+		FlushSize struct{ Count int64 } `json:"flush_size"`
+		TowerObjs struct{ Count int64 } `json:"tower_objs"`
+		Layers    map[string]int        `json:"layers"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("metrics_test").String()), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars.Move != 2 || vars.Enter != 3 || vars.Leave != 1 || vars.FlushSize.Count != 1 || vars.TowerObjs.Count != 1 || vars.Layers["1"] != 5 {
+		t.Fatal("vars", expvar.Get("metrics_test").String())
+	}
+}
+
+// TestCollector_ConcurrentNew 同名的Collector同时创建时只有一个成功, 不会panic
+func TestCollector_ConcurrentNew(t *testing.T) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := New(&Config{Name: "metrics_concurrent_test"}); err == nil {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if created != 1 {
+		t.Fatal("created", created)
+	}
+}
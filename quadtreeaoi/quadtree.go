@@ -0,0 +1,206 @@
+package quadtreeaoi
+
+import (
+	"aoi"
+	"aoi/base/linemath"
+)
+
+// _QuadTree 四叉树, 叶子节点对象过多时分裂, 子树对象过少时合并
+type _QuadTree struct {
+	root *_Node
+
+	splitThreshold int
+	mergeThreshold int
+	maxDepth       int
+}
+
+type _Node struct {
+	minPos, maxPos linemath.Vector2
+	depth          int
+
+	items    map[string]*_Item // 只有叶子节点有
+	children [4]*_Node
+	count    int // 子树中的对象数量
+}
+
+type _Item struct {
+	obj aoi.IObject
+	pos linemath.Vector2 // 插入时的位置, 删除时按这个位置查找
+}
+
+func newQuadTree(minPos, maxPos linemath.Vector2, splitThreshold, mergeThreshold, maxDepth int) *_QuadTree {
+	return &_QuadTree{
+		root:           newNode(minPos, maxPos, 0),
+		splitThreshold: splitThreshold,
+		mergeThreshold: mergeThreshold,
+		maxDepth:       maxDepth,
+	}
+}
+
+func newNode(minPos, maxPos linemath.Vector2, depth int) *_Node {
+	return &_Node{
+		minPos: minPos,
+		maxPos: maxPos,
+		depth:  depth,
+		items:  make(map[string]*_Item),
+	}
+}
+
+func (n *_Node) isLeaf() bool {
+	return n.children[0] == nil
+}
+
+func (n *_Node) center() linemath.Vector2 {
+	return linemath.Vector2{X: (n.minPos.X + n.maxPos.X) / 2, Y: (n.minPos.Y + n.maxPos.Y) / 2}
+}
+
+// childIndex 0:左下 1:右下 2:左上 3:右上
+func (n *_Node) childIndex(pos linemath.Vector2) int {
+	c := n.center()
+	index := 0
+	if pos.X >= c.X {
+		index |= 1
+	}
+	if pos.Y >= c.Y {
+		index |= 2
+	}
+
+	return index
+}
+
+func (n *_Node) split() {
+	c := n.center()
+	n.children[0] = newNode(n.minPos, c, n.depth+1)
+	n.children[1] = newNode(linemath.Vector2{X: c.X, Y: n.minPos.Y}, linemath.Vector2{X: n.maxPos.X, Y: c.Y}, n.depth+1)
+	n.children[2] = newNode(linemath.Vector2{X: n.minPos.X, Y: c.Y}, linemath.Vector2{X: c.X, Y: n.maxPos.Y}, n.depth+1)
+	n.children[3] = newNode(c, n.maxPos, n.depth+1)
+
+	for id, item := range n.items {
+		child := n.children[n.childIndex(item.pos)]
+		child.items[id] = item
+		child.count++
+	}
+	n.items = nil
+}
+
+func (n *_Node) merge() {
+	n.items = make(map[string]*_Item, n.count)
+	n.collect(n.items)
+	n.children = [4]*_Node{}
+}
+
+func (n *_Node) collect(items map[string]*_Item) {
+	if n.isLeaf() {
+		for id, item := range n.items {
+			items[id] = item
+		}
+		return
+	}
+
+	for _, child := range n.children {
+		child.collect(items)
+	}
+}
+
+func (qt *_QuadTree) Insert(obj aoi.IObject, pos linemath.Vector2) {
+	n := qt.root
+	for {
+		n.count++
+		if n.isLeaf() {
+			break
+		}
+		n = n.children[n.childIndex(pos)]
+	}
+
+	n.items[obj.GetAOIID()] = &_Item{obj: obj, pos: pos}
+	if n.count > qt.splitThreshold && n.depth < qt.maxDepth {
+		n.split()
+	}
+}
+
+// Remove pos必须是插入时的位置
+func (qt *_QuadTree) Remove(id string, pos linemath.Vector2) bool {
+	return qt.remove(qt.root, id, pos)
+}
+
+func (qt *_QuadTree) remove(n *_Node, id string, pos linemath.Vector2) bool {
+	if n.isLeaf() {
+		if _, ok := n.items[id]; !ok {
+			return false
+		}
+		delete(n.items, id)
+		n.count--
+		return true
+	}
+
+	if !qt.remove(n.children[n.childIndex(pos)], id, pos) {
+		return false
+	}
+
+	n.count--
+	if n.count <= qt.mergeThreshold {
+		n.merge()
+	}
+
+	return true
+}
+
+// Query 遍历矩形范围内的对象, cb返回false时停止
+func (qt *_QuadTree) Query(minPos, maxPos linemath.Vector2, cb func(obj aoi.IObject, pos linemath.Vector2) bool) {
+	qt.query(qt.root, minPos, maxPos, cb)
+}
+
+func (qt *_QuadTree) query(n *_Node, minPos, maxPos linemath.Vector2, cb func(obj aoi.IObject, pos linemath.Vector2) bool) bool {
+	if n.count == 0 || n.minPos.X > maxPos.X || n.maxPos.X < minPos.X || n.minPos.Y > maxPos.Y || n.maxPos.Y < minPos.Y {
+		return true
+	}
+
+	if n.isLeaf() {
+		for _, item := range n.items {
+			p := item.pos
+			if p.X < minPos.X || p.X > maxPos.X || p.Y < minPos.Y || p.Y > maxPos.Y {
+				continue
+			}
+			if !cb(item.obj, p) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, child := range n.children {
+		if !qt.query(child, minPos, maxPos, cb) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// QueryRadius 遍历圆形范围内的对象, cb返回false时停止
+func (qt *_QuadTree) QueryRadius(pos linemath.Vector2, radius float32, cb func(obj aoi.IObject, pos linemath.Vector2) bool) {
+	minPos := linemath.Vector2{X: pos.X - radius, Y: pos.Y - radius}
+	maxPos := linemath.Vector2{X: pos.X + radius, Y: pos.Y + radius}
+	qt.Query(minPos, maxPos, func(obj aoi.IObject, p linemath.Vector2) bool {
+		if p.Sub(pos).Len() > radius {
+			return true
+		}
+		return cb(obj, p)
+	})
+}
+
+// NodeCount 节点数量, 测试用
+func (qt *_QuadTree) NodeCount() int {
+	return qt.root.nodeCount()
+}
+
+func (n *_Node) nodeCount() int {
+	count := 1
+	if !n.isLeaf() {
+		for _, child := range n.children {
+			count += child.nodeCount()
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,546 @@
+package quadtreeaoi
+
+import (
+	"aoi"
+	"aoi/base/linemath"
+	"aoi/internal/tower"
+	"errors"
+
+	log "github.com/cihub/seelog"
+)
+
+// QuadTreeAOI 基于四叉树的AOI实现, 适合密度很不均匀的地图
+// 进出视野按照与watcher的实际距离判断
+type QuadTreeAOI struct {
+	tree           *_QuadTree
+	minPos, maxPos linemath.Vector2 // 地图边界
+	objs           map[string]*_CacheObject
+
+	// 所有watcher, 以及最大视野, 对象移动时用来查找可能看到它的watcher
+	watchers  map[string]*_CacheObject
+	maxVisual float32
+
+	// 全局列表
+	global *tower.Tower
+
+	// 群组
+	groups      map[int]*tower.Tower
+	groupIDSeed int
+
+	// 缓存需要清理的watcher
+	dirtyWatchers map[string]*tower.WrapWatcher
+}
+
+type _CacheObject struct {
+	obj         aoi.IObject
+	pos         linemath.Vector2 // 在树中的位置
+	wrapWatcher *tower.WrapWatcher
+	groups      []int
+
+	seenBy map[string]*_CacheObject // 视野内有这个对象的watcher
+	sees   map[string]*_CacheObject // 是watcher时, 视野内的对象
+}
+
+var (
+	ErrQuadTreeConfigInvalid = errors.New("config invalid")
+)
+
+type Config struct {
+	MinPos linemath.Vector2
+	MaxPos linemath.Vector2
+
+	// SplitThreshold 叶子节点对象数超过时分裂, 默认16
+	SplitThreshold int
+	// MergeThreshold 子树对象数不超过时合并, 默认SplitThreshold/2
+	MergeThreshold int
+	// MaxDepth 最大深度, 默认10
+	MaxDepth int
+}
+
+func New(cfg *Config) (aoi.IAOI, error) {
+	if cfg.MinPos.X > cfg.MaxPos.X || cfg.MinPos.Y > cfg.MaxPos.Y {
+		return nil, ErrQuadTreeConfigInvalid
+	}
+
+	splitThreshold := cfg.SplitThreshold
+	if splitThreshold <= 0 {
+		splitThreshold = 16
+	}
+	mergeThreshold := cfg.MergeThreshold
+	if mergeThreshold <= 0 {
+		mergeThreshold = splitThreshold / 2
+	}
+	if mergeThreshold >= splitThreshold {
+		return nil, ErrQuadTreeConfigInvalid
+	}
+	maxDepth := cfg.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = 10
+	}
+
+	qa := &QuadTreeAOI{
+		tree:          newQuadTree(cfg.MinPos, cfg.MaxPos, splitThreshold, mergeThreshold, maxDepth),
+		minPos:        cfg.MinPos,
+		maxPos:        cfg.MaxPos,
+		objs:          make(map[string]*_CacheObject),
+		watchers:      make(map[string]*_CacheObject),
+		global:        tower.NewTower(),
+		groups:        make(map[int]*tower.Tower),
+		dirtyWatchers: make(map[string]*tower.WrapWatcher),
+	}
+
+	return qa, nil
+}
+
+func (q *QuadTreeAOI) AddToAOI(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	if _, ok := q.objs[obj.GetAOIID()]; ok {
+		return aoi.ErrObjectExisted
+	}
+
+	pos := obj.GetCoordPos()
+	if !q.isInvalid(pos) {
+		return aoi.ErrPosInvalid
+	}
+
+	cacheObj := &_CacheObject{
+		obj:    obj,
+		pos:    pos,
+		seenBy: make(map[string]*_CacheObject),
+	}
+	q.objs[obj.GetAOIID()] = cacheObj
+	q.tree.Insert(obj, pos)
+
+	if w, ok := obj.(aoi.IWatcher); ok {
+		ww := tower.NewWrapWatcher(w, q.notifyDirty)
+		cacheObj.wrapWatcher = ww
+		cacheObj.sees = make(map[string]*_CacheObject)
+		q.watchers[w.GetAOIID()] = cacheObj
+		if visual := w.GetVisual(); visual > q.maxVisual {
+			q.maxVisual = visual
+		}
+
+		q.global.AddWatcher(ww)
+		q.refreshWatcher(cacheObj)
+	}
+
+	q.refreshObject(cacheObj)
+
+	q.flushWatchers()
+
+	return nil
+}
+
+func (q *QuadTreeAOI) RemoveFromAOI(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := q.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+	delete(q.objs, obj.GetAOIID())
+
+	// 从所有group中移除
+	for _, groupID := range cacheObj.groups {
+		if group, existed := q.groups[groupID]; existed {
+			if group.Existed(obj.GetAOIID()) {
+				group.Remove(obj)
+				if cacheObj.wrapWatcher != nil {
+					group.RemoveWatcher(cacheObj.wrapWatcher)
+				}
+
+				if group.GetObjsLen() == 0 && group.GetWatchersLen() == 0 {
+					delete(q.groups, groupID)
+				}
+			} else {
+				log.Debug("奇怪的事情发生了, 检查代码")
+			}
+		}
+	}
+
+	if q.global.Existed(obj.GetAOIID()) {
+		q.global.Remove(obj)
+	} else {
+		q.tree.Remove(obj.GetAOIID(), cacheObj.pos)
+		for _, watcherObj := range cacheObj.seenBy {
+			q.setSeen(watcherObj, cacheObj, false)
+		}
+	}
+
+	if ww := cacheObj.wrapWatcher; ww != nil {
+		for _, o := range cacheObj.sees {
+			q.setSeen(cacheObj, o, false)
+		}
+		q.global.RemoveWatcher(ww)
+
+		delete(q.watchers, ww.GetAOIID())
+		if ww.GetVisual() >= q.maxVisual {
+			q.resetMaxVisual()
+		}
+	}
+
+	q.flushWatchers()
+
+	return nil
+}
+
+func (q *QuadTreeAOI) Move(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := q.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	pos := obj.GetCoordPos()
+	if !q.isInvalid(pos) {
+		return aoi.ErrPosInvalid
+	}
+
+	if q.global.Existed(obj.GetAOIID()) {
+		cacheObj.pos = pos
+	} else {
+		q.tree.Remove(obj.GetAOIID(), cacheObj.pos)
+		cacheObj.pos = pos
+		q.tree.Insert(obj, pos)
+		q.refreshObject(cacheObj)
+	}
+
+	if cacheObj.wrapWatcher != nil {
+		q.refreshWatcher(cacheObj)
+	}
+
+	q.flushWatchers()
+
+	return nil
+}
+
+func (q *QuadTreeAOI) AddGlobalMarker(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := q.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	if q.global.Existed(obj.GetAOIID()) {
+		return aoi.ErrObjectExisted
+	}
+
+	q.global.Add(obj)
+
+	q.tree.Remove(obj.GetAOIID(), cacheObj.pos)
+	for _, watcherObj := range cacheObj.seenBy {
+		q.setSeen(watcherObj, cacheObj, false)
+	}
+
+	q.flushWatchers()
+
+	return nil
+}
+
+func (q *QuadTreeAOI) RemoveGlobalMarker(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	if !q.global.Existed(obj.GetAOIID()) {
+		return aoi.ErrObjectNotExisted
+	}
+
+	pos := obj.GetCoordPos()
+	if !q.isInvalid(pos) {
+		return aoi.ErrPosInvalid
+	}
+
+	cacheObj := q.objs[obj.GetAOIID()]
+	cacheObj.pos = pos
+	q.tree.Insert(obj, pos)
+	q.refreshObject(cacheObj)
+
+	q.global.Remove(obj)
+
+	q.flushWatchers()
+
+	return nil
+}
+
+func (q *QuadTreeAOI) Traversal(obj aoi.IObject, cb func(obj aoi.IWatcher) bool) {
+	if obj == nil {
+		return
+	}
+
+	cacheObject, ok := q.objs[obj.GetAOIID()]
+	if !ok {
+		return
+	}
+
+	// 全局对象, 直接遍历所有的watcher就可以
+	if q.global.Existed(obj.GetAOIID()) {
+		q.global.Traversal(obj, cb)
+		return
+	}
+
+	calledWatchers := make(map[string]bool)
+	for _, groupID := range cacheObject.groups {
+		q.TraversalGroup(obj, groupID, func(watcher aoi.IWatcher) bool {
+			if _, called := calledWatchers[watcher.GetAOIID()]; !called {
+				calledWatchers[watcher.GetAOIID()] = true
+				return cb(watcher)
+			}
+			return true
+		})
+	}
+
+	for _, watcherObj := range cacheObject.seenBy {
+		if _, called := calledWatchers[watcherObj.obj.GetAOIID()]; !called {
+			if !cb(watcherObj.wrapWatcher) {
+				return
+			}
+		}
+	}
+}
+
+func (q *QuadTreeAOI) CreateGroup(objs []aoi.IObject) (int, error) {
+	if len(objs) == 0 {
+		return 0, aoi.ErrObjectInvalid
+	}
+
+	// 检查所有objs是否在AOI中
+	for _, o := range objs {
+		if _, ok := q.objs[o.GetAOIID()]; !ok {
+			return 0, aoi.ErrObjectNotExisted
+		}
+	}
+
+	// 构建群组
+	group := tower.NewTower()
+	q.groupIDSeed++
+	q.groups[q.groupIDSeed] = group
+	for _, o := range objs {
+		group.Add(o)
+
+		cacheObj := q.objs[o.GetAOIID()]
+		cacheObj.groups = append(cacheObj.groups, q.groupIDSeed)
+
+		if cacheObj.wrapWatcher != nil {
+			group.AddWatcher(cacheObj.wrapWatcher)
+		}
+	}
+
+	q.flushWatchers()
+
+	return q.groupIDSeed, nil
+}
+
+func (q *QuadTreeAOI) DestroyGroup(groupID int) error {
+	group, ok := q.groups[groupID]
+	if !ok {
+		return aoi.ErrGroupNotExisted
+	}
+
+	for _, o := range group.GetObjs() {
+		cacheObj := q.objs[o.GetAOIID()]
+		cacheObj.groups = removeGroupID(cacheObj.groups, groupID)
+	}
+
+	group.Clear()
+	delete(q.groups, groupID)
+
+	q.flushWatchers()
+
+	return nil
+}
+
+func (q *QuadTreeAOI) AddToGroup(obj aoi.IObject, groupID int) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := q.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	group, ok := q.groups[groupID]
+	if !ok {
+		return aoi.ErrGroupNotExisted
+	}
+
+	if group.Existed(obj.GetAOIID()) {
+		return nil
+	}
+
+	group.Add(obj)
+	if cacheObj.wrapWatcher != nil {
+		group.AddWatcher(cacheObj.wrapWatcher)
+	}
+
+	cacheObj.groups = append(cacheObj.groups, groupID)
+
+	q.flushWatchers()
+
+	return nil
+}
+
+func (q *QuadTreeAOI) RemoveFromGroup(obj aoi.IObject, groupID int) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := q.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	group, ok := q.groups[groupID]
+	if !ok {
+		return aoi.ErrGroupNotExisted
+	}
+
+	if !group.Existed(obj.GetAOIID()) {
+		return nil
+	}
+
+	group.Remove(obj)
+	if cacheObj.wrapWatcher != nil {
+		group.RemoveWatcher(cacheObj.wrapWatcher)
+	}
+
+	cacheObj.groups = removeGroupID(cacheObj.groups, groupID)
+
+	if group.GetWatchersLen() == 0 && group.GetObjsLen() == 0 {
+		delete(q.groups, groupID)
+	}
+
+	q.flushWatchers()
+
+	return nil
+}
+
+func (q *QuadTreeAOI) TraversalGroup(obj aoi.IObject, groupID int, cb func(watcher aoi.IWatcher) bool) {
+	if obj == nil {
+		return
+	}
+
+	if _, ok := q.objs[obj.GetAOIID()]; !ok {
+		return
+	}
+
+	group, ok := q.groups[groupID]
+	if !ok {
+		return
+	}
+
+	group.Traversal(obj, cb)
+}
+
+// refreshObject 对象位置变化后, 重新判断哪些watcher能看到它
+func (q *QuadTreeAOI) refreshObject(cacheObj *_CacheObject) {
+	for _, watcherObj := range cacheObj.seenBy {
+		if !q.inVisual(watcherObj, cacheObj.pos) {
+			q.setSeen(watcherObj, cacheObj, false)
+		}
+	}
+
+	check := func(id string) {
+		if watcherObj, ok := q.watchers[id]; ok && q.inVisual(watcherObj, cacheObj.pos) {
+			q.setSeen(watcherObj, cacheObj, true)
+		}
+	}
+	q.tree.QueryRadius(cacheObj.pos, q.maxVisual, func(obj aoi.IObject, pos linemath.Vector2) bool {
+		check(obj.GetAOIID())
+		return true
+	})
+	// 全局watcher不在树中
+	for id := range q.global.GetObjs() {
+		check(id)
+	}
+}
+
+// refreshWatcher watcher位置变化后, 重新计算它视野内的对象
+func (q *QuadTreeAOI) refreshWatcher(watcherObj *_CacheObject) {
+	for _, o := range watcherObj.sees {
+		if !q.inVisual(watcherObj, o.pos) {
+			q.setSeen(watcherObj, o, false)
+		}
+	}
+
+	visual := watcherObj.wrapWatcher.GetVisual()
+	if visual <= 0 {
+		return
+	}
+	q.tree.QueryRadius(watcherObj.pos, visual, func(obj aoi.IObject, pos linemath.Vector2) bool {
+		q.setSeen(watcherObj, q.objs[obj.GetAOIID()], true)
+		return true
+	})
+}
+
+func (q *QuadTreeAOI) inVisual(watcherObj *_CacheObject, pos linemath.Vector2) bool {
+	return pos.Sub(watcherObj.pos).Len() <= watcherObj.wrapWatcher.GetVisual()
+}
+
+// setSeen 修改watcher对对象的空间可见性, 重复设置忽略
+func (q *QuadTreeAOI) setSeen(watcherObj *_CacheObject, cacheObj *_CacheObject, seen bool) {
+	watcherID, id := watcherObj.obj.GetAOIID(), cacheObj.obj.GetAOIID()
+	if _, ok := cacheObj.seenBy[watcherID]; ok == seen {
+		return
+	}
+
+	if seen {
+		cacheObj.seenBy[watcherID] = watcherObj
+		watcherObj.sees[id] = cacheObj
+		watcherObj.wrapWatcher.OnObjectEnter(cacheObj.obj)
+	} else {
+		delete(cacheObj.seenBy, watcherID)
+		delete(watcherObj.sees, id)
+		watcherObj.wrapWatcher.OnObjectLeave(cacheObj.obj)
+	}
+}
+
+func (q *QuadTreeAOI) resetMaxVisual() {
+	q.maxVisual = 0
+	for _, watcherObj := range q.watchers {
+		if visual := watcherObj.wrapWatcher.GetVisual(); visual > q.maxVisual {
+			q.maxVisual = visual
+		}
+	}
+}
+
+func (q *QuadTreeAOI) isInvalid(pos linemath.Vector2) bool {
+	if pos.X < q.minPos.X || pos.X > q.maxPos.X || pos.Y < q.minPos.Y || pos.Y > q.maxPos.Y {
+		return false
+	}
+
+	return true
+}
+
+func (q *QuadTreeAOI) notifyDirty(ww *tower.WrapWatcher) {
+	q.dirtyWatchers[ww.GetAOIID()] = ww
+}
+
+func (q *QuadTreeAOI) flushWatchers() {
+	for id, w := range q.dirtyWatchers {
+		w.Flush()
+		delete(q.dirtyWatchers, id)
+	}
+}
+
+func removeGroupID(groups []int, groupID int) []int {
+	for i := range groups {
+		if groups[i] == groupID {
+			return append(groups[:i], groups[i+1:]...)
+		}
+	}
+
+	return groups
+}
@@ -0,0 +1,150 @@
+package quadtreeaoi
+
+import (
+	"aoi"
+	"aoi/aoitest"
+	"aoi/base/linemath"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func newTestAOI(t *testing.T) *QuadTreeAOI {
+	qa, err := New(&Config{
+		MinPos:         linemath.Vector2{X: -100, Y: -100},
+		MaxPos:         linemath.Vector2{X: 100, Y: 100},
+		SplitThreshold: 4,
+		MergeThreshold: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return qa.(*QuadTreeAOI)
+}
+
+func TestQuadTreeAOI_SplitMerge(t *testing.T) {
+	qa := newTestAOI(t)
+
+	objs := make([]*aoi.TestMarker, 0, 20)
+	for i := 0; i < 20; i++ {
+		o := &aoi.TestMarker{ID: fmt.Sprintf("obj:%d", i), Pos: linemath.Vector2{X: float32(i), Y: float32(i)}}
+		qa.AddToAOI(o)
+		objs = append(objs, o)
+	}
+	if qa.tree.NodeCount() <= 1 {
+		t.Fatal("tree should split")
+	}
+	if qa.tree.root.count != 20 {
+		t.Fatal("count", qa.tree.root.count)
+	}
+
+	for _, o := range objs[:18] {
+		qa.RemoveFromAOI(o)
+	}
+	if qa.tree.NodeCount() != 1 || qa.tree.root.count != 2 {
+		t.Fatal("tree should merge", qa.tree.NodeCount(), qa.tree.root.count)
+	}
+}
+
+func TestQuadTreeAOI_Visual(t *testing.T) {
+	qa := newTestAOI(t)
+
+	w := aoitest.NewWatcher("watcher", linemath.Vector2{}, 10)
+	o := &aoi.TestMarker{ID: "obj", Pos: linemath.Vector2{X: 8, Y: 0}}
+	corner := &aoi.TestMarker{ID: "corner", Pos: linemath.Vector2{X: 8, Y: 8}}
+	qa.AddToAOI(o)
+	qa.AddToAOI(w)
+	qa.AddToAOI(corner)
+	if !w.Sees("obj") || !w.Sees("watcher") || w.Sees("corner") {
+		t.Fatal("visible", w.Visible())
+	}
+
+	w.Pos = linemath.Vector2{X: 8, Y: 5}
+	qa.Move(w)
+	if !w.Sees("obj") || !w.Sees("corner") {
+		t.Fatal("watcher moved", w.Visible())
+	}
+
+	o.Pos = linemath.Vector2{X: 50, Y: 50}
+	qa.Move(o)
+	if w.Sees("obj") {
+		t.Fatal("obj moved out")
+	}
+
+	qa.AddGlobalMarker(o)
+	if !w.Sees("obj") {
+		t.Fatal("global marker should be visible")
+	}
+	qa.RemoveGlobalMarker(o)
+	if w.Sees("obj") {
+		t.Fatal("global marker removed")
+	}
+
+	groupID, _ := qa.CreateGroup([]aoi.IObject{w, o})
+	if !w.Sees("obj") {
+		t.Fatal("group member should be visible")
+	}
+	qa.DestroyGroup(groupID)
+	if w.Sees("obj") {
+		t.Fatal("group destroyed")
+	}
+
+	if qa.AddToAOI(&aoi.TestMarker{ID: "out", Pos: linemath.Vector2{X: 1000}}) != aoi.ErrPosInvalid {
+		t.Fatal("pos invalid")
+	}
+
+	qa.RemoveFromAOI(w)
+	if len(w.Visible()) != 0 {
+		t.Fatal("removed watcher still sees", w.Visible())
+	}
+}
+
+func TestQuadTreeAOI_Random(t *testing.T) {
+	qa := newTestAOI(t)
+	r := rand.New(rand.NewSource(1))
+	randPos := func() linemath.Vector2 {
+		return linemath.Vector2{X: r.Float32()*200 - 100, Y: r.Float32()*200 - 100}
+	}
+
+	var watchers []*aoitest.Watcher
+	var all []aoi.IObject
+	for i := 0; i < 100; i++ {
+		if i%4 == 0 {
+			w := aoitest.NewWatcher(fmt.Sprintf("watcher:%d", i), randPos(), 5+r.Float32()*30)
+			watchers = append(watchers, w)
+			all = append(all, w)
+			qa.AddToAOI(w)
+		} else {
+			o := &aoi.TestMarker{ID: fmt.Sprintf("obj:%d", i), Pos: randPos()}
+			all = append(all, o)
+			qa.AddToAOI(o)
+		}
+	}
+
+	for i := 0; i < 2000; i++ {
+		switch o := all[r.Intn(len(all))].(type) {
+		case *aoitest.Watcher:
+			o.Pos = randPos()
+			qa.Move(o)
+		case *aoi.TestMarker:
+			o.Pos = randPos()
+			qa.Move(o)
+		}
+	}
+
+	for _, w := range watchers {
+		for _, o := range all {
+			in := o.GetCoordPos().Sub(w.Pos).Len() <= w.Visual
+			if in != w.Sees(o.GetAOIID()) {
+				t.Fatal(w.ID, "visible mismatch", o.GetAOIID(), in)
+			}
+		}
+	}
+}
+
+func TestQuadTreeAOI_Conformance(t *testing.T) {
+	aoitest.RunConformance(t, aoitest.Factory{New: func() (aoi.IAOI, error) {
+		return New(&Config{MinPos: aoitest.MinPos, MaxPos: aoitest.MaxPos, SplitThreshold: 2})
+	}})
+}
@@ -0,0 +1,228 @@
+package recorder
+
+import (
+	"aoi"
+	"aoi/base/linemath"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// 日志格式版本, 格式变化时递增
+const logVersion uint8 = 1
+
+var logMagic = [4]byte{'A', 'R', 'E', 'C'}
+
+// 操作类型
+const (
+	opAdd uint8 = iota + 1
+	opRemove
+	opMove
+	opAddGlobal
+	opRemoveGlobal
+	opCreateGroup
+	opDestroyGroup
+	opAddToGroup
+	opRemoveFromGroup
+	opBegin
+	opCommit
+)
+
+const (
+	kindMarker  uint8 = 0
+	kindWatcher uint8 = 1
+)
+
+var (
+	ErrLogInvalid = errors.New("log invalid")
+	ErrLogVersion = errors.New("log version not supported")
+)
+
+// Recorder 包装任意的aoi.IAOI, 把所有修改操作(对象ID, 位置, 视野)记录到日志中, 再交给被包装的AOI
+// 日志很紧凑: 整数用varint, 坐标和视野用float32, 可以一直开着, 出问题时交给Replay离线重现
+type Recorder struct {
+	aoi.IAOI
+
+	w   io.Writer
+	buf []byte
+	err error
+}
+
+// New 开始记录, 先写入日志头
+func New(a aoi.IAOI, w io.Writer) *Recorder {
+	r := &Recorder{IAOI: a, w: w}
+	r.buf = append(r.buf, logMagic[:]...)
+	r.buf = append(r.buf, logVersion)
+	r.flush()
+
+	return r
+}
+
+// Err 写日志时遇到的第一个错误, 之后不再记录
+func (r *Recorder) Err() error {
+	return r.err
+}
+
+func (r *Recorder) AddToAOI(obj aoi.IObject) error {
+	r.appendObj(opAdd, obj)
+	return r.IAOI.AddToAOI(obj)
+}
+
+func (r *Recorder) RemoveFromAOI(obj aoi.IObject) error {
+	r.appendObjOp(opRemove, obj)
+	return r.IAOI.RemoveFromAOI(obj)
+}
+
+// Move 同时记录watcher当前的视野
+func (r *Recorder) Move(obj aoi.IObject) error {
+	r.appendObj(opMove, obj)
+	return r.IAOI.Move(obj)
+}
+
+func (r *Recorder) AddGlobalMarker(obj aoi.IObject) error {
+	r.appendObjOp(opAddGlobal, obj)
+	return r.IAOI.AddGlobalMarker(obj)
+}
+
+func (r *Recorder) RemoveGlobalMarker(obj aoi.IObject) error {
+	if obj != nil {
+		r.buf = append(r.buf, opRemoveGlobal)
+		r.appendString(obj.GetAOIID())
+		r.appendPos(obj.GetCoordPos())
+		r.flush()
+	}
+
+	return r.IAOI.RemoveGlobalMarker(obj)
+}
+
+// CreateGroup 记录返回的群组ID, 重放时映射到新AOI的群组ID
+func (r *Recorder) CreateGroup(objs []aoi.IObject) (int, error) {
+	groupID, err := r.IAOI.CreateGroup(objs)
+
+	r.buf = append(r.buf, opCreateGroup)
+	r.appendInt(int64(groupID))
+	r.appendUint(uint64(len(objs)))
+	for _, o := range objs {
+		// nil对象记录为空ID, 重放时还原成nil
+		if o == nil {
+			r.appendString("")
+		} else {
+			r.appendString(o.GetAOIID())
+		}
+	}
+	r.flush()
+
+	return groupID, err
+}
+
+func (r *Recorder) DestroyGroup(groupID int) error {
+	r.buf = append(r.buf, opDestroyGroup)
+	r.appendInt(int64(groupID))
+	r.flush()
+
+	return r.IAOI.DestroyGroup(groupID)
+}
+
+func (r *Recorder) AddToGroup(obj aoi.IObject, groupID int) error {
+	r.appendGroupOp(opAddToGroup, obj, groupID)
+	return r.IAOI.AddToGroup(obj, groupID)
+}
+
+func (r *Recorder) RemoveFromGroup(obj aoi.IObject, groupID int) error {
+	r.appendGroupOp(opRemoveFromGroup, obj, groupID)
+	return r.IAOI.RemoveFromGroup(obj, groupID)
+}
+
+// Begin 被包装的AOI支持批量操作时才转发
+func (r *Recorder) Begin() {
+	if b, ok := r.IAOI.(aoi.IAOIBatch); ok {
+		r.buf = append(r.buf, opBegin)
+		r.flush()
+		b.Begin()
+	}
+}
+
+func (r *Recorder) Commit() {
+	if b, ok := r.IAOI.(aoi.IAOIBatch); ok {
+		r.buf = append(r.buf, opCommit)
+		r.flush()
+		b.Commit()
+	}
+}
+
+// appendObj 记录对象的类型和位置, watcher还记录视野
+func (r *Recorder) appendObj(op uint8, obj aoi.IObject) {
+	if obj == nil {
+		return
+	}
+
+	r.buf = append(r.buf, op)
+	r.appendString(obj.GetAOIID())
+	if w, ok := obj.(aoi.IWatcher); ok {
+		r.buf = append(r.buf, kindWatcher)
+		r.appendPos(obj.GetCoordPos())
+		r.appendFloat(w.GetVisual())
+	} else {
+		r.buf = append(r.buf, kindMarker)
+		r.appendPos(obj.GetCoordPos())
+	}
+	r.flush()
+}
+
+func (r *Recorder) appendObjOp(op uint8, obj aoi.IObject) {
+	if obj == nil {
+		return
+	}
+
+	r.buf = append(r.buf, op)
+	r.appendString(obj.GetAOIID())
+	r.flush()
+}
+
+func (r *Recorder) appendGroupOp(op uint8, obj aoi.IObject, groupID int) {
+	if obj == nil {
+		return
+	}
+
+	r.buf = append(r.buf, op)
+	r.appendString(obj.GetAOIID())
+	r.appendInt(int64(groupID))
+	r.flush()
+}
+
+func (r *Recorder) appendUint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	r.buf = append(r.buf, b[:n]...)
+}
+
+func (r *Recorder) appendInt(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], v)
+	r.buf = append(r.buf, b[:n]...)
+}
+
+func (r *Recorder) appendString(s string) {
+	r.appendUint(uint64(len(s)))
+	r.buf = append(r.buf, s...)
+}
+
+func (r *Recorder) appendFloat(v float32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], math.Float32bits(v))
+	r.buf = append(r.buf, b[:]...)
+}
+
+func (r *Recorder) appendPos(pos linemath.Vector2) {
+	r.appendFloat(pos.X)
+	r.appendFloat(pos.Y)
+}
+
+// flush 每个操作写一次, 出错后只清空缓冲
+func (r *Recorder) flush() {
+	if r.err == nil {
+		_, r.err = r.w.Write(r.buf)
+	}
+	r.buf = r.buf[:0]
+}
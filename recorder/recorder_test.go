@@ -0,0 +1,223 @@
+package recorder
+
+import (
+	"aoi"
+	"aoi/aoitest"
+	"aoi/base/linemath"
+	"aoi/toweraoi"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// eventWatcher 按watcher记录收到的通知, 和重放得到的Event比较
+type eventWatcher struct {
+	aoi.TestWatcher
+	events map[string][]string
+}
+
+func (w *eventWatcher) OnBatchEnter(objs []aoi.IObject) {
+	if len(objs) == 0 {
+		return
+	}
+	w.events[w.ID] = append(w.events[w.ID], formatEvent(true, ids(objs)))
+}
+
+func (w *eventWatcher) OnBatchLeave(objs []aoi.IObject) {
+	if len(objs) == 0 {
+		return
+	}
+	w.events[w.ID] = append(w.events[w.ID], formatEvent(false, ids(objs)))
+}
+
+func ids(objs []aoi.IObject) []string {
+	s := make([]string, 0, len(objs))
+	for _, o := range objs {
+		s = append(s, o.GetAOIID())
+	}
+
+	return s
+}
+
+func formatEvent(enter bool, objs []string) string {
+	s := append([]string(nil), objs...)
+	sort.Strings(s)
+	if enter {
+		return "enter " + strings.Join(s, ",")
+	}
+	return "leave " + strings.Join(s, ",")
+}
+
+func newTowerAOI(t *testing.T) aoi.IAOI {
+	ta, err := toweraoi.New(&toweraoi.Config{
+		MinPos:    linemath.Vector2{X: -50, Y: -50},
+		MaxPos:    linemath.Vector2{X: 50, Y: 50},
+		TowerSize: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ta
+}
+
+func TestRecorder_Replay(t *testing.T) {
+	var log bytes.Buffer
+	rec := New(newTowerAOI(t), &log)
+
+	recorded := make(map[string][]string)
+	objs := make([]aoi.IObject, 0, 20)
+	randPos := func(r *rand.Rand) linemath.Vector2 {
+		return linemath.Vector2{X: r.Float32()*100 - 50, Y: r.Float32()*100 - 50}
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		id := fmt.Sprintf("obj:%d", i)
+		if i%2 == 0 {
+			objs = append(objs, &eventWatcher{
+				TestWatcher: aoi.TestWatcher{ID: id, Pos: randPos(r), Visual: 15},
+				events:      recorded,
+			})
+		} else {
+			objs = append(objs, &aoi.TestMarker{ID: id, Pos: randPos(r)})
+		}
+		if err := rec.AddToAOI(objs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 重复加入和不存在的群组也记录下来, 重放时同样失败
+	if err := rec.AddToAOI(objs[0]); err != aoi.ErrObjectExisted {
+		t.Fatal("expected ErrObjectExisted, got", err)
+	}
+	if err := rec.AddToGroup(objs[1], 100); err != aoi.ErrGroupNotExisted {
+		t.Fatal("expected ErrGroupNotExisted, got", err)
+	}
+
+	groupID, err := rec.CreateGroup([]aoi.IObject{objs[0], objs[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.AddToGroup(objs[2], groupID); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.AddGlobalMarker(objs[3]); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 200; i++ {
+		o := objs[r.Intn(len(objs))]
+		switch v := o.(type) {
+		case *eventWatcher:
+			v.Pos = randPos(r)
+			if r.Intn(4) == 0 {
+				v.Visual = 5 + r.Float32()*20
+			}
+		case *aoi.TestMarker:
+			v.Pos = randPos(r)
+		}
+
+		if i%50 == 0 {
+			rec.Begin()
+			rec.Move(o)
+			rec.RemoveFromAOI(objs[5])
+			rec.AddToAOI(objs[5])
+			rec.Commit()
+			continue
+		}
+		if err := rec.Move(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	objs[3].(*aoi.TestMarker).Pos = linemath.Vector2{X: 1, Y: 1}
+	if err := rec.RemoveGlobalMarker(objs[3]); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.RemoveFromGroup(objs[1], groupID); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.DestroyGroup(groupID); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.RemoveFromAOI(objs[4]); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Err() != nil {
+		t.Fatal(rec.Err())
+	}
+
+	// 先占用一个群组ID, 验证重放时群组ID的映射
+	replayAOI := newTowerAOI(t)
+	dummy := &aoi.TestMarker{ID: "dummy"}
+	replayAOI.AddToAOI(dummy)
+	dummyGroup, err := replayAOI.CreateGroup([]aoi.IObject{dummy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayAOI.DestroyGroup(dummyGroup)
+	replayAOI.RemoveFromAOI(dummy)
+
+	replayed := make(map[string][]string)
+	lastSeq := -1
+	err = Replay(bytes.NewReader(log.Bytes()), replayAOI, func(e Event) {
+		if e.Seq < lastSeq {
+			t.Fatal("event seq out of order", e.Seq, lastSeq)
+		}
+		lastSeq = e.Seq
+		replayed[e.Watcher] = append(replayed[e.Watcher], formatEvent(e.Enter, e.Objs))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recorded) == 0 {
+		t.Fatal("no events recorded")
+	}
+	for id := range recorded {
+		if !reflect.DeepEqual(recorded[id], replayed[id]) {
+			t.Errorf("watcher %s events mismatch\nrecorded %v\nreplayed %v", id, recorded[id], replayed[id])
+		}
+	}
+	if len(recorded) != len(replayed) {
+		t.Errorf("recorded %d watchers, replayed %d", len(recorded), len(replayed))
+	}
+}
+
+func TestRecorder_InvalidLog(t *testing.T) {
+	if err := Replay(bytes.NewReader([]byte("AREC")), newTowerAOI(t), nil); err != ErrLogInvalid {
+		t.Fatal("expected ErrLogInvalid, got", err)
+	}
+	if err := Replay(bytes.NewReader([]byte("XREC\x01")), newTowerAOI(t), nil); err != ErrLogInvalid {
+		t.Fatal("expected ErrLogInvalid, got", err)
+	}
+	if err := Replay(bytes.NewReader([]byte("AREC\x02")), newTowerAOI(t), nil); err != ErrLogVersion {
+		t.Fatal("expected ErrLogVersion, got", err)
+	}
+
+	var log bytes.Buffer
+	rec := New(newTowerAOI(t), &log)
+	rec.AddToAOI(&aoi.TestMarker{ID: "obj:1"})
+
+	// 截断的操作
+	truncated := log.Bytes()[:log.Len()-2]
+	if err := Replay(bytes.NewReader(truncated), newTowerAOI(t), nil); err != ErrLogInvalid {
+		t.Fatal("expected ErrLogInvalid, got", err)
+	}
+}
+
+func TestRecorder_Conformance(t *testing.T) {
+	aoitest.RunConformance(t, aoitest.Factory{New: func() (aoi.IAOI, error) {
+		a, err := toweraoi.New(&toweraoi.Config{MinPos: aoitest.MinPos, MaxPos: aoitest.MaxPos, TowerSize: 10})
+		if err != nil {
+			return nil, err
+		}
+		return New(a, ioutil.Discard), nil
+	}})
+}
@@ -0,0 +1,372 @@
+package recorder
+
+import (
+	"aoi"
+	"aoi/base/linemath"
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Event 重放过程中watcher收到的一次通知
+type Event struct {
+	Seq     int      // 触发通知的操作在日志中的序号, 从0开始
+	Watcher string   // 收到通知的watcher
+	Enter   bool     // true为进入, false为离开
+	Objs    []string // 进入或离开的对象ID
+}
+
+// Replay 在一个新的AOI上重新执行日志中的操作, 对象用只有ID, 位置和视野的桩对象代替
+// watcher收到的每次通知都交给onEvent. 操作本身的错误被忽略, 因为记录时的调用也可能失败
+// 日志中的群组ID映射到新AOI创建的群组ID
+func Replay(r io.Reader, a aoi.IAOI, onEvent func(Event)) error {
+	p := &_Replayer{
+		r:       bufio.NewReader(r),
+		aoi:     a,
+		onEvent: onEvent,
+		objs:    make(map[string]aoi.IObject),
+		groups:  make(map[int]int),
+	}
+
+	if err := p.readHeader(); err != nil {
+		return err
+	}
+
+	for ; ; p.seq++ {
+		op, err := p.r.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := p.replay(op); err != nil {
+			return err
+		}
+	}
+}
+
+type _Replayer struct {
+	r       *bufio.Reader
+	aoi     aoi.IAOI
+	onEvent func(Event)
+
+	seq    int
+	objs   map[string]aoi.IObject
+	groups map[int]int // 日志中的群组ID -> 新AOI的群组ID
+}
+
+func (p *_Replayer) readHeader() error {
+	var header [len(logMagic) + 1]byte
+	if _, err := io.ReadFull(p.r, header[:]); err != nil {
+		return ErrLogInvalid
+	}
+
+	for i := range logMagic {
+		if header[i] != logMagic[i] {
+			return ErrLogInvalid
+		}
+	}
+	if header[len(logMagic)] != logVersion {
+		return ErrLogVersion
+	}
+
+	return nil
+}
+
+func (p *_Replayer) replay(op uint8) error {
+	switch op {
+	case opAdd:
+		obj, err := p.readObj()
+		if err != nil {
+			return err
+		}
+		if _, ok := p.objs[obj.GetAOIID()]; ok {
+			_ = p.aoi.AddToAOI(obj)
+			return nil
+		}
+		if p.aoi.AddToAOI(obj) == nil {
+			p.objs[obj.GetAOIID()] = obj
+		}
+	case opMove:
+		obj, err := p.readObj()
+		if err != nil {
+			return err
+		}
+		_ = p.aoi.Move(p.update(obj))
+	case opRemove:
+		obj, err := p.readID()
+		if err != nil {
+			return err
+		}
+		if p.aoi.RemoveFromAOI(obj) == nil {
+			delete(p.objs, obj.GetAOIID())
+		}
+	case opAddGlobal:
+		obj, err := p.readID()
+		if err != nil {
+			return err
+		}
+		_ = p.aoi.AddGlobalMarker(obj)
+	case opRemoveGlobal:
+		obj, err := p.readID()
+		if err != nil {
+			return err
+		}
+		pos, err := p.readPos()
+		if err != nil {
+			return err
+		}
+		setPos(obj, pos)
+		_ = p.aoi.RemoveGlobalMarker(obj)
+	case opCreateGroup:
+		return p.replayCreateGroup()
+	case opDestroyGroup:
+		groupID, err := p.readInt()
+		if err != nil {
+			return err
+		}
+		if p.aoi.DestroyGroup(p.mapGroup(groupID)) == nil {
+			delete(p.groups, groupID)
+		}
+	case opAddToGroup, opRemoveFromGroup:
+		obj, err := p.readID()
+		if err != nil {
+			return err
+		}
+		groupID, err := p.readInt()
+		if err != nil {
+			return err
+		}
+		if op == opAddToGroup {
+			_ = p.aoi.AddToGroup(obj, p.mapGroup(groupID))
+		} else {
+			_ = p.aoi.RemoveFromGroup(obj, p.mapGroup(groupID))
+		}
+	case opBegin:
+		if b, ok := p.aoi.(aoi.IAOIBatch); ok {
+			b.Begin()
+		}
+	case opCommit:
+		if b, ok := p.aoi.(aoi.IAOIBatch); ok {
+			b.Commit()
+		}
+	default:
+		return ErrLogInvalid
+	}
+
+	return nil
+}
+
+func (p *_Replayer) replayCreateGroup() error {
+	groupID, err := p.readInt()
+	if err != nil {
+		return err
+	}
+	n, err := binary.ReadUvarint(p.r)
+	if err != nil {
+		return ErrLogInvalid
+	}
+
+	objs := make([]aoi.IObject, 0, n)
+	for i := uint64(0); i < n; i++ {
+		id, err := p.readString()
+		if err != nil {
+			return err
+		}
+		if id == "" {
+			objs = append(objs, nil)
+		} else {
+			objs = append(objs, p.find(id))
+		}
+	}
+
+	if newID, err := p.aoi.CreateGroup(objs); err == nil {
+		p.groups[groupID] = newID
+	}
+
+	return nil
+}
+
+// mapGroup 没有创建成功的群组映射到一个不存在的ID, 保证新AOI也返回错误
+func (p *_Replayer) mapGroup(groupID int) int {
+	if newID, ok := p.groups[groupID]; ok {
+		return newID
+	}
+
+	return math.MinInt32
+}
+
+// find 日志中的对象不在新AOI中时(记录时的调用失败了), 用一个新的桩对象代替
+func (p *_Replayer) find(id string) aoi.IObject {
+	if obj, ok := p.objs[id]; ok {
+		return obj
+	}
+
+	return &_StubMarker{id: id}
+}
+
+// update 把读到的位置和视野更新到已有的桩对象上
+func (p *_Replayer) update(obj aoi.IObject) aoi.IObject {
+	old, ok := p.objs[obj.GetAOIID()]
+	if !ok {
+		return obj
+	}
+
+	setPos(old, obj.GetCoordPos())
+	if w, ok := obj.(*_StubWatcher); ok {
+		if oldW, ok := old.(*_StubWatcher); ok {
+			oldW.visual = w.visual
+		}
+	}
+
+	return old
+}
+
+func (p *_Replayer) readObj() (aoi.IObject, error) {
+	id, err := p.readString()
+	if err != nil {
+		return nil, err
+	}
+	kind, err := p.r.ReadByte()
+	if err != nil {
+		return nil, ErrLogInvalid
+	}
+	pos, err := p.readPos()
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case kindMarker:
+		return &_StubMarker{id: id, pos: pos}, nil
+	case kindWatcher:
+		visual, err := p.readFloat()
+		if err != nil {
+			return nil, err
+		}
+		return &_StubWatcher{_StubMarker: _StubMarker{id: id, pos: pos}, visual: visual, p: p}, nil
+	}
+
+	return nil, ErrLogInvalid
+}
+
+func (p *_Replayer) readID() (aoi.IObject, error) {
+	id, err := p.readString()
+	if err != nil {
+		return nil, err
+	}
+
+	return p.find(id), nil
+}
+
+func (p *_Replayer) readInt() (int, error) {
+	v, err := binary.ReadVarint(p.r)
+	if err != nil {
+		return 0, ErrLogInvalid
+	}
+
+	return int(v), nil
+}
+
+func (p *_Replayer) readString() (string, error) {
+	n, err := binary.ReadUvarint(p.r)
+	if err != nil {
+		return "", ErrLogInvalid
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(p.r, b); err != nil {
+		return "", ErrLogInvalid
+	}
+
+	return string(b), nil
+}
+
+func (p *_Replayer) readFloat() (float32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(p.r, b[:]); err != nil {
+		return 0, ErrLogInvalid
+	}
+
+	return math.Float32frombits(binary.LittleEndian.Uint32(b[:])), nil
+}
+
+func (p *_Replayer) readPos() (linemath.Vector2, error) {
+	x, err := p.readFloat()
+	if err != nil {
+		return linemath.Vector2{}, err
+	}
+	y, err := p.readFloat()
+	if err != nil {
+		return linemath.Vector2{}, err
+	}
+
+	return linemath.Vector2{X: x, Y: y}, nil
+}
+
+func (p *_Replayer) notify(watcher string, enter bool, objs []aoi.IObject) {
+	if p.onEvent == nil || len(objs) == 0 {
+		return
+	}
+
+	ids := make([]string, 0, len(objs))
+	for _, o := range objs {
+		ids = append(ids, o.GetAOIID())
+	}
+
+	p.onEvent(Event{Seq: p.seq, Watcher: watcher, Enter: enter, Objs: ids})
+}
+
+// _StubMarker 重放用的桩对象, 只有ID和位置
+type _StubMarker struct {
+	id  string
+	pos linemath.Vector2
+}
+
+func (m *_StubMarker) GetAOIID() string {
+	return m.id
+}
+
+func (m *_StubMarker) GetCoordPos() linemath.Vector2 {
+	return m.pos
+}
+
+// _StubWatcher 重放用的watcher, 把收到的通知转成Event
+type _StubWatcher struct {
+	_StubMarker
+
+	visual float32
+	p      *_Replayer
+}
+
+func (w *_StubWatcher) GetVisual() float32 {
+	return w.visual
+}
+
+func (w *_StubWatcher) OnObjectEnter(obj aoi.IObject) {
+	w.p.notify(w.id, true, []aoi.IObject{obj})
+}
+
+func (w *_StubWatcher) OnObjectLeave(obj aoi.IObject) {
+	w.p.notify(w.id, false, []aoi.IObject{obj})
+}
+
+func (w *_StubWatcher) OnBatchEnter(objs []aoi.IObject) {
+	w.p.notify(w.id, true, objs)
+}
+
+func (w *_StubWatcher) OnBatchLeave(objs []aoi.IObject) {
+	w.p.notify(w.id, false, objs)
+}
+
+func setPos(obj aoi.IObject, pos linemath.Vector2) {
+	switch o := obj.(type) {
+	case *_StubMarker:
+		o.pos = pos
+	case *_StubWatcher:
+		o.pos = pos
+	}
+}
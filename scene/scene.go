@@ -0,0 +1,259 @@
+package scene
+
+import (
+	"aoi"
+	"errors"
+	"sort"
+)
+
+// Manager 管理多个AOI实例(场景), 按地图ID索引, 记录每个对象所在的场景
+type Manager struct {
+	templates map[string]*Template
+	scenes    map[int]*_Scene
+	objs      map[string]int // 对象所在场景的地图ID
+}
+
+// Template 创建场景的模板
+type Template struct {
+	Factory func() (aoi.IAOI, error)
+
+	// Persistent 常驻场景, 没有对象时也不会被Reclaim回收
+	Persistent bool
+}
+
+type _Scene struct {
+	aoi      aoi.IAOI
+	view     *_SceneAOI // 对外返回的AOI
+	template *Template
+	objs     map[string]aoi.IObject
+}
+
+var (
+	ErrTemplateInvalid    = errors.New("template invalid")
+	ErrTemplateExisted    = errors.New("template existed")
+	ErrTemplateNotExisted = errors.New("template not existed")
+	ErrSceneExisted       = errors.New("scene existed")
+	ErrSceneNotExisted    = errors.New("scene not existed")
+	ErrObjectNotInScene   = errors.New("object not in scene")
+)
+
+func NewManager() *Manager {
+	return &Manager{
+		templates: make(map[string]*Template),
+		scenes:    make(map[int]*_Scene),
+		objs:      make(map[string]int),
+	}
+}
+
+func (m *Manager) RegisterTemplate(name string, template *Template) error {
+	if template == nil || template.Factory == nil {
+		return ErrTemplateInvalid
+	}
+
+	if _, ok := m.templates[name]; ok {
+		return ErrTemplateExisted
+	}
+
+	m.templates[name] = template
+
+	return nil
+}
+
+// CreateScene 按模板为地图创建AOI实例, 返回的AOI添加和移除对象等同于Enter和Leave,
+// 场景销毁后的操作返回ErrSceneNotExisted. 返回值只实现aoi.IAOI, 不能断言为具体的AOI类型
+func (m *Manager) CreateScene(mapID int, template string) (aoi.IAOI, error) {
+	if _, ok := m.scenes[mapID]; ok {
+		return nil, ErrSceneExisted
+	}
+
+	t, ok := m.templates[template]
+	if !ok {
+		return nil, ErrTemplateNotExisted
+	}
+
+	a, err := t.Factory()
+	if err != nil {
+		return nil, err
+	}
+
+	scene := &_Scene{
+		aoi:      a,
+		view:     &_SceneAOI{m: m, mapID: mapID},
+		template: t,
+		objs:     make(map[string]aoi.IObject),
+	}
+	m.scenes[mapID] = scene
+
+	return scene.view, nil
+}
+
+// DestroyScene 移除场景中所有的对象(watcher会收到离开)后销毁场景
+func (m *Manager) DestroyScene(mapID int) error {
+	scene, ok := m.scenes[mapID]
+	if !ok {
+		return ErrSceneNotExisted
+	}
+
+	m.clearScene(scene)
+	delete(m.scenes, mapID)
+
+	return nil
+}
+
+// clearScene 移除场景中所有的对象
+func (m *Manager) clearScene(scene *_Scene) {
+	batch, isBatch := scene.aoi.(aoi.IAOIBatch)
+	if isBatch {
+		batch.Begin()
+	}
+	for id, obj := range scene.objs {
+		scene.aoi.RemoveFromAOI(obj)
+		delete(scene.objs, id)
+		delete(m.objs, id)
+	}
+	if isBatch {
+		batch.Commit()
+	}
+}
+
+// GetScene 地图对应的AOI实例, 和CreateScene返回的相同, 不存在返回nil
+func (m *Manager) GetScene(mapID int) aoi.IAOI {
+	if scene, ok := m.scenes[mapID]; ok {
+		return scene.view
+	}
+
+	return nil
+}
+
+// SceneOf 对象所在场景的地图ID
+func (m *Manager) SceneOf(obj aoi.IObject) (int, bool) {
+	mapID, ok := m.objs[obj.GetAOIID()]
+	return mapID, ok
+}
+
+// Enter 对象进入场景, 同一时间只能在一个场景中
+func (m *Manager) Enter(obj aoi.IObject, mapID int) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	if _, ok := m.objs[obj.GetAOIID()]; ok {
+		return aoi.ErrObjectExisted
+	}
+
+	scene, ok := m.scenes[mapID]
+	if !ok {
+		return ErrSceneNotExisted
+	}
+
+	if err := scene.aoi.AddToAOI(obj); err != nil {
+		return err
+	}
+
+	scene.objs[obj.GetAOIID()] = obj
+	m.objs[obj.GetAOIID()] = mapID
+
+	return nil
+}
+
+// Leave 对象离开所在的场景
+func (m *Manager) Leave(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	mapID, ok := m.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	scene := m.scenes[mapID]
+	if err := scene.aoi.RemoveFromAOI(obj); err != nil {
+		return err
+	}
+
+	delete(scene.objs, obj.GetAOIID())
+	delete(m.objs, obj.GetAOIID())
+
+	return nil
+}
+
+// Move 对象在所在的场景中移动
+func (m *Manager) Move(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	mapID, ok := m.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	return m.scenes[mapID].aoi.Move(obj)
+}
+
+// Transfer 对象从from场景切换到to场景, 旧场景的watcher收到离开, 新场景的收到进入,
+// 对象是watcher时先离开旧场景的所有对象再进入新场景的. 进入新场景失败时回到旧场景
+func (m *Manager) Transfer(obj aoi.IObject, from, to int) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	mapID, ok := m.objs[obj.GetAOIID()]
+	if !ok || mapID != from {
+		return ErrObjectNotInScene
+	}
+
+	if from == to {
+		return nil
+	}
+
+	toScene, ok := m.scenes[to]
+	if !ok {
+		return ErrSceneNotExisted
+	}
+
+	fromScene := m.scenes[from]
+	if err := fromScene.aoi.RemoveFromAOI(obj); err != nil {
+		return err
+	}
+	delete(fromScene.objs, obj.GetAOIID())
+
+	if err := toScene.aoi.AddToAOI(obj); err != nil {
+		if fromScene.aoi.AddToAOI(obj) == nil {
+			fromScene.objs[obj.GetAOIID()] = obj
+		} else {
+			delete(m.objs, obj.GetAOIID())
+		}
+		return err
+	}
+
+	toScene.objs[obj.GetAOIID()] = obj
+	m.objs[obj.GetAOIID()] = to
+
+	return nil
+}
+
+// Reclaim 销毁所有没有对象的非常驻场景, 返回销毁的地图ID, 从小到大排列.
+// 对象数量以Manager记录的为准, 销毁前仍然会清理场景, 保证AOI中不留下对象
+func (m *Manager) Reclaim() []int {
+	var reclaimed []int
+	for mapID, scene := range m.scenes {
+		if len(scene.objs) == 0 && !scene.template.Persistent {
+			reclaimed = append(reclaimed, mapID)
+		}
+	}
+	sort.Ints(reclaimed)
+
+	for _, mapID := range reclaimed {
+		m.clearScene(m.scenes[mapID])
+		delete(m.scenes, mapID)
+	}
+
+	return reclaimed
+}
+
+// SceneCount 当前场景数量
+func (m *Manager) SceneCount() int {
+	return len(m.scenes)
+}
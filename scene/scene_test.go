@@ -0,0 +1,196 @@
+package scene
+
+import (
+	"aoi"
+	"aoi/aoitest"
+	"aoi/base/linemath"
+	"aoi/toweraoi"
+	"testing"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	m := NewManager()
+	dungeon := &Template{Factory: func() (aoi.IAOI, error) {
+		return toweraoi.New(&toweraoi.Config{
+			MinPos:    linemath.Vector2{X: -50, Y: -50},
+			MaxPos:    linemath.Vector2{X: 50, Y: 50},
+			TowerSize: 10,
+		})
+	}}
+	if err := m.RegisterTemplate("dungeon", dungeon); err != nil {
+		t.Fatal(err)
+	}
+	if m.RegisterTemplate("dungeon", dungeon) != ErrTemplateExisted {
+		t.Fatal("template existed")
+	}
+
+	return m
+}
+
+func TestManager_Transfer(t *testing.T) {
+	m := newTestManager(t)
+	m.CreateScene(1, "dungeon")
+	m.CreateScene(2, "dungeon")
+	if _, err := m.CreateScene(1, "dungeon"); err != ErrSceneExisted {
+		t.Fatal("scene existed", err)
+	}
+	if _, err := m.CreateScene(3, "town"); err != ErrTemplateNotExisted {
+		t.Fatal("template not existed", err)
+	}
+
+	player := aoitest.NewWatcher("player", linemath.Vector2{}, 20)
+	npc1 := &aoi.TestMarker{ID: "npc1", Pos: linemath.Vector2{X: 5}}
+	guard := aoitest.NewWatcher("guard", linemath.Vector2{X: -5}, 20)
+	m.Enter(player, 1)
+	m.Enter(npc1, 1)
+	m.Enter(guard, 2)
+	if m.Enter(player, 2) != aoi.ErrObjectExisted {
+		t.Fatal("in two scenes")
+	}
+	if !player.Sees("npc1") || player.Sees("guard") {
+		t.Fatal("scenes are isolated", player.Visible())
+	}
+
+	if m.Transfer(player, 2, 1) != ErrObjectNotInScene {
+		t.Fatal("wrong from")
+	}
+	if err := m.Transfer(player, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if mapID, _ := m.SceneOf(player); mapID != 2 {
+		t.Fatal("SceneOf", mapID)
+	}
+	if player.Sees("npc1") || !player.Sees("guard") || !guard.Sees("player") {
+		t.Fatal("transfer", player.Visible(), guard.Visible())
+	}
+
+	// 新场景的位置不合法时回到原来的场景
+	m.RegisterTemplate("room", &Template{Factory: func() (aoi.IAOI, error) {
+		return toweraoi.New(&toweraoi.Config{
+			MinPos:    linemath.Vector2{X: -10, Y: -10},
+			MaxPos:    linemath.Vector2{X: 10, Y: 10},
+			TowerSize: 10,
+		})
+	}})
+	player.Pos = linemath.Vector2{X: 30}
+	m.Move(player)
+	m.CreateScene(3, "room")
+	if m.Transfer(player, 2, 3) != aoi.ErrPosInvalid {
+		t.Fatal("pos invalid")
+	}
+	if mapID, _ := m.SceneOf(player); mapID != 2 {
+		t.Fatal("rollback", mapID)
+	}
+
+	m.Leave(npc1)
+	if reclaimed := m.Reclaim(); len(reclaimed) != 2 || reclaimed[0] != 1 || reclaimed[1] != 3 || m.GetScene(1) != nil {
+		t.Fatal("reclaim", reclaimed)
+	}
+
+	if m.DestroyScene(2) != nil || m.SceneCount() != 0 {
+		t.Fatal("destroy")
+	}
+	if len(guard.Visible()) != 0 {
+		t.Fatal("destroyed scene", guard.Visible())
+	}
+	if _, ok := m.SceneOf(player); ok {
+		t.Fatal("player still in scene")
+	}
+}
+
+func TestManager_Persistent(t *testing.T) {
+	m := newTestManager(t)
+	m.RegisterTemplate("town", &Template{
+		Factory:    m.templates["dungeon"].Factory,
+		Persistent: true,
+	})
+	m.CreateScene(1, "town")
+	m.CreateScene(2, "dungeon")
+	if reclaimed := m.Reclaim(); len(reclaimed) != 1 || reclaimed[0] != 2 {
+		t.Fatal("reclaim", reclaimed)
+	}
+	if m.GetScene(1) == nil {
+		t.Fatal("persistent scene reclaimed")
+	}
+	if m.RegisterTemplate("bad", &Template{}) != ErrTemplateInvalid {
+		t.Fatal("invalid template")
+	}
+}
+
+func TestManager_SceneAOI(t *testing.T) {
+	m := newTestManager(t)
+	a, _ := m.CreateScene(1, "dungeon")
+	m.CreateScene(2, "dungeon")
+
+	// 直接通过场景AOI添加的对象也记录在Manager中, 不会被回收
+	w := aoitest.NewWatcher("w", linemath.Vector2{}, 20)
+	o := &aoi.TestMarker{ID: "o", Pos: linemath.Vector2{X: 5}}
+	if a.AddToAOI(w) != nil || m.GetScene(1).AddToAOI(o) != nil {
+		t.Fatal("add")
+	}
+	if mapID, ok := m.SceneOf(o); !ok || mapID != 1 || !w.Sees("o") {
+		t.Fatal("SceneOf", mapID, w.Visible())
+	}
+	if m.Enter(o, 2) != aoi.ErrObjectExisted || m.GetScene(2).RemoveFromAOI(o) != aoi.ErrObjectNotExisted {
+		t.Fatal("other scene")
+	}
+	if reclaimed := m.Reclaim(); len(reclaimed) != 1 || reclaimed[0] != 2 {
+		t.Fatal("reclaim", reclaimed)
+	}
+
+	if a.RemoveFromAOI(o) != nil || w.Sees("o") {
+		t.Fatal("remove", w.Visible())
+	}
+	if _, ok := m.SceneOf(o); ok {
+		t.Fatal("removed object still in scene")
+	}
+	if reclaimed := m.Reclaim(); len(reclaimed) != 0 {
+		t.Fatal("scene with watcher reclaimed", reclaimed)
+	}
+}
+
+func TestManager_DestroyedView(t *testing.T) {
+	m := newTestManager(t)
+	a, _ := m.CreateScene(1, "dungeon")
+	w := aoitest.NewWatcher("w", linemath.Vector2{}, 20)
+	o := &aoi.TestMarker{ID: "o", Pos: linemath.Vector2{X: 5}}
+	a.AddToAOI(w)
+	a.AddToAOI(o)
+	groupID, err := a.CreateGroup([]aoi.IObject{w})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.DestroyScene(1)
+
+	// 销毁后通过旧的AOI操作都返回错误, 不会影响已经销毁的实例
+	errs := map[string]error{
+		"AddToAOI":           a.AddToAOI(o),
+		"RemoveFromAOI":      a.RemoveFromAOI(o),
+		"Move":               a.Move(o),
+		"AddGlobalMarker":    a.AddGlobalMarker(o),
+		"RemoveGlobalMarker": a.RemoveGlobalMarker(o),
+		"DestroyGroup":       a.DestroyGroup(groupID),
+		"AddToGroup":         a.AddToGroup(o, groupID),
+		"RemoveFromGroup":    a.RemoveFromGroup(w, groupID),
+	}
+	_, errs["CreateGroup"] = a.CreateGroup([]aoi.IObject{o})
+	for op, err := range errs {
+		if err != ErrSceneNotExisted {
+			t.Fatal(op, err)
+		}
+	}
+	a.Traversal(o, func(watcher aoi.IWatcher) bool {
+		t.Fatal("traversal after destroy")
+		return true
+	})
+	a.TraversalGroup(w, groupID, func(watcher aoi.IWatcher) bool {
+		t.Fatal("group traversal after destroy")
+		return true
+	})
+
+	// 同一个地图ID重新创建的场景只能通过新的AOI访问
+	b, _ := m.CreateScene(1, "dungeon")
+	if a.AddToAOI(o) != ErrSceneNotExisted || b.AddToAOI(o) != nil || m.GetScene(1) != b {
+		t.Fatal("recreated scene")
+	}
+}
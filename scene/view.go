@@ -0,0 +1,131 @@
+package scene
+
+import "aoi"
+
+// _SceneAOI 对外返回的场景AOI, 所有操作都经过Manager, 保证对象所在的场景和数量正确.
+// 场景销毁后(包括之后用同一个地图ID重新创建), 返回错误的操作返回ErrSceneNotExisted, 遍历不做任何事
+type _SceneAOI struct {
+	m     *Manager
+	mapID int
+}
+
+// scene 场景还存在时返回, 重新创建的同ID场景不属于旧的AOI
+func (s *_SceneAOI) scene() *_Scene {
+	if scene, ok := s.m.scenes[s.mapID]; ok && scene.view == s {
+		return scene
+	}
+
+	return nil
+}
+
+// contains 对象在这个场景中
+func (s *_SceneAOI) contains(obj aoi.IObject) bool {
+	mapID, ok := s.m.objs[obj.GetAOIID()]
+	return ok && mapID == s.mapID
+}
+
+func (s *_SceneAOI) AddToAOI(obj aoi.IObject) error {
+	if s.scene() == nil {
+		return ErrSceneNotExisted
+	}
+
+	return s.m.Enter(obj, s.mapID)
+}
+
+func (s *_SceneAOI) RemoveFromAOI(obj aoi.IObject) error {
+	if s.scene() == nil {
+		return ErrSceneNotExisted
+	}
+
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	if !s.contains(obj) {
+		return aoi.ErrObjectNotExisted
+	}
+
+	return s.m.Leave(obj)
+}
+
+func (s *_SceneAOI) Move(obj aoi.IObject) error {
+	if s.scene() == nil {
+		return ErrSceneNotExisted
+	}
+
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	if !s.contains(obj) {
+		return aoi.ErrObjectNotExisted
+	}
+
+	return s.m.Move(obj)
+}
+
+func (s *_SceneAOI) Traversal(obj aoi.IObject, cb func(watcher aoi.IWatcher) bool) {
+	if scene := s.scene(); scene != nil {
+		scene.aoi.Traversal(obj, cb)
+	}
+}
+
+func (s *_SceneAOI) AddGlobalMarker(obj aoi.IObject) error {
+	scene := s.scene()
+	if scene == nil {
+		return ErrSceneNotExisted
+	}
+
+	return scene.aoi.AddGlobalMarker(obj)
+}
+
+func (s *_SceneAOI) RemoveGlobalMarker(obj aoi.IObject) error {
+	scene := s.scene()
+	if scene == nil {
+		return ErrSceneNotExisted
+	}
+
+	return scene.aoi.RemoveGlobalMarker(obj)
+}
+
+func (s *_SceneAOI) CreateGroup(objs []aoi.IObject) (int, error) {
+	scene := s.scene()
+	if scene == nil {
+		return 0, ErrSceneNotExisted
+	}
+
+	return scene.aoi.CreateGroup(objs)
+}
+
+func (s *_SceneAOI) DestroyGroup(groupID int) error {
+	scene := s.scene()
+	if scene == nil {
+		return ErrSceneNotExisted
+	}
+
+	return scene.aoi.DestroyGroup(groupID)
+}
+
+func (s *_SceneAOI) AddToGroup(obj aoi.IObject, groupID int) error {
+	scene := s.scene()
+	if scene == nil {
+		return ErrSceneNotExisted
+	}
+
+	return scene.aoi.AddToGroup(obj, groupID)
+}
+
+func (s *_SceneAOI) RemoveFromGroup(obj aoi.IObject, groupID int) error {
+	scene := s.scene()
+	if scene == nil {
+		return ErrSceneNotExisted
+	}
+
+	return scene.aoi.RemoveFromGroup(obj, groupID)
+}
+
+func (s *_SceneAOI) TraversalGroup(obj aoi.IObject, groupID int, cb func(watcher aoi.IWatcher) bool) {
+	if scene := s.scene(); scene != nil {
+		scene.aoi.TraversalGroup(obj, groupID, cb)
+	}
+}
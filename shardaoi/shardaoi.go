@@ -0,0 +1,744 @@
+package shardaoi
+
+import (
+	"aoi"
+	"aoi/base/linemath"
+	"aoi/internal/tower"
+	"errors"
+	"math"
+	"sort"
+	"sync"
+)
+
+// ShardAOI 并发安全的灯塔AOI, 地图按灯塔区域分片加锁, 不同区域的操作可以并行
+//
+// 加锁顺序: 对象锁 -> 分片锁(按下标从小到大) -> 全局锁 -> 群组锁 -> watcher锁 -> dirty锁
+// watcher的回调在所有锁之外进行, 同一个watcher的回调是串行的, 回调中不要同步调用同一个AOI的清理逻辑
+type ShardAOI struct {
+	// 灯塔相关
+	towers         [][]*tower.Tower
+	minPos, maxPos linemath.Vector2 // 地图边界
+	towerSize      float32
+	towerSizeX     int // 灯塔数量
+	towerSizeY     int // 灯塔数量
+
+	// 分片
+	shardSize  int // 每个分片边长包含的灯塔数量
+	shardSizeY int // Y方向分片数量
+	shards     []sync.Mutex
+
+	objsMu sync.RWMutex
+	objs   map[string]*_CacheObject
+
+	// 全局列表
+	globalMu sync.Mutex
+	global   *tower.Tower
+
+	// 群组, 同时保护_CacheObject.groups
+	groupMu     sync.Mutex
+	groups      map[int]*tower.Tower
+	groupIDSeed int
+
+	// 缓存需要清理的watcher
+	// 同ID的watcher可能被移除后再加入, 按指针记录
+	dirtyMu       sync.Mutex
+	dirtyWatchers map[*tower.WrapWatcher]struct{}
+}
+
+type _CacheObject struct {
+	mu sync.Mutex // 同一个对象的操作串行
+
+	X, Y        int
+	wrapWatcher *tower.WrapWatcher
+	towerVisual int // 订阅灯塔时使用的视野
+	global      bool
+	removed     bool
+
+	groups []int
+}
+
+var (
+	ErrTowerConfigInvalid = errors.New("config invalid")
+)
+
+type Config struct {
+	MinPos    linemath.Vector2
+	MaxPos    linemath.Vector2
+	TowerSize float32
+
+	// ShardSize 每个分片边长包含的灯塔数量, 默认8
+	ShardSize int
+}
+
+func New(cfg *Config) (aoi.IAOI, error) {
+	if cfg.MinPos.X > cfg.MaxPos.X || cfg.MinPos.Y > cfg.MaxPos.Y || cfg.TowerSize <= 0 || cfg.ShardSize < 0 {
+		return nil, ErrTowerConfigInvalid
+	}
+
+	sa := &ShardAOI{
+		minPos:        cfg.MinPos,
+		maxPos:        cfg.MaxPos,
+		towerSize:     cfg.TowerSize,
+		shardSize:     cfg.ShardSize,
+		objs:          make(map[string]*_CacheObject),
+		dirtyWatchers: make(map[*tower.WrapWatcher]struct{}),
+	}
+	if sa.shardSize == 0 {
+		sa.shardSize = 8
+	}
+
+	sa.towerSizeX = int(math.Ceil(float64((cfg.MaxPos.X - cfg.MinPos.X) / cfg.TowerSize)))
+	sa.towerSizeY = int(math.Ceil(float64((cfg.MaxPos.Y - cfg.MinPos.Y) / cfg.TowerSize)))
+
+	sa.towers = make([][]*tower.Tower, sa.towerSizeX)
+	for x := 0; x < sa.towerSizeX; x++ {
+		sa.towers[x] = make([]*tower.Tower, sa.towerSizeY)
+		for y := 0; y < sa.towerSizeY; y++ {
+			sa.towers[x][y] = tower.NewTower()
+		}
+	}
+
+	shardSizeX := (sa.towerSizeX + sa.shardSize - 1) / sa.shardSize
+	sa.shardSizeY = (sa.towerSizeY + sa.shardSize - 1) / sa.shardSize
+	sa.shards = make([]sync.Mutex, shardSizeX*sa.shardSizeY)
+
+	sa.global = tower.NewTower()
+	sa.groups = make(map[int]*tower.Tower)
+
+	return sa, nil
+}
+
+func (s *ShardAOI) AddToAOI(obj aoi.IObject) error {
+	err := s.addToAOI(obj)
+	s.flushWatchers()
+
+	return err
+}
+
+func (s *ShardAOI) addToAOI(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	pos := obj.GetCoordPos()
+	if !s.isInvalid(pos) {
+		return aoi.ErrPosInvalid
+	}
+	x, y := s.transPos(pos)
+
+	cacheObj := &_CacheObject{X: x, Y: y, towerVisual: -1}
+	cacheObj.mu.Lock()
+	defer cacheObj.mu.Unlock()
+
+	s.objsMu.Lock()
+	if _, ok := s.objs[obj.GetAOIID()]; ok {
+		s.objsMu.Unlock()
+		return aoi.ErrObjectExisted
+	}
+	s.objs[obj.GetAOIID()] = cacheObj
+	s.objsMu.Unlock()
+
+	w, isWatcher := obj.(aoi.IWatcher)
+	if isWatcher {
+		cacheObj.wrapWatcher = tower.NewWrapWatcher(w, s.notifyDirty)
+		if visual := w.GetVisual(); visual > 0 {
+			cacheObj.towerVisual = s.getTowerVisual(visual)
+		}
+	}
+
+	unlock := s.lockShards(s.shardsOf(x, y, cacheObj.towerVisual))
+	s.towers[x][y].Add(obj)
+	if cacheObj.wrapWatcher != nil {
+		s.traversalTowerByVisual(x, y, cacheObj.towerVisual, func(towerX, towerY int, tower *tower.Tower) {
+			tower.AddWatcher(cacheObj.wrapWatcher)
+		})
+
+		s.globalMu.Lock()
+		s.global.AddWatcher(cacheObj.wrapWatcher)
+		s.globalMu.Unlock()
+	}
+	unlock()
+
+	return nil
+}
+
+func (s *ShardAOI) RemoveFromAOI(obj aoi.IObject) error {
+	err := s.removeFromAOI(obj)
+	s.flushWatchers()
+
+	return err
+}
+
+func (s *ShardAOI) removeFromAOI(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj := s.lockObject(obj.GetAOIID())
+	if cacheObj == nil {
+		return aoi.ErrObjectNotExisted
+	}
+	defer cacheObj.mu.Unlock()
+
+	unlock := s.lockShards(s.shardsOf(cacheObj.X, cacheObj.Y, cacheObj.towerVisual))
+	if cacheObj.global {
+		s.globalMu.Lock()
+		s.global.Remove(obj)
+		s.globalMu.Unlock()
+	} else {
+		s.towers[cacheObj.X][cacheObj.Y].Remove(obj)
+	}
+	if cacheObj.wrapWatcher != nil {
+		s.traversalTowerByVisual(cacheObj.X, cacheObj.Y, cacheObj.towerVisual, func(towerX, towerY int, tower *tower.Tower) {
+			tower.RemoveWatcher(cacheObj.wrapWatcher)
+		})
+
+		s.globalMu.Lock()
+		s.global.RemoveWatcher(cacheObj.wrapWatcher)
+		s.globalMu.Unlock()
+	}
+	unlock()
+
+	// 从所有group中移除
+	s.groupMu.Lock()
+	cacheObj.removed = true
+	for _, groupID := range cacheObj.groups {
+		if group, existed := s.groups[groupID]; existed {
+			group.Remove(obj)
+			if cacheObj.wrapWatcher != nil {
+				group.RemoveWatcher(cacheObj.wrapWatcher)
+			}
+
+			if group.GetObjsLen() == 0 && group.GetWatchersLen() == 0 {
+				delete(s.groups, groupID)
+			}
+		}
+	}
+	cacheObj.groups = nil
+	s.groupMu.Unlock()
+
+	// 最后才从列表中删除, 保证同ID的对象不会在清理完成前再次加入
+	s.objsMu.Lock()
+	delete(s.objs, obj.GetAOIID())
+	s.objsMu.Unlock()
+
+	return nil
+}
+
+func (s *ShardAOI) Move(obj aoi.IObject) error {
+	err := s.move(obj)
+	s.flushWatchers()
+
+	return err
+}
+
+func (s *ShardAOI) move(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj := s.lockObject(obj.GetAOIID())
+	if cacheObj == nil {
+		return aoi.ErrObjectNotExisted
+	}
+	defer cacheObj.mu.Unlock()
+
+	pos := obj.GetCoordPos()
+	if !s.isInvalid(pos) {
+		return aoi.ErrPosInvalid
+	}
+
+	oldX, oldY := cacheObj.X, cacheObj.Y
+	newX, newY := s.transPos(pos)
+	if oldX == newX && oldY == newY {
+		return nil
+	}
+
+	shards := append(s.shardsOf(oldX, oldY, cacheObj.towerVisual), s.shardsOf(newX, newY, cacheObj.towerVisual)...)
+	unlock := s.lockShards(shards)
+
+	// 先加后删, 其他协程中途清理时不会看到对象短暂离开
+	if !cacheObj.global {
+		s.towers[newX][newY].Add(obj)
+		s.towers[oldX][oldY].Remove(obj)
+	}
+
+	if cacheObj.wrapWatcher != nil {
+		oldStartX, oldEndX, oldStartY, oldEndY := s.getTowerRange(oldX, oldY, cacheObj.towerVisual)
+		newStartX, newEndX, newStartY, newEndY := s.getTowerRange(newX, newY, cacheObj.towerVisual)
+		s.traversalTowerByVisual(newX, newY, cacheObj.towerVisual, func(towerX, towerY int, tower *tower.Tower) {
+			if !inRange(towerX, towerY, oldStartX, oldEndX, oldStartY, oldEndY) {
+				tower.AddWatcher(cacheObj.wrapWatcher)
+			}
+		})
+		s.traversalTowerByVisual(oldX, oldY, cacheObj.towerVisual, func(towerX, towerY int, tower *tower.Tower) {
+			if !inRange(towerX, towerY, newStartX, newEndX, newStartY, newEndY) {
+				tower.RemoveWatcher(cacheObj.wrapWatcher)
+			}
+		})
+	}
+
+	cacheObj.X = newX
+	cacheObj.Y = newY
+	unlock()
+
+	return nil
+}
+
+func (s *ShardAOI) AddGlobalMarker(obj aoi.IObject) error {
+	err := s.addGlobalMarker(obj)
+	s.flushWatchers()
+
+	return err
+}
+
+func (s *ShardAOI) addGlobalMarker(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj := s.lockObject(obj.GetAOIID())
+	if cacheObj == nil {
+		return aoi.ErrObjectNotExisted
+	}
+	defer cacheObj.mu.Unlock()
+
+	if cacheObj.global {
+		return aoi.ErrObjectExisted
+	}
+
+	unlock := s.lockShards(s.shardsOf(cacheObj.X, cacheObj.Y, -1))
+	s.globalMu.Lock()
+	s.global.Add(obj)
+	s.globalMu.Unlock()
+	s.towers[cacheObj.X][cacheObj.Y].Remove(obj)
+	cacheObj.global = true
+	unlock()
+
+	return nil
+}
+
+func (s *ShardAOI) RemoveGlobalMarker(obj aoi.IObject) error {
+	err := s.removeGlobalMarker(obj)
+	s.flushWatchers()
+
+	return err
+}
+
+func (s *ShardAOI) removeGlobalMarker(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj := s.lockObject(obj.GetAOIID())
+	if cacheObj == nil || !cacheObj.global {
+		if cacheObj != nil {
+			cacheObj.mu.Unlock()
+		}
+		return aoi.ErrObjectNotExisted
+	}
+	defer cacheObj.mu.Unlock()
+
+	pos := obj.GetCoordPos()
+	if !s.isInvalid(pos) {
+		return aoi.ErrPosInvalid
+	}
+	x, y := s.transPos(pos)
+
+	// 视野按新位置重新订阅
+	shards := append(s.shardsOf(cacheObj.X, cacheObj.Y, cacheObj.towerVisual), s.shardsOf(x, y, cacheObj.towerVisual)...)
+	unlock := s.lockShards(shards)
+	s.towers[x][y].Add(obj)
+	s.globalMu.Lock()
+	s.global.Remove(obj)
+	s.globalMu.Unlock()
+	if cacheObj.wrapWatcher != nil && (x != cacheObj.X || y != cacheObj.Y) {
+		s.traversalTowerByVisual(x, y, cacheObj.towerVisual, func(towerX, towerY int, tower *tower.Tower) {
+			tower.AddWatcher(cacheObj.wrapWatcher)
+		})
+		s.traversalTowerByVisual(cacheObj.X, cacheObj.Y, cacheObj.towerVisual, func(towerX, towerY int, tower *tower.Tower) {
+			tower.RemoveWatcher(cacheObj.wrapWatcher)
+		})
+	}
+	cacheObj.X = x
+	cacheObj.Y = y
+	cacheObj.global = false
+	unlock()
+
+	return nil
+}
+
+func (s *ShardAOI) Traversal(obj aoi.IObject, cb func(obj aoi.IWatcher) bool) {
+	if obj == nil {
+		return
+	}
+
+	cacheObj := s.lockObject(obj.GetAOIID())
+	if cacheObj == nil {
+		return
+	}
+
+	// 在锁内复制watcher, 锁外回调
+	var watchers []aoi.IWatcher
+	if cacheObj.global {
+		s.globalMu.Lock()
+		watchers = s.global.CopyWatchers()
+		s.globalMu.Unlock()
+	} else {
+		// 按加锁顺序先复制灯塔的watcher, 再复制群组的; 回调时群组的watcher在前
+		unlock := s.lockShards(s.shardsOf(cacheObj.X, cacheObj.Y, -1))
+		towerWatchers := s.towers[cacheObj.X][cacheObj.Y].CopyWatchers()
+		unlock()
+
+		called := make(map[string]bool)
+		s.groupMu.Lock()
+		for _, groupID := range cacheObj.groups {
+			if group, ok := s.groups[groupID]; ok {
+				for _, w := range group.CopyWatchers() {
+					if !called[w.GetAOIID()] {
+						called[w.GetAOIID()] = true
+						watchers = append(watchers, w)
+					}
+				}
+			}
+		}
+		s.groupMu.Unlock()
+
+		for _, w := range towerWatchers {
+			if !called[w.GetAOIID()] {
+				watchers = append(watchers, w)
+			}
+		}
+	}
+	cacheObj.mu.Unlock()
+
+	for _, w := range watchers {
+		if !cb(w) {
+			return
+		}
+	}
+}
+
+func (s *ShardAOI) CreateGroup(objs []aoi.IObject) (int, error) {
+	if len(objs) == 0 {
+		return 0, aoi.ErrObjectInvalid
+	}
+
+	cacheObjs := make([]*_CacheObject, 0, len(objs))
+	s.objsMu.RLock()
+	for _, o := range objs {
+		cacheObj, ok := s.objs[o.GetAOIID()]
+		if !ok {
+			s.objsMu.RUnlock()
+			return 0, aoi.ErrObjectNotExisted
+		}
+		cacheObjs = append(cacheObjs, cacheObj)
+	}
+	s.objsMu.RUnlock()
+
+	s.groupMu.Lock()
+	for _, cacheObj := range cacheObjs {
+		if cacheObj.removed {
+			s.groupMu.Unlock()
+			return 0, aoi.ErrObjectNotExisted
+		}
+	}
+
+	// 构建群组
+	group := tower.NewTower()
+	s.groupIDSeed++
+	groupID := s.groupIDSeed
+	s.groups[groupID] = group
+	for i, o := range objs {
+		// 重复的对象只加入一次
+		if group.Add(o) != nil {
+			continue
+		}
+
+		cacheObj := cacheObjs[i]
+		cacheObj.groups = append(cacheObj.groups, groupID)
+		if cacheObj.wrapWatcher != nil {
+			group.AddWatcher(cacheObj.wrapWatcher)
+		}
+	}
+	s.groupMu.Unlock()
+
+	s.flushWatchers()
+
+	return groupID, nil
+}
+
+func (s *ShardAOI) DestroyGroup(groupID int) error {
+	s.groupMu.Lock()
+	group, ok := s.groups[groupID]
+	if !ok {
+		s.groupMu.Unlock()
+		return aoi.ErrGroupNotExisted
+	}
+
+	s.objsMu.RLock()
+	for id := range group.GetObjs() {
+		if cacheObj, existed := s.objs[id]; existed {
+			cacheObj.groups = removeGroupID(cacheObj.groups, groupID)
+		}
+	}
+	s.objsMu.RUnlock()
+
+	group.Clear()
+	delete(s.groups, groupID)
+	s.groupMu.Unlock()
+
+	s.flushWatchers()
+
+	return nil
+}
+
+func (s *ShardAOI) AddToGroup(obj aoi.IObject, groupID int) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	s.objsMu.RLock()
+	cacheObj, ok := s.objs[obj.GetAOIID()]
+	s.objsMu.RUnlock()
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	s.groupMu.Lock()
+	if cacheObj.removed {
+		s.groupMu.Unlock()
+		return aoi.ErrObjectNotExisted
+	}
+
+	group, ok := s.groups[groupID]
+	if !ok {
+		s.groupMu.Unlock()
+		return aoi.ErrGroupNotExisted
+	}
+
+	if !group.Existed(obj.GetAOIID()) {
+		group.Add(obj)
+		if cacheObj.wrapWatcher != nil {
+			group.AddWatcher(cacheObj.wrapWatcher)
+		}
+		cacheObj.groups = append(cacheObj.groups, groupID)
+	}
+	s.groupMu.Unlock()
+
+	s.flushWatchers()
+
+	return nil
+}
+
+func (s *ShardAOI) RemoveFromGroup(obj aoi.IObject, groupID int) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	s.objsMu.RLock()
+	cacheObj, ok := s.objs[obj.GetAOIID()]
+	s.objsMu.RUnlock()
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	s.groupMu.Lock()
+	group, ok := s.groups[groupID]
+	if !ok {
+		s.groupMu.Unlock()
+		return aoi.ErrGroupNotExisted
+	}
+
+	if group.Existed(obj.GetAOIID()) {
+		group.Remove(obj)
+		if cacheObj.wrapWatcher != nil {
+			group.RemoveWatcher(cacheObj.wrapWatcher)
+		}
+		cacheObj.groups = removeGroupID(cacheObj.groups, groupID)
+
+		if group.GetWatchersLen() == 0 && group.GetObjsLen() == 0 {
+			delete(s.groups, groupID)
+		}
+	}
+	s.groupMu.Unlock()
+
+	s.flushWatchers()
+
+	return nil
+}
+
+func (s *ShardAOI) TraversalGroup(obj aoi.IObject, groupID int, cb func(watcher aoi.IWatcher) bool) {
+	if obj == nil {
+		return
+	}
+
+	s.groupMu.Lock()
+	group, ok := s.groups[groupID]
+	if !ok || !group.Existed(obj.GetAOIID()) {
+		s.groupMu.Unlock()
+		return
+	}
+	watchers := group.CopyWatchers()
+	s.groupMu.Unlock()
+
+	for _, w := range watchers {
+		if !cb(w) {
+			return
+		}
+	}
+}
+
+// lockObject 查找并锁住对象, 对象不存在或者已经被移除返回nil
+func (s *ShardAOI) lockObject(id string) *_CacheObject {
+	s.objsMu.RLock()
+	cacheObj, ok := s.objs[id]
+	s.objsMu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	cacheObj.mu.Lock()
+	if cacheObj.removed {
+		cacheObj.mu.Unlock()
+		return nil
+	}
+
+	return cacheObj
+}
+
+// shardsOf 灯塔(x, y)及其视野覆盖的灯塔所在的分片, towerVisual小于0表示只有灯塔本身
+func (s *ShardAOI) shardsOf(x, y, towerVisual int) []int {
+	if towerVisual < 0 {
+		towerVisual = 0
+	}
+
+	startX, endX, startY, endY := s.getTowerRange(x, y, towerVisual)
+	shards := make([]int, 0, 4)
+	for i := startX / s.shardSize; i <= endX/s.shardSize; i++ {
+		for j := startY / s.shardSize; j <= endY/s.shardSize; j++ {
+			shards = append(shards, i*s.shardSizeY+j)
+		}
+	}
+
+	return shards
+}
+
+// lockShards 按下标顺序加锁, 返回解锁函数
+func (s *ShardAOI) lockShards(shards []int) func() {
+	sort.Ints(shards)
+	locked := make([]int, 0, len(shards))
+	for _, shard := range shards {
+		if len(locked) > 0 && locked[len(locked)-1] == shard {
+			continue
+		}
+		locked = append(locked, shard)
+	}
+
+	for _, shard := range locked {
+		s.shards[shard].Lock()
+	}
+
+	return func() {
+		for i := len(locked) - 1; i >= 0; i-- {
+			s.shards[locked[i]].Unlock()
+		}
+	}
+}
+
+func (s *ShardAOI) isInvalid(pos linemath.Vector2) bool {
+	if pos.X < s.minPos.X || pos.X > s.maxPos.X || pos.Y < s.minPos.Y || pos.Y > s.maxPos.Y {
+		return false
+	}
+
+	return true
+}
+
+func (s *ShardAOI) transPos(pos linemath.Vector2) (int, int) {
+	x := int(math.Floor(float64((pos.X - s.minPos.X) / s.towerSize)))
+	y := int(math.Floor(float64((pos.Y - s.minPos.Y) / s.towerSize)))
+	// 正好在最大边界上的对象归到最后一个灯塔
+	if x > s.towerSizeX-1 {
+		x = s.towerSizeX - 1
+	}
+	if y > s.towerSizeY-1 {
+		y = s.towerSizeY - 1
+	}
+
+	return x, y
+}
+
+func (s *ShardAOI) getTowerVisual(visual float32) int {
+	return int(math.Ceil(float64(visual/s.towerSize))) - 1
+}
+
+// traversalTowerByVisual towerVisual小于0时没有视野, 不遍历
+func (s *ShardAOI) traversalTowerByVisual(x, y, towerVisual int, cb func(towerX, towerY int, tower *tower.Tower)) {
+	if towerVisual < 0 {
+		return
+	}
+
+	startX, endX, startY, endY := s.getTowerRange(x, y, towerVisual)
+	for i := startX; i <= endX; i++ {
+		for j := startY; j <= endY; j++ {
+			cb(i, j, s.towers[i][j])
+		}
+	}
+}
+
+func (s *ShardAOI) getTowerRange(x, y, i int) (int, int, int, int) {
+	startX := x - i
+	if startX < 0 {
+		startX = 0
+	}
+	endX := x + i
+	if endX > s.towerSizeX-1 {
+		endX = s.towerSizeX - 1
+	}
+	startY := y - i
+	if startY < 0 {
+		startY = 0
+	}
+	endY := y + i
+	if endY > s.towerSizeY-1 {
+		endY = s.towerSizeY - 1
+	}
+
+	return startX, endX, startY, endY
+}
+
+func (s *ShardAOI) notifyDirty(ww *tower.WrapWatcher) {
+	s.dirtyMu.Lock()
+	s.dirtyWatchers[ww] = struct{}{}
+	s.dirtyMu.Unlock()
+}
+
+// flushWatchers 取出当前所有dirty的watcher并在锁外清理
+func (s *ShardAOI) flushWatchers() {
+	s.dirtyMu.Lock()
+	if len(s.dirtyWatchers) == 0 {
+		s.dirtyMu.Unlock()
+		return
+	}
+	dirty := s.dirtyWatchers
+	s.dirtyWatchers = make(map[*tower.WrapWatcher]struct{})
+	s.dirtyMu.Unlock()
+
+	for w := range dirty {
+		w.Flush()
+	}
+}
+
+func inRange(x, y, startX, endX, startY, endY int) bool {
+	return x >= startX && x <= endX && y >= startY && y <= endY
+}
+
+func removeGroupID(groups []int, groupID int) []int {
+	for i := range groups {
+		if groups[i] == groupID {
+			return append(groups[:i], groups[i+1:]...)
+		}
+	}
+
+	return groups
+}
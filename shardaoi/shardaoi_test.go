@@ -0,0 +1,217 @@
+package shardaoi
+
+import (
+	"aoi"
+	"aoi/aoitest"
+	"aoi/base/linemath"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestShardAOI_Base(t *testing.T) {
+	sa, err := New(&Config{
+		MinPos:    linemath.Vector2{X: -10, Y: -10},
+		MaxPos:    linemath.Vector2{X: 10, Y: 10},
+		TowerSize: 1,
+		ShardSize: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := aoitest.NewWatcher("watcher", linemath.Vector2{X: 0.5, Y: 0.5}, 2)
+	o := &aoi.TestMarker{ID: "obj", Pos: linemath.Vector2{X: 1.5, Y: 1.5}}
+	sa.AddToAOI(w)
+	sa.AddToAOI(o)
+	if !w.Sees("obj") || !w.Sees("watcher") {
+		t.Fatal("obj should be visible")
+	}
+	if sa.AddToAOI(o) != aoi.ErrObjectExisted {
+		t.Fatal("add twice")
+	}
+
+	o.Pos = linemath.Vector2{X: 5.5, Y: 5.5}
+	sa.Move(o)
+	if w.Sees("obj") {
+		t.Fatal("obj moved out")
+	}
+
+	sa.AddGlobalMarker(o)
+	if !w.Sees("obj") {
+		t.Fatal("global marker should be visible")
+	}
+	sa.RemoveGlobalMarker(o)
+	if w.Sees("obj") {
+		t.Fatal("global marker removed")
+	}
+
+	groupID, _ := sa.CreateGroup([]aoi.IObject{w, o})
+	if !w.Sees("obj") {
+		t.Fatal("group member should be visible")
+	}
+	called := 0
+	sa.Traversal(o, func(watcher aoi.IWatcher) bool {
+		called++
+		return true
+	})
+	if called != 1 {
+		t.Fatal("Traversal", called)
+	}
+	sa.DestroyGroup(groupID)
+	if w.Sees("obj") {
+		t.Fatal("group destroyed")
+	}
+	if sa.DestroyGroup(groupID) != aoi.ErrGroupNotExisted {
+		t.Fatal("destroy twice")
+	}
+
+	sa.RemoveFromAOI(w)
+	if len(w.Visible()) != 0 {
+		t.Fatal("removed watcher still sees", w.Visible())
+	}
+}
+
+func TestShardAOI_Concurrent(t *testing.T) {
+	const (
+		size      = 100
+		towerSize = 5
+		workers   = 8
+		perWorker = 50
+		moves     = 500
+	)
+
+	sa, err := New(&Config{
+		MinPos:    linemath.Vector2{},
+		MaxPos:    linemath.Vector2{X: size, Y: size},
+		TowerSize: towerSize,
+		ShardSize: 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	randPos := func(r *rand.Rand) linemath.Vector2 {
+		return linemath.Vector2{X: r.Float32() * size, Y: r.Float32() * size}
+	}
+
+	watchers := make([][]*aoitest.Watcher, workers)
+	markers := make([][]*aoi.TestMarker, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(worker)))
+			for j := 0; j < perWorker; j++ {
+				if j%5 == 0 {
+					w := aoitest.NewWatcher(fmt.Sprintf("watcher:%d:%d", worker, j), randPos(r), 12)
+					sa.AddToAOI(w)
+					watchers[worker] = append(watchers[worker], w)
+				} else {
+					o := &aoi.TestMarker{ID: fmt.Sprintf("obj:%d:%d", worker, j), Pos: randPos(r)}
+					sa.AddToAOI(o)
+					markers[worker] = append(markers[worker], o)
+				}
+			}
+
+			for j := 0; j < moves; j++ {
+				if r.Intn(4) == 0 {
+					w := watchers[worker][r.Intn(len(watchers[worker]))]
+					w.Pos = randPos(r)
+					sa.Move(w)
+				} else {
+					o := markers[worker][r.Intn(len(markers[worker]))]
+					o.Pos = randPos(r)
+					sa.Move(o)
+				}
+
+				sa.Traversal(markers[worker][0], func(watcher aoi.IWatcher) bool {
+					return true
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	towerOf := func(pos linemath.Vector2) (int, int) {
+		return int(pos.X / towerSize), int(pos.Y / towerSize)
+	}
+	var all []aoi.IObject
+	for i := 0; i < workers; i++ {
+		for _, w := range watchers[i] {
+			all = append(all, w)
+		}
+		for _, o := range markers[i] {
+			all = append(all, o)
+		}
+	}
+
+	for i := 0; i < workers; i++ {
+		for _, w := range watchers[i] {
+			towerVisual := int(math.Ceil(float64(w.Visual/towerSize))) - 1
+			wx, wy := towerOf(w.Pos)
+			expected := 0
+			for _, o := range all {
+				ox, oy := towerOf(o.GetCoordPos())
+				in := ox >= wx-towerVisual && ox <= wx+towerVisual && oy >= wy-towerVisual && oy <= wy+towerVisual
+				if in {
+					expected++
+				}
+				if in != w.Sees(o.GetAOIID()) {
+					t.Fatal(w.ID, "visible mismatch", o.GetAOIID(), in)
+				}
+			}
+			if expected != len(w.Visible()) {
+				t.Fatal(w.ID, "visible count mismatch", expected, len(w.Visible()))
+			}
+		}
+	}
+}
+
+func TestShardAOI_GroupDuplicate(t *testing.T) {
+	sa, err := New(&Config{
+		MinPos:    linemath.Vector2{X: -100, Y: -100},
+		MaxPos:    linemath.Vector2{X: 100, Y: 100},
+		TowerSize: 10,
+		ShardSize: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := aoitest.NewWatcher("watcher", linemath.Vector2{X: -90, Y: -90}, 10)
+	m := &aoi.TestMarker{ID: "member", Pos: linemath.Vector2{X: 90, Y: 90}}
+	sa.AddToAOI(w)
+	sa.AddToAOI(m)
+
+	// 重复的对象只加入一次, 销毁后不能留下计数
+	groupID, err := sa.CreateGroup([]aoi.IObject{w, m, m, w})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !w.Sees("member") {
+		t.Fatal("group member not visible")
+	}
+	if groups := sa.(*ShardAOI).objs["member"].groups; len(groups) != 1 {
+		t.Fatal("group IDs", groups)
+	}
+
+	if err := sa.DestroyGroup(groupID); err != nil {
+		t.Fatal(err)
+	}
+	if w.Sees("member") {
+		t.Fatal("group member visible after destroy")
+	}
+	if groups := sa.(*ShardAOI).objs["member"].groups; len(groups) != 0 {
+		t.Fatal("group IDs after destroy", groups)
+	}
+}
+
+func TestShardAOI_Conformance(t *testing.T) {
+	aoitest.RunConformance(t, aoitest.Factory{New: func() (aoi.IAOI, error) {
+		return New(&Config{MinPos: aoitest.MinPos, MaxPos: aoitest.MaxPos, TowerSize: 10, ShardSize: 2})
+	}})
+}
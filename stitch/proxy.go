@@ -0,0 +1,95 @@
+package stitch
+
+import (
+	"aoi"
+
+	log "github.com/cihub/seelog"
+)
+
+// _Ghost 对象在相邻实例中的镜像, 只嵌入IObject, 即使原对象是watcher在相邻实例中也只是标记
+type _Ghost struct {
+	aoi.IObject
+}
+
+// unwrap 实例中的对象是原对象, 镜像或者watcher的代理, 通知给外部的都是原对象
+func unwrap(obj aoi.IObject) aoi.IObject {
+	switch o := obj.(type) {
+	case *_Ghost:
+		return o.IObject
+	case *_ProxyWatcher:
+		return o.IWatcher
+	}
+
+	return obj
+}
+
+// _ProxyWatcher 加入实例的watcher, 多个实例共用一个
+// 对象跨实例迁移时会先在新实例进入再从旧实例离开, 按ID计数, 只有第一次进入和最后一次离开通知给watcher
+type _ProxyWatcher struct {
+	aoi.IWatcher
+
+	counts map[string]int
+}
+
+func newProxyWatcher(w aoi.IWatcher) *_ProxyWatcher {
+	return &_ProxyWatcher{
+		IWatcher: w,
+		counts:   make(map[string]int),
+	}
+}
+
+func (pw *_ProxyWatcher) enter(objs []aoi.IObject) []aoi.IObject {
+	var enterList []aoi.IObject
+	for _, o := range objs {
+		pw.counts[o.GetAOIID()]++
+		if pw.counts[o.GetAOIID()] == 1 {
+			enterList = append(enterList, unwrap(o))
+		}
+	}
+
+	return enterList
+}
+
+func (pw *_ProxyWatcher) leave(objs []aoi.IObject) []aoi.IObject {
+	var leaveList []aoi.IObject
+	for _, o := range objs {
+		count, ok := pw.counts[o.GetAOIID()]
+		if !ok {
+			log.Debug("非常奇怪的事情发生了, 检查代码和日志!")
+			continue
+		}
+
+		if count <= 1 {
+			delete(pw.counts, o.GetAOIID())
+			leaveList = append(leaveList, unwrap(o))
+		} else {
+			pw.counts[o.GetAOIID()] = count - 1
+		}
+	}
+
+	return leaveList
+}
+
+func (pw *_ProxyWatcher) OnObjectEnter(obj aoi.IObject) {
+	if list := pw.enter([]aoi.IObject{obj}); len(list) > 0 {
+		pw.IWatcher.OnObjectEnter(list[0])
+	}
+}
+
+func (pw *_ProxyWatcher) OnObjectLeave(obj aoi.IObject) {
+	if list := pw.leave([]aoi.IObject{obj}); len(list) > 0 {
+		pw.IWatcher.OnObjectLeave(list[0])
+	}
+}
+
+func (pw *_ProxyWatcher) OnBatchEnter(objs []aoi.IObject) {
+	if list := pw.enter(objs); len(list) > 0 {
+		pw.IWatcher.OnBatchEnter(list)
+	}
+}
+
+func (pw *_ProxyWatcher) OnBatchLeave(objs []aoi.IObject) {
+	if list := pw.leave(objs); len(list) > 0 {
+		pw.IWatcher.OnBatchLeave(list)
+	}
+}
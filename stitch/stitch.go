@@ -0,0 +1,358 @@
+package stitch
+
+import (
+	"aoi"
+	"aoi/base/linemath"
+	"errors"
+	"sort"
+)
+
+// Stitch 把相邻的多个AOI实例拼接成一张地图, 每个实例负责一个矩形区域
+//
+// 对象真实地加入所在区域的实例, 同时以镜像(ghost)的形式加入边界Margin范围内的相邻实例,
+// 所以边界附近的watcher在自己的实例中就能看到相邻实例的对象. 对象跨区域移动时,
+// 新实例中的镜像换成真实对象, 旧实例中的真实对象换成镜像, 两边的watcher都不会收到多余的进出
+type Stitch struct {
+	regions   []*_Region
+	margin    float32
+	maxVisual float32
+	objs      map[string]*_CacheObject
+}
+
+type _Region struct {
+	Region
+	aoi aoi.IAOI
+}
+
+type _CacheObject struct {
+	obj    aoi.IObject
+	owner  int          // 真实对象所在的区域
+	ghost  *_Ghost      // 所有相邻实例共用一个镜像
+	ghosts map[int]bool // 有镜像的区域
+	proxy  *_ProxyWatcher
+}
+
+var (
+	ErrStitchConfigInvalid = errors.New("config invalid")
+)
+
+// Region 一个实例负责的区域, 包括边界
+type Region struct {
+	MinPos linemath.Vector2
+	MaxPos linemath.Vector2
+}
+
+type Config struct {
+	Regions []Region
+
+	// Margin 边界两侧镜像的宽度, 不能小于MaxVisual. 灯塔实现的视野按灯塔取整, 还要加上一个灯塔的边长
+	Margin float32
+
+	// MaxVisual 最大的视野, 视野更大的watcher加入时返回aoi.ErrObjectInvalid
+	MaxVisual float32
+
+	// Factory 为每个区域创建实例, 传入的边界已经向外扩展了Margin
+	// 实例实现了aoi.IAOIBatch时, 跨区域的替换在一次批量操作中完成, 替换同一个ID的对象不会通知进出
+	Factory func(minPos, maxPos linemath.Vector2) (aoi.IAOI, error)
+}
+
+func New(cfg *Config) (*Stitch, error) {
+	if len(cfg.Regions) == 0 || cfg.MaxVisual <= 0 || cfg.Margin < cfg.MaxVisual || cfg.Factory == nil {
+		return nil, ErrStitchConfigInvalid
+	}
+
+	for i, r := range cfg.Regions {
+		if r.MinPos.X > r.MaxPos.X || r.MinPos.Y > r.MaxPos.Y {
+			return nil, ErrStitchConfigInvalid
+		}
+		// 区域之间只能共用边界
+		for _, o := range cfg.Regions[:i] {
+			if r.MinPos.X < o.MaxPos.X && o.MinPos.X < r.MaxPos.X && r.MinPos.Y < o.MaxPos.Y && o.MinPos.Y < r.MaxPos.Y {
+				return nil, ErrStitchConfigInvalid
+			}
+		}
+	}
+
+	s := &Stitch{
+		margin:    cfg.Margin,
+		maxVisual: cfg.MaxVisual,
+		objs:      make(map[string]*_CacheObject),
+	}
+
+	for _, r := range cfg.Regions {
+		margin := linemath.Vector2{X: cfg.Margin, Y: cfg.Margin}
+		a, err := cfg.Factory(r.MinPos.Sub(margin), r.MaxPos.Add(margin))
+		if err != nil {
+			return nil, err
+		}
+		s.regions = append(s.regions, &_Region{Region: r, aoi: a})
+	}
+
+	return s, nil
+}
+
+// GetAOI 区域对应的实例
+func (s *Stitch) GetAOI(region int) aoi.IAOI {
+	if region < 0 || region >= len(s.regions) {
+		return nil
+	}
+
+	return s.regions[region].aoi
+}
+
+// RegionOf 对象当前所在的区域, 不存在返回-1
+func (s *Stitch) RegionOf(obj aoi.IObject) int {
+	if cacheObj, ok := s.objs[obj.GetAOIID()]; ok {
+		return cacheObj.owner
+	}
+
+	return -1
+}
+
+func (s *Stitch) AddToAOI(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	if _, ok := s.objs[obj.GetAOIID()]; ok {
+		return aoi.ErrObjectExisted
+	}
+
+	pos := obj.GetCoordPos()
+	owner := s.findOwner(pos)
+	if owner < 0 {
+		return aoi.ErrPosInvalid
+	}
+
+	cacheObj := &_CacheObject{
+		obj:    obj,
+		owner:  owner,
+		ghost:  &_Ghost{IObject: obj},
+		ghosts: make(map[int]bool),
+	}
+	if w, ok := obj.(aoi.IWatcher); ok {
+		if w.GetVisual() > s.maxVisual {
+			return aoi.ErrObjectInvalid
+		}
+		cacheObj.proxy = newProxyWatcher(w)
+	}
+
+	if err := s.regions[owner].aoi.AddToAOI(cacheObj.target()); err != nil {
+		return err
+	}
+
+	// 镜像加入失败时撤销已经加入的, 不留下只有部分镜像的对象
+	for _, idx := range s.findGhostRegions(pos, owner) {
+		if err := s.regions[idx].aoi.AddToAOI(cacheObj.ghost); err != nil {
+			for added := range cacheObj.ghosts {
+				s.regions[added].aoi.RemoveFromAOI(cacheObj.ghost)
+			}
+			s.regions[owner].aoi.RemoveFromAOI(cacheObj.target())
+			return err
+		}
+		cacheObj.ghosts[idx] = true
+	}
+	s.objs[obj.GetAOIID()] = cacheObj
+
+	return nil
+}
+
+func (s *Stitch) RemoveFromAOI(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := s.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+	delete(s.objs, obj.GetAOIID())
+
+	for idx := range cacheObj.ghosts {
+		s.regions[idx].aoi.RemoveFromAOI(cacheObj.ghost)
+	}
+
+	return s.regions[cacheObj.owner].aoi.RemoveFromAOI(cacheObj.target())
+}
+
+// Move 按新位置更新真实对象和镜像, 跨区域时先在新实例中替换成真实对象, 再把旧实例中的替换成镜像.
+// 真实对象移动成功后, 镜像更新失败的实例中不再保留镜像, 返回第一个错误
+func (s *Stitch) Move(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := s.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	pos := obj.GetCoordPos()
+	owner := s.findOwner(pos)
+	if owner < 0 {
+		return aoi.ErrPosInvalid
+	}
+
+	ghosts := make(map[int]bool)
+	for _, idx := range s.findGhostRegions(pos, owner) {
+		ghosts[idx] = true
+	}
+
+	var firstErr error
+	oldOwner := cacheObj.owner
+	if owner == oldOwner {
+		if err := s.regions[owner].aoi.Move(cacheObj.target()); err != nil {
+			return err
+		}
+	} else {
+		// 新区域中原来的镜像换成真实对象
+		err := s.replace(owner, func(a aoi.IAOI) error {
+			if cacheObj.ghosts[owner] {
+				a.RemoveFromAOI(cacheObj.ghost)
+			}
+			if err := a.AddToAOI(cacheObj.target()); err != nil {
+				if cacheObj.ghosts[owner] && a.AddToAOI(cacheObj.ghost) != nil {
+					delete(cacheObj.ghosts, owner)
+				}
+				return err
+			}
+			delete(cacheObj.ghosts, owner)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		cacheObj.owner = owner
+
+		// 旧区域中的真实对象换成镜像
+		firstErr = s.replace(oldOwner, func(a aoi.IAOI) error {
+			a.RemoveFromAOI(cacheObj.target())
+			if ghosts[oldOwner] {
+				if err := a.AddToAOI(cacheObj.ghost); err != nil {
+					return err
+				}
+				cacheObj.ghosts[oldOwner] = true
+			}
+			return nil
+		})
+	}
+
+	for idx := range cacheObj.ghosts {
+		if ghosts[idx] {
+			err := s.regions[idx].aoi.Move(cacheObj.ghost)
+			if err == nil {
+				continue
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		s.regions[idx].aoi.RemoveFromAOI(cacheObj.ghost)
+		delete(cacheObj.ghosts, idx)
+	}
+
+	for idx := range ghosts {
+		if !cacheObj.ghosts[idx] {
+			if err := s.regions[idx].aoi.AddToAOI(cacheObj.ghost); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			cacheObj.ghosts[idx] = true
+		}
+	}
+
+	return firstErr
+}
+
+// Traversal 遍历能看到obj的watcher, 包括相邻实例中通过镜像看到它的
+func (s *Stitch) Traversal(obj aoi.IObject, cb func(watcher aoi.IWatcher) bool) {
+	if obj == nil {
+		return
+	}
+
+	cacheObj, ok := s.objs[obj.GetAOIID()]
+	if !ok {
+		return
+	}
+
+	stopped := false
+	calledWatchers := make(map[string]bool)
+	traversal := func(a aoi.IAOI, target aoi.IObject) {
+		a.Traversal(target, func(watcher aoi.IWatcher) bool {
+			if calledWatchers[watcher.GetAOIID()] {
+				return true
+			}
+			calledWatchers[watcher.GetAOIID()] = true
+
+			if c, ok := s.objs[watcher.GetAOIID()]; ok && c.proxy != nil {
+				watcher = c.proxy.IWatcher
+			}
+			stopped = !cb(watcher)
+			return !stopped
+		})
+	}
+
+	traversal(s.regions[cacheObj.owner].aoi, cacheObj.target())
+
+	ghosts := make([]int, 0, len(cacheObj.ghosts))
+	for idx := range cacheObj.ghosts {
+		ghosts = append(ghosts, idx)
+	}
+	sort.Ints(ghosts)
+
+	for _, idx := range ghosts {
+		if stopped {
+			return
+		}
+		traversal(s.regions[idx].aoi, cacheObj.ghost)
+	}
+}
+
+// replace 在一次批量操作中修改实例
+func (s *Stitch) replace(region int, f func(a aoi.IAOI) error) error {
+	a := s.regions[region].aoi
+	if b, ok := a.(aoi.IAOIBatch); ok {
+		b.Begin()
+		defer b.Commit()
+	}
+
+	return f(a)
+}
+
+// findOwner 包含pos的第一个区域, 不存在返回-1
+func (s *Stitch) findOwner(pos linemath.Vector2) int {
+	for i, r := range s.regions {
+		if r.contains(pos, 0) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// findGhostRegions 除了owner之外, 扩展Margin后包含pos的区域
+func (s *Stitch) findGhostRegions(pos linemath.Vector2, owner int) []int {
+	var regions []int
+	for i, r := range s.regions {
+		if i != owner && r.contains(pos, s.margin) {
+			regions = append(regions, i)
+		}
+	}
+
+	return regions
+}
+
+func (r *_Region) contains(pos linemath.Vector2, margin float32) bool {
+	return pos.X >= r.MinPos.X-margin && pos.X <= r.MaxPos.X+margin && pos.Y >= r.MinPos.Y-margin && pos.Y <= r.MaxPos.Y+margin
+}
+
+// target 加入实例的对象, watcher需要用代理
+func (c *_CacheObject) target() aoi.IObject {
+	if c.proxy != nil {
+		return c.proxy
+	}
+
+	return c.obj
+}
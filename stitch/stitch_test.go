@@ -0,0 +1,230 @@
+package stitch
+
+import (
+	"aoi"
+	"aoi/aoitest"
+	"aoi/base/linemath"
+	"aoi/toweraoi"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// entered 进入视野的对象总数, 穿过边界时不能重复进入
+func entered(w *aoitest.Watcher) int {
+	n := 0
+	for _, ids := range w.Enters {
+		n += len(ids)
+	}
+
+	return n
+}
+
+func towerFactory(minPos, maxPos linemath.Vector2) (aoi.IAOI, error) {
+	return toweraoi.New(&toweraoi.Config{
+		MinPos:      minPos,
+		MaxPos:      maxPos,
+		TowerSize:   10,
+		ExactVisual: true,
+	})
+}
+
+// newTestStitch 2x2个区域拼成[-100, 100]的地图
+func newTestStitch(t *testing.T) *Stitch {
+	var regions []Region
+	for _, x := range []float32{-100, 0} {
+		for _, y := range []float32{-100, 0} {
+			regions = append(regions, Region{
+				MinPos: linemath.Vector2{X: x, Y: y},
+				MaxPos: linemath.Vector2{X: x + 100, Y: y + 100},
+			})
+		}
+	}
+
+	s, err := New(&Config{Regions: regions, Margin: 20, MaxVisual: 15, Factory: towerFactory})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestStitch_Config(t *testing.T) {
+	overlap := []Region{
+		{MinPos: linemath.Vector2{X: 0, Y: 0}, MaxPos: linemath.Vector2{X: 10, Y: 10}},
+		{MinPos: linemath.Vector2{X: 5, Y: 5}, MaxPos: linemath.Vector2{X: 15, Y: 15}},
+	}
+	if _, err := New(&Config{Regions: overlap, Margin: 20, MaxVisual: 15, Factory: towerFactory}); err != ErrStitchConfigInvalid {
+		t.Fatal("overlap", err)
+	}
+
+	if _, err := New(&Config{Regions: overlap[:1], Margin: 10, MaxVisual: 15, Factory: towerFactory}); err != ErrStitchConfigInvalid {
+		t.Fatal("margin smaller than visual", err)
+	}
+
+	s := newTestStitch(t)
+	if s.AddToAOI(&aoi.TestMarker{ID: "out", Pos: linemath.Vector2{X: 200}}) != aoi.ErrPosInvalid {
+		t.Fatal("pos invalid")
+	}
+	if s.AddToAOI(aoitest.NewWatcher("far-sighted", linemath.Vector2{}, 30)) != aoi.ErrObjectInvalid {
+		t.Fatal("visual larger than MaxVisual")
+	}
+}
+
+// TestStitch_GhostError 实例不接受镜像时返回错误, 不留下部分加入的对象
+func TestStitch_GhostError(t *testing.T) {
+	regions := []Region{
+		{MinPos: linemath.Vector2{X: -100, Y: -100}, MaxPos: linemath.Vector2{X: 0, Y: 100}},
+		{MinPos: linemath.Vector2{X: 0, Y: -100}, MaxPos: linemath.Vector2{X: 100, Y: 100}},
+	}
+	// 实例只覆盖区域本身, 边界外的镜像位置不合法
+	margin := linemath.Vector2{X: 20, Y: 20}
+	s, err := New(&Config{Regions: regions, Margin: 20, MaxVisual: 15, Factory: func(minPos, maxPos linemath.Vector2) (aoi.IAOI, error) {
+		return towerFactory(minPos.Add(margin), maxPos.Sub(margin))
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := &aoi.TestMarker{ID: "obj", Pos: linemath.Vector2{X: 5}}
+	if s.AddToAOI(o) != aoi.ErrPosInvalid || s.RegionOf(o) != -1 {
+		t.Fatal("ghost add error")
+	}
+	if nearest := s.GetAOI(1).(aoi.IAOIQuery).QueryKNearest(linemath.Vector2{}, 1); len(nearest) != 0 {
+		t.Fatal("owner not rolled back", nearest)
+	}
+
+	o.Pos.X = 50
+	if s.AddToAOI(o) != nil {
+		t.Fatal("add")
+	}
+	o.Pos.X = 5
+	if s.Move(o) != aoi.ErrPosInvalid || s.RegionOf(o) != 1 || len(s.objs["obj"].ghosts) != 0 {
+		t.Fatal("ghost move error")
+	}
+	s.RemoveFromAOI(o)
+	for i := range s.regions {
+		if nearest := s.GetAOI(i).(aoi.IAOIQuery).QueryKNearest(linemath.Vector2{}, 1); len(nearest) != 0 {
+			t.Fatal("object left", i, nearest)
+		}
+	}
+}
+
+func TestStitch_Cross(t *testing.T) {
+	s := newTestStitch(t)
+
+	w := aoitest.NewWatcher("watcher", linemath.Vector2{X: -5, Y: 50}, 15)
+	o := &aoi.TestMarker{ID: "obj", Pos: linemath.Vector2{X: 5, Y: 50}}
+	s.AddToAOI(w)
+	s.AddToAOI(o)
+	if s.RegionOf(w) == s.RegionOf(o) || w.Object("obj") != o {
+		t.Fatal("across the seam", w.Visible())
+	}
+
+	// 来回穿过边界不会重复进入
+	for i := 0; i < 4; i++ {
+		o.Pos.X = -o.Pos.X
+		s.Move(o)
+		w.Pos.X = -w.Pos.X
+		s.Move(w)
+	}
+	if entered(w) != 2 || w.Object("obj") != o || w.Object("watcher") != w {
+		t.Fatal("flicker", entered(w), w.Visible())
+	}
+
+	count := 0
+	s.Traversal(o, func(watcher aoi.IWatcher) bool {
+		if watcher != w {
+			t.Fatal("traversal got proxy")
+		}
+		count++
+		return true
+	})
+	if count != 1 {
+		t.Fatal("traversal", count)
+	}
+
+	o.Pos = linemath.Vector2{X: 50, Y: -50}
+	s.Move(o)
+	if w.Sees("obj") {
+		t.Fatal("moved away", w.Visible())
+	}
+
+	s.RemoveFromAOI(w)
+	if len(w.Visible()) != 0 {
+		t.Fatal("removed", w.Visible())
+	}
+	s.RemoveFromAOI(o)
+	for i := range s.regions {
+		if nearest := s.GetAOI(i).(aoi.IAOIQuery).QueryKNearest(linemath.Vector2{}, 1); len(nearest) != 0 {
+			t.Fatal("ghost left", i, nearest)
+		}
+	}
+}
+
+// TestStitch_Random 和覆盖整张地图的单个实例比较
+func TestStitch_Random(t *testing.T) {
+	s := newTestStitch(t)
+	single, _ := towerFactory(linemath.Vector2{X: -100, Y: -100}, linemath.Vector2{X: 100, Y: 100})
+
+	r := rand.New(rand.NewSource(1))
+	randPos := func() linemath.Vector2 {
+		return linemath.Vector2{X: r.Float32()*200 - 100, Y: r.Float32()*200 - 100}
+	}
+
+	type pair struct {
+		stitched, single aoi.IObject
+	}
+	var watchers [][2]*aoitest.Watcher
+	var pairs []pair
+	for i := 0; i < 200; i++ {
+		pos := randPos()
+		if i%4 == 0 {
+			id, visual := fmt.Sprintf("watcher:%d", i), r.Float32()*15
+			w := [2]*aoitest.Watcher{aoitest.NewWatcher(id, pos, visual), aoitest.NewWatcher(id, pos, visual)}
+			watchers = append(watchers, w)
+			pairs = append(pairs, pair{w[0], w[1]})
+		} else {
+			id := fmt.Sprintf("obj:%d", i)
+			pairs = append(pairs, pair{&aoi.TestMarker{ID: id, Pos: pos}, &aoi.TestMarker{ID: id, Pos: pos}})
+		}
+		s.AddToAOI(pairs[i].stitched)
+		single.AddToAOI(pairs[i].single)
+	}
+
+	setPos := func(obj aoi.IObject, pos linemath.Vector2) {
+		switch o := obj.(type) {
+		case *aoitest.Watcher:
+			o.Pos = pos
+		case *aoi.TestMarker:
+			o.Pos = pos
+		}
+	}
+
+	for i := 0; i < 5000; i++ {
+		p := pairs[r.Intn(len(pairs))]
+		// 大部分是小范围移动, 频繁穿过边界
+		pos := p.single.GetCoordPos().Add(linemath.Vector2{X: r.Float32()*10 - 5, Y: r.Float32()*10 - 5})
+		if pos.X < -100 || pos.X > 100 || pos.Y < -100 || pos.Y > 100 {
+			pos = randPos()
+		}
+		setPos(p.stitched, pos)
+		setPos(p.single, pos)
+		s.Move(p.stitched)
+		single.Move(p.single)
+	}
+
+	for _, w := range watchers {
+		if len(w[0].Visible()) != len(w[1].Visible()) || entered(w[0]) != entered(w[1]) {
+			t.Fatal(w[0].ID, "mismatch", len(w[0].Visible()), len(w[1].Visible()), entered(w[0]), entered(w[1]))
+		}
+		for _, id := range w[0].Visible() {
+			if !w[1].Sees(id) {
+				t.Fatal(w[0].ID, "extra", id)
+			}
+			if o := w[0].Object(id); unwrap(o) != o {
+				t.Fatal(w[0].ID, "wrapper leaked", id)
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package sweepaoi
+
+import "sort"
+
+// 端点类型, 值相同时按 min < point < max 排序, 区间包含边界
+const (
+	endpointMin = iota
+	endpointPoint
+	endpointMax
+)
+
+// _Endpoint 轴上的一个端点, 对象本身是point, watcher的视野区间是min和max
+type _Endpoint struct {
+	obj   *_CacheObject
+	kind  int
+	value float32
+	index int // 在轴上的下标
+}
+
+func (e *_Endpoint) less(o *_Endpoint) bool {
+	return e.value < o.value || (e.value == o.value && e.kind < o.kind)
+}
+
+// _Axis 一个坐标轴上按值排序的端点
+type _Axis struct {
+	list []*_Endpoint
+
+	// 端点交换时通知, moving为正在移动的端点, passed为被越过的端点
+	onSwap func(moving, passed *_Endpoint, right bool)
+}
+
+func (a *_Axis) Insert(e *_Endpoint) {
+	i := sort.Search(len(a.list), func(i int) bool {
+		return e.less(a.list[i])
+	})
+
+	a.list = append(a.list, nil)
+	copy(a.list[i+1:], a.list[i:])
+	a.list[i] = e
+	for j := i; j < len(a.list); j++ {
+		a.list[j].index = j
+	}
+}
+
+func (a *_Axis) Remove(e *_Endpoint) {
+	i := e.index
+	copy(a.list[i:], a.list[i+1:])
+	a.list[len(a.list)-1] = nil
+	a.list = a.list[:len(a.list)-1]
+	for j := i; j < len(a.list); j++ {
+		a.list[j].index = j
+	}
+}
+
+// Update 端点的值变化后通过相邻交换移动到新位置, 每次交换都会通知
+func (a *_Axis) Update(e *_Endpoint) {
+	for e.index+1 < len(a.list) && a.list[e.index+1].less(e) {
+		a.swap(e.index, e.index+1)
+		a.onSwap(e, a.list[e.index-1], true)
+	}
+
+	for e.index > 0 && e.less(a.list[e.index-1]) {
+		a.swap(e.index-1, e.index)
+		a.onSwap(e, a.list[e.index+1], false)
+	}
+}
+
+func (a *_Axis) swap(i, j int) {
+	a.list[i], a.list[j] = a.list[j], a.list[i]
+	a.list[i].index = i
+	a.list[j].index = j
+}
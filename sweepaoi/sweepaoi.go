@@ -0,0 +1,667 @@
+package sweepaoi
+
+import (
+	"aoi"
+	"aoi/base/linemath"
+	"aoi/internal/tower"
+	"errors"
+
+	log "github.com/cihub/seelog"
+)
+
+// SweepAOI 基于排序扫描(sweep and prune)的AOI实现
+// 每个对象在X和Y轴上各有一个点, 每个watcher在两个轴上还有视野区间的两个端点, 端点按坐标排序
+// 移动时端点通过相邻交换移动到新位置, 交换时增量维护对象和视野区间的重叠关系
+// 移动的代价与经过的端点数量成正比, 与视野大小无关, 适合视野大且各不相同的场景
+// 加入和移除的代价与对象总数成正比
+// 进出视野按照与watcher的实际距离判断
+type SweepAOI struct {
+	axes           [2]*_Axis
+	minPos, maxPos linemath.Vector2 // 地图边界
+	objs           map[string]*_CacheObject
+
+	// 所有watcher, 以及最大视野, 加入对象时用来查找包含它的视野区间
+	watchers  map[string]*_CacheObject
+	maxVisual float32
+
+	// 全局列表
+	global *tower.Tower
+
+	// 群组
+	groups      map[int]*tower.Tower
+	groupIDSeed int
+
+	// 缓存需要清理的watcher
+	dirtyWatchers map[string]*tower.WrapWatcher
+}
+
+const (
+	axisX = 0
+	axisY = 1
+)
+
+type _CacheObject struct {
+	obj         aoi.IObject
+	pos         linemath.Vector2 // 在轴上的位置
+	points      [2]*_Endpoint
+	global      bool // 全局对象仍然在轴上, 但不参与距离判断
+	wrapWatcher *tower.WrapWatcher
+	groups      []int
+
+	// 是watcher并且视野大于0时, 视野区间在两个轴上的端点
+	mins, maxs [2]*_Endpoint
+
+	// 是watcher时, 在视野区间内的对象及重叠的轴数量, 两个轴都重叠时才需要判断距离
+	overlaps map[string]int
+	boxed    map[string]*_CacheObject
+
+	// 视野区间包含这个对象的watcher
+	containedBy map[string]*_CacheObject
+	boxedBy     map[string]*_CacheObject
+
+	seenBy map[string]*_CacheObject // 视野内有这个对象的watcher
+	sees   map[string]*_CacheObject // 是watcher时, 视野内的对象
+}
+
+var (
+	ErrSweepConfigInvalid = errors.New("config invalid")
+)
+
+type Config struct {
+	MinPos linemath.Vector2
+	MaxPos linemath.Vector2
+}
+
+func New(cfg *Config) (aoi.IAOI, error) {
+	if cfg.MinPos.X > cfg.MaxPos.X || cfg.MinPos.Y > cfg.MaxPos.Y {
+		return nil, ErrSweepConfigInvalid
+	}
+
+	sa := &SweepAOI{
+		minPos:        cfg.MinPos,
+		maxPos:        cfg.MaxPos,
+		objs:          make(map[string]*_CacheObject),
+		watchers:      make(map[string]*_CacheObject),
+		global:        tower.NewTower(),
+		groups:        make(map[int]*tower.Tower),
+		dirtyWatchers: make(map[string]*tower.WrapWatcher),
+	}
+	for axis := range sa.axes {
+		sa.axes[axis] = &_Axis{onSwap: sa.onSwap}
+	}
+
+	return sa, nil
+}
+
+func (s *SweepAOI) AddToAOI(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	if _, ok := s.objs[obj.GetAOIID()]; ok {
+		return aoi.ErrObjectExisted
+	}
+
+	pos := obj.GetCoordPos()
+	if !s.isInvalid(pos) {
+		return aoi.ErrPosInvalid
+	}
+
+	cacheObj := &_CacheObject{
+		obj:         obj,
+		pos:         pos,
+		containedBy: make(map[string]*_CacheObject),
+		boxedBy:     make(map[string]*_CacheObject),
+		seenBy:      make(map[string]*_CacheObject),
+	}
+	s.objs[obj.GetAOIID()] = cacheObj
+	s.insertPoints(cacheObj)
+
+	if w, ok := obj.(aoi.IWatcher); ok {
+		ww := tower.NewWrapWatcher(w, s.notifyDirty)
+		cacheObj.wrapWatcher = ww
+		cacheObj.overlaps = make(map[string]int)
+		cacheObj.boxed = make(map[string]*_CacheObject)
+		cacheObj.sees = make(map[string]*_CacheObject)
+		s.watchers[w.GetAOIID()] = cacheObj
+		if visual := w.GetVisual(); visual > s.maxVisual {
+			s.maxVisual = visual
+		}
+
+		s.global.AddWatcher(ww)
+		s.insertInterval(cacheObj)
+	}
+
+	s.flushWatchers()
+
+	return nil
+}
+
+func (s *SweepAOI) RemoveFromAOI(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := s.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+	delete(s.objs, obj.GetAOIID())
+
+	// 从所有group中移除
+	for _, groupID := range cacheObj.groups {
+		if group, existed := s.groups[groupID]; existed {
+			if group.Existed(obj.GetAOIID()) {
+				group.Remove(obj)
+				if cacheObj.wrapWatcher != nil {
+					group.RemoveWatcher(cacheObj.wrapWatcher)
+				}
+
+				if group.GetObjsLen() == 0 && group.GetWatchersLen() == 0 {
+					delete(s.groups, groupID)
+				}
+			} else {
+				log.Debug("奇怪的事情发生了, 检查代码")
+			}
+		}
+	}
+
+	if s.global.Existed(obj.GetAOIID()) {
+		s.global.Remove(obj)
+	}
+
+	// watcher的视野区间包含自己, 这里会一起清除
+	for _, watcherObj := range cacheObj.containedBy {
+		s.clearOverlap(watcherObj, cacheObj)
+	}
+
+	if ww := cacheObj.wrapWatcher; ww != nil {
+		for id := range cacheObj.overlaps {
+			s.clearOverlap(cacheObj, s.objs[id])
+		}
+		s.global.RemoveWatcher(ww)
+
+		delete(s.watchers, ww.GetAOIID())
+		if ww.GetVisual() >= s.maxVisual {
+			s.resetMaxVisual()
+		}
+
+		for axis := range s.axes {
+			if cacheObj.mins[axis] != nil {
+				s.axes[axis].Remove(cacheObj.mins[axis])
+				s.axes[axis].Remove(cacheObj.maxs[axis])
+			}
+		}
+	}
+
+	for axis := range s.axes {
+		s.axes[axis].Remove(cacheObj.points[axis])
+	}
+
+	s.flushWatchers()
+
+	return nil
+}
+
+func (s *SweepAOI) Move(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := s.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	pos := obj.GetCoordPos()
+	if !s.isInvalid(pos) {
+		return aoi.ErrPosInvalid
+	}
+
+	s.moveTo(cacheObj, pos)
+
+	s.flushWatchers()
+
+	return nil
+}
+
+func (s *SweepAOI) AddGlobalMarker(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := s.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	if s.global.Existed(obj.GetAOIID()) {
+		return aoi.ErrObjectExisted
+	}
+
+	s.global.Add(obj)
+
+	cacheObj.global = true
+	for _, watcherObj := range cacheObj.seenBy {
+		s.setSeen(watcherObj, cacheObj, false)
+	}
+
+	s.flushWatchers()
+
+	return nil
+}
+
+func (s *SweepAOI) RemoveGlobalMarker(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	if !s.global.Existed(obj.GetAOIID()) {
+		return aoi.ErrObjectNotExisted
+	}
+
+	pos := obj.GetCoordPos()
+	if !s.isInvalid(pos) {
+		return aoi.ErrPosInvalid
+	}
+
+	cacheObj := s.objs[obj.GetAOIID()]
+	cacheObj.global = false
+	s.moveTo(cacheObj, pos)
+	for _, watcherObj := range cacheObj.boxedBy {
+		s.updateSeen(watcherObj, cacheObj)
+	}
+
+	s.global.Remove(obj)
+
+	s.flushWatchers()
+
+	return nil
+}
+
+func (s *SweepAOI) Traversal(obj aoi.IObject, cb func(obj aoi.IWatcher) bool) {
+	if obj == nil {
+		return
+	}
+
+	cacheObject, ok := s.objs[obj.GetAOIID()]
+	if !ok {
+		return
+	}
+
+	// 全局对象, 直接遍历所有的watcher就可以
+	if s.global.Existed(obj.GetAOIID()) {
+		s.global.Traversal(obj, cb)
+		return
+	}
+
+	calledWatchers := make(map[string]bool)
+	for _, groupID := range cacheObject.groups {
+		s.TraversalGroup(obj, groupID, func(watcher aoi.IWatcher) bool {
+			if _, called := calledWatchers[watcher.GetAOIID()]; !called {
+				calledWatchers[watcher.GetAOIID()] = true
+				return cb(watcher)
+			}
+			return true
+		})
+	}
+
+	for _, watcherObj := range cacheObject.seenBy {
+		if _, called := calledWatchers[watcherObj.obj.GetAOIID()]; !called {
+			if !cb(watcherObj.wrapWatcher) {
+				return
+			}
+		}
+	}
+}
+
+func (s *SweepAOI) CreateGroup(objs []aoi.IObject) (int, error) {
+	if len(objs) == 0 {
+		return 0, aoi.ErrObjectInvalid
+	}
+
+	// 检查所有objs是否在AOI中
+	for _, o := range objs {
+		if _, ok := s.objs[o.GetAOIID()]; !ok {
+			return 0, aoi.ErrObjectNotExisted
+		}
+	}
+
+	// 构建群组
+	group := tower.NewTower()
+	s.groupIDSeed++
+	s.groups[s.groupIDSeed] = group
+	for _, o := range objs {
+		group.Add(o)
+
+		cacheObj := s.objs[o.GetAOIID()]
+		cacheObj.groups = append(cacheObj.groups, s.groupIDSeed)
+
+		if cacheObj.wrapWatcher != nil {
+			group.AddWatcher(cacheObj.wrapWatcher)
+		}
+	}
+
+	s.flushWatchers()
+
+	return s.groupIDSeed, nil
+}
+
+func (s *SweepAOI) DestroyGroup(groupID int) error {
+	group, ok := s.groups[groupID]
+	if !ok {
+		return aoi.ErrGroupNotExisted
+	}
+
+	for _, o := range group.GetObjs() {
+		cacheObj := s.objs[o.GetAOIID()]
+		cacheObj.groups = removeGroupID(cacheObj.groups, groupID)
+	}
+
+	group.Clear()
+	delete(s.groups, groupID)
+
+	s.flushWatchers()
+
+	return nil
+}
+
+func (s *SweepAOI) AddToGroup(obj aoi.IObject, groupID int) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := s.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	group, ok := s.groups[groupID]
+	if !ok {
+		return aoi.ErrGroupNotExisted
+	}
+
+	if group.Existed(obj.GetAOIID()) {
+		return nil
+	}
+
+	group.Add(obj)
+	if cacheObj.wrapWatcher != nil {
+		group.AddWatcher(cacheObj.wrapWatcher)
+	}
+
+	cacheObj.groups = append(cacheObj.groups, groupID)
+
+	s.flushWatchers()
+
+	return nil
+}
+
+func (s *SweepAOI) RemoveFromGroup(obj aoi.IObject, groupID int) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := s.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	group, ok := s.groups[groupID]
+	if !ok {
+		return aoi.ErrGroupNotExisted
+	}
+
+	if !group.Existed(obj.GetAOIID()) {
+		return nil
+	}
+
+	group.Remove(obj)
+	if cacheObj.wrapWatcher != nil {
+		group.RemoveWatcher(cacheObj.wrapWatcher)
+	}
+
+	cacheObj.groups = removeGroupID(cacheObj.groups, groupID)
+
+	if group.GetWatchersLen() == 0 && group.GetObjsLen() == 0 {
+		delete(s.groups, groupID)
+	}
+
+	s.flushWatchers()
+
+	return nil
+}
+
+func (s *SweepAOI) TraversalGroup(obj aoi.IObject, groupID int, cb func(watcher aoi.IWatcher) bool) {
+	if obj == nil {
+		return
+	}
+
+	if _, ok := s.objs[obj.GetAOIID()]; !ok {
+		return
+	}
+
+	group, ok := s.groups[groupID]
+	if !ok {
+		return
+	}
+
+	group.Traversal(obj, cb)
+}
+
+// insertPoints 插入对象在两个轴上的点, 找出视野区间包含它的watcher
+func (s *SweepAOI) insertPoints(cacheObj *_CacheObject) {
+	for axis, a := range s.axes {
+		p := &_Endpoint{obj: cacheObj, kind: endpointPoint, value: coord(cacheObj.pos, axis)}
+		cacheObj.points[axis] = p
+		a.Insert(p)
+
+		// 包含这个点的区间, 起点一定在[value-2*maxVisual, value]之间
+		for i := p.index - 1; i >= 0 && a.list[i].value >= p.value-2*s.maxVisual; i-- {
+			e := a.list[i]
+			if e.kind == endpointMin && e.obj.maxs[axis].index > p.index {
+				s.enterOverlap(e.obj, cacheObj)
+			}
+		}
+	}
+}
+
+// insertInterval 插入watcher的视野区间, 区间内的点就是重叠的对象
+func (s *SweepAOI) insertInterval(watcherObj *_CacheObject) {
+	visual := watcherObj.wrapWatcher.GetVisual()
+	if visual <= 0 {
+		return
+	}
+
+	for axis, a := range s.axes {
+		c := coord(watcherObj.pos, axis)
+		watcherObj.mins[axis] = &_Endpoint{obj: watcherObj, kind: endpointMin, value: c - visual}
+		watcherObj.maxs[axis] = &_Endpoint{obj: watcherObj, kind: endpointMax, value: c + visual}
+		a.Insert(watcherObj.mins[axis])
+		a.Insert(watcherObj.maxs[axis])
+
+		for i := watcherObj.mins[axis].index + 1; i < watcherObj.maxs[axis].index; i++ {
+			if e := a.list[i]; e.kind == endpointPoint {
+				s.enterOverlap(watcherObj, e.obj)
+			}
+		}
+	}
+}
+
+// moveTo 更新端点的坐标并移动到新位置, 然后重新判断距离
+func (s *SweepAOI) moveTo(cacheObj *_CacheObject, pos linemath.Vector2) {
+	old := cacheObj.pos
+	cacheObj.pos = pos
+
+	for axis, a := range s.axes {
+		c := coord(pos, axis)
+		p := cacheObj.points[axis]
+		p.value = c
+		if cacheObj.mins[axis] == nil {
+			a.Update(p)
+			continue
+		}
+
+		visual := cacheObj.wrapWatcher.GetVisual()
+		minEp, maxEp := cacheObj.mins[axis], cacheObj.maxs[axis]
+		minEp.value, maxEp.value = c-visual, c+visual
+
+		// 按移动方向先处理前面的端点, 自己的端点之间不会互相越过
+		if c > coord(old, axis) {
+			a.Update(maxEp)
+			a.Update(p)
+			a.Update(minEp)
+		} else {
+			a.Update(minEp)
+			a.Update(p)
+			a.Update(maxEp)
+		}
+	}
+
+	for _, watcherObj := range cacheObj.boxedBy {
+		s.updateSeen(watcherObj, cacheObj)
+	}
+
+	if cacheObj.wrapWatcher != nil {
+		for _, o := range cacheObj.boxed {
+			s.updateSeen(cacheObj, o)
+		}
+	}
+}
+
+// onSwap 端点越过另一个端点时, 点和区间的包含关系可能发生变化
+func (s *SweepAOI) onSwap(moving, passed *_Endpoint, right bool) {
+	if moving.obj == passed.obj {
+		return
+	}
+
+	switch {
+	case moving.kind == endpointPoint && passed.kind == endpointMin:
+		s.toggleOverlap(passed.obj, moving.obj, right)
+	case moving.kind == endpointPoint && passed.kind == endpointMax:
+		s.toggleOverlap(passed.obj, moving.obj, !right)
+	case moving.kind == endpointMin && passed.kind == endpointPoint:
+		s.toggleOverlap(moving.obj, passed.obj, !right)
+	case moving.kind == endpointMax && passed.kind == endpointPoint:
+		s.toggleOverlap(moving.obj, passed.obj, right)
+	}
+}
+
+func (s *SweepAOI) toggleOverlap(watcherObj, cacheObj *_CacheObject, enter bool) {
+	if enter {
+		s.enterOverlap(watcherObj, cacheObj)
+	} else {
+		s.leaveOverlap(watcherObj, cacheObj)
+	}
+}
+
+// enterOverlap 对象在一个轴上进入了watcher的视野区间
+func (s *SweepAOI) enterOverlap(watcherObj, cacheObj *_CacheObject) {
+	id := cacheObj.obj.GetAOIID()
+	watcherObj.overlaps[id]++
+	if watcherObj.overlaps[id] == 1 {
+		cacheObj.containedBy[watcherObj.obj.GetAOIID()] = watcherObj
+		return
+	}
+
+	watcherObj.boxed[id] = cacheObj
+	cacheObj.boxedBy[watcherObj.obj.GetAOIID()] = watcherObj
+	s.updateSeen(watcherObj, cacheObj)
+}
+
+// leaveOverlap 对象在一个轴上离开了watcher的视野区间
+func (s *SweepAOI) leaveOverlap(watcherObj, cacheObj *_CacheObject) {
+	id := cacheObj.obj.GetAOIID()
+	watcherObj.overlaps[id]--
+	if watcherObj.overlaps[id] > 0 {
+		delete(watcherObj.boxed, id)
+		delete(cacheObj.boxedBy, watcherObj.obj.GetAOIID())
+		s.setSeen(watcherObj, cacheObj, false)
+		return
+	}
+
+	delete(watcherObj.overlaps, id)
+	delete(cacheObj.containedBy, watcherObj.obj.GetAOIID())
+}
+
+// clearOverlap 移除对象时清除所有重叠关系
+func (s *SweepAOI) clearOverlap(watcherObj, cacheObj *_CacheObject) {
+	id, watcherID := cacheObj.obj.GetAOIID(), watcherObj.obj.GetAOIID()
+	s.setSeen(watcherObj, cacheObj, false)
+	delete(watcherObj.overlaps, id)
+	delete(watcherObj.boxed, id)
+	delete(cacheObj.containedBy, watcherID)
+	delete(cacheObj.boxedBy, watcherID)
+}
+
+// updateSeen 两个轴都重叠时按实际距离判断可见性
+func (s *SweepAOI) updateSeen(watcherObj, cacheObj *_CacheObject) {
+	_, boxed := watcherObj.boxed[cacheObj.obj.GetAOIID()]
+	seen := boxed && !cacheObj.global &&
+		cacheObj.pos.Sub(watcherObj.pos).Len() <= watcherObj.wrapWatcher.GetVisual()
+	s.setSeen(watcherObj, cacheObj, seen)
+}
+
+// setSeen 修改watcher对对象的空间可见性, 重复设置忽略
+func (s *SweepAOI) setSeen(watcherObj *_CacheObject, cacheObj *_CacheObject, seen bool) {
+	watcherID, id := watcherObj.obj.GetAOIID(), cacheObj.obj.GetAOIID()
+	if _, ok := cacheObj.seenBy[watcherID]; ok == seen {
+		return
+	}
+
+	if seen {
+		cacheObj.seenBy[watcherID] = watcherObj
+		watcherObj.sees[id] = cacheObj
+		watcherObj.wrapWatcher.OnObjectEnter(cacheObj.obj)
+	} else {
+		delete(cacheObj.seenBy, watcherID)
+		delete(watcherObj.sees, id)
+		watcherObj.wrapWatcher.OnObjectLeave(cacheObj.obj)
+	}
+}
+
+func (s *SweepAOI) resetMaxVisual() {
+	s.maxVisual = 0
+	for _, watcherObj := range s.watchers {
+		if visual := watcherObj.wrapWatcher.GetVisual(); visual > s.maxVisual {
+			s.maxVisual = visual
+		}
+	}
+}
+
+func (s *SweepAOI) isInvalid(pos linemath.Vector2) bool {
+	if pos.X < s.minPos.X || pos.X > s.maxPos.X || pos.Y < s.minPos.Y || pos.Y > s.maxPos.Y {
+		return false
+	}
+
+	return true
+}
+
+func (s *SweepAOI) notifyDirty(ww *tower.WrapWatcher) {
+	s.dirtyWatchers[ww.GetAOIID()] = ww
+}
+
+func (s *SweepAOI) flushWatchers() {
+	for id, w := range s.dirtyWatchers {
+		w.Flush()
+		delete(s.dirtyWatchers, id)
+	}
+}
+
+func coord(pos linemath.Vector2, axis int) float32 {
+	if axis == axisX {
+		return pos.X
+	}
+
+	return pos.Y
+}
+
+func removeGroupID(groups []int, groupID int) []int {
+	for i := range groups {
+		if groups[i] == groupID {
+			return append(groups[:i], groups[i+1:]...)
+		}
+	}
+
+	return groups
+}
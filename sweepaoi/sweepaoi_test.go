@@ -0,0 +1,237 @@
+package sweepaoi
+
+import (
+	"aoi"
+	"aoi/aoitest"
+	"aoi/base/linemath"
+	"aoi/toweraoi"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// 测试用例同时在精确视野模式的灯塔AOI上运行, 两者的结果应该完全一致
+var testFactories = []struct {
+	name string
+	new  func() (aoi.IAOI, error)
+}{
+	{"tower", func() (aoi.IAOI, error) {
+		return toweraoi.New(&toweraoi.Config{
+			MinPos:      linemath.Vector2{X: -100, Y: -100},
+			MaxPos:      linemath.Vector2{X: 100, Y: 100},
+			TowerSize:   10,
+			ExactVisual: true,
+		})
+	}},
+	{"sweep", func() (aoi.IAOI, error) {
+		return New(&Config{
+			MinPos: linemath.Vector2{X: -100, Y: -100},
+			MaxPos: linemath.Vector2{X: 100, Y: 100},
+		})
+	}},
+}
+
+func runAll(t *testing.T, f func(t *testing.T, a aoi.IAOI)) {
+	for _, factory := range testFactories {
+		t.Run(factory.name, func(t *testing.T) {
+			a, err := factory.new()
+			if err != nil {
+				t.Fatal(err)
+			}
+			f(t, a)
+		})
+	}
+}
+
+func TestSweepAOI_Visual(t *testing.T) {
+	runAll(t, func(t *testing.T, a aoi.IAOI) {
+		w := aoitest.NewWatcher("watcher", linemath.Vector2{}, 10)
+		o := &aoi.TestMarker{ID: "obj", Pos: linemath.Vector2{X: 8, Y: 0}}
+		corner := &aoi.TestMarker{ID: "corner", Pos: linemath.Vector2{X: 8, Y: 8}}
+		a.AddToAOI(o)
+		a.AddToAOI(w)
+		a.AddToAOI(corner)
+		if !w.Sees("obj") || !w.Sees("watcher") || w.Sees("corner") {
+			t.Fatal("visible", w.Visible())
+		}
+
+		w.Pos = linemath.Vector2{X: 8, Y: 5}
+		a.Move(w)
+		if !w.Sees("obj") || !w.Sees("corner") {
+			t.Fatal("watcher moved", w.Visible())
+		}
+
+		o.Pos = linemath.Vector2{X: 50, Y: 50}
+		a.Move(o)
+		if w.Sees("obj") {
+			t.Fatal("obj moved out")
+		}
+
+		a.AddGlobalMarker(o)
+		if !w.Sees("obj") {
+			t.Fatal("global marker should be visible")
+		}
+		a.RemoveGlobalMarker(o)
+		if w.Sees("obj") {
+			t.Fatal("global marker removed")
+		}
+
+		groupID, _ := a.CreateGroup([]aoi.IObject{w, o})
+		if !w.Sees("obj") {
+			t.Fatal("group member should be visible")
+		}
+		a.DestroyGroup(groupID)
+		if w.Sees("obj") {
+			t.Fatal("group destroyed")
+		}
+
+		if a.AddToAOI(&aoi.TestMarker{ID: "out", Pos: linemath.Vector2{X: 1000}}) != aoi.ErrPosInvalid {
+			t.Fatal("pos invalid")
+		}
+
+		a.RemoveFromAOI(w)
+		if len(w.Visible()) != 0 {
+			t.Fatal("removed watcher still sees", w.Visible())
+		}
+	})
+}
+
+func TestSweepAOI_LargeVisual(t *testing.T) {
+	runAll(t, func(t *testing.T, a aoi.IAOI) {
+		big := aoitest.NewWatcher("big", linemath.Vector2{X: -90, Y: -90}, 150)
+		small := aoitest.NewWatcher("small", linemath.Vector2{X: 90, Y: 90}, 1)
+		a.AddToAOI(big)
+		a.AddToAOI(small)
+		if big.Sees("small") || small.Sees("big") {
+			t.Fatal("too far", big.Visible(), small.Visible())
+		}
+
+		big.Pos = linemath.Vector2{X: 0, Y: 0}
+		a.Move(big)
+		if !big.Sees("small") || small.Sees("big") {
+			t.Fatal("big watcher moved", big.Visible(), small.Visible())
+		}
+
+		small.Pos = linemath.Vector2{X: 0.5, Y: 0.5}
+		a.Move(small)
+		if !big.Sees("small") || !small.Sees("big") {
+			t.Fatal("small watcher moved", big.Visible(), small.Visible())
+		}
+
+		a.RemoveFromAOI(small)
+		if big.Sees("small") {
+			t.Fatal("small removed")
+		}
+	})
+}
+
+// TestSweepAOI_Random 对两个实现执行相同的随机操作, 比较视野内的对象
+func TestSweepAOI_Random(t *testing.T) {
+	var results []map[string][]string
+	for _, factory := range testFactories {
+		a, err := factory.new()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := rand.New(rand.NewSource(1))
+		randPos := func() linemath.Vector2 {
+			return linemath.Vector2{X: r.Float32()*200 - 100, Y: r.Float32()*200 - 100}
+		}
+
+		var watchers []*aoitest.Watcher
+		var all []aoi.IObject
+		for i := 0; i < 100; i++ {
+			if i%4 == 0 {
+				// 视野大小差别很大
+				w := aoitest.NewWatcher(fmt.Sprintf("watcher:%d", i), randPos(), 1+r.Float32()*r.Float32()*120)
+				watchers = append(watchers, w)
+				all = append(all, w)
+			} else {
+				all = append(all, &aoi.TestMarker{ID: fmt.Sprintf("obj:%d", i), Pos: randPos()})
+			}
+			a.AddToAOI(all[i])
+		}
+
+		removed := make(map[string]bool)
+		for i := 0; i < 3000; i++ {
+			o := all[r.Intn(len(all))]
+			id := o.GetAOIID()
+			switch op := r.Intn(20); {
+			case op == 0 && !removed[id]:
+				a.RemoveFromAOI(o)
+				removed[id] = true
+			case op == 0:
+				a.AddToAOI(o)
+				delete(removed, id)
+			default:
+				switch o := o.(type) {
+				case *aoitest.Watcher:
+					o.Pos = randPos()
+				case *aoi.TestMarker:
+					o.Pos = randPos()
+				}
+				a.Move(o)
+			}
+		}
+
+		result := make(map[string][]string)
+		for _, w := range watchers {
+			if removed[w.ID] {
+				if len(w.Visible()) != 0 {
+					t.Fatal(factory.name, w.ID, "removed watcher still sees", w.Visible())
+				}
+				continue
+			}
+			for _, o := range all {
+				in := !removed[o.GetAOIID()] && o.GetCoordPos().Sub(w.Pos).Len() <= w.Visual
+				if in != w.Sees(o.GetAOIID()) {
+					t.Fatal(factory.name, w.ID, "visible mismatch", o.GetAOIID(), in)
+				}
+			}
+			result[w.ID] = w.Visible()
+		}
+		results = append(results, result)
+	}
+
+	if fmt.Sprint(results[0]) != fmt.Sprint(results[1]) {
+		t.Fatal("tower and sweep differ")
+	}
+}
+
+func BenchmarkSweepAOI_Move(b *testing.B) {
+	a, _ := New(&Config{
+		MinPos: linemath.Vector2{X: -100, Y: -100},
+		MaxPos: linemath.Vector2{X: 100, Y: 100},
+	})
+	r := rand.New(rand.NewSource(1))
+
+	var objs []*aoi.TestMarker
+	for i := 0; i < 2000; i++ {
+		pos := linemath.Vector2{X: r.Float32()*200 - 100, Y: r.Float32()*200 - 100}
+		if i%10 == 0 {
+			a.AddToAOI(aoitest.NewWatcher(fmt.Sprintf("watcher:%d", i), pos, 5+r.Float32()*50))
+			continue
+		}
+		o := &aoi.TestMarker{ID: fmt.Sprintf("obj:%d", i), Pos: pos}
+		objs = append(objs, o)
+		a.AddToAOI(o)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		o := objs[i%len(objs)]
+		o.Pos.X += r.Float32() - 0.5
+		o.Pos.Y += r.Float32() - 0.5
+		if a.Move(o) == aoi.ErrPosInvalid {
+			o.Pos = linemath.Vector2{}
+			a.Move(o)
+		}
+	}
+}
+
+func TestSweepAOI_Conformance(t *testing.T) {
+	aoitest.RunConformance(t, aoitest.Factory{New: func() (aoi.IAOI, error) {
+		return New(&Config{MinPos: aoitest.MinPos, MaxPos: aoitest.MaxPos})
+	}})
+}
@@ -0,0 +1,215 @@
+package toweraoi
+
+import (
+	"aoi"
+	"aoi/base/linemath"
+	"math"
+	"sort"
+)
+
+type _QueryResult struct {
+	obj  aoi.IObject
+	dist float32
+}
+
+// QueryRadius 遍历圆形范围内的对象(包括全局对象), cb返回false时停止
+func (t *TowerAOI) QueryRadius(pos linemath.Vector2, radius float32, cb func(obj aoi.IObject) bool) {
+	if radius < 0 {
+		return
+	}
+
+	minPos := linemath.Vector2{X: pos.X - radius, Y: pos.Y - radius}
+	maxPos := linemath.Vector2{X: pos.X + radius, Y: pos.Y + radius}
+	t.QueryRect(minPos, maxPos, func(obj aoi.IObject) bool {
+		if obj.GetCoordPos().Sub(pos).Len() > radius {
+			return true
+		}
+		return cb(obj)
+	})
+}
+
+// QueryRect 遍历矩形范围内的对象(包括全局对象), cb返回false时停止
+func (t *TowerAOI) QueryRect(minPos, maxPos linemath.Vector2, cb func(obj aoi.IObject) bool) {
+	if minPos.X > maxPos.X || minPos.Y > maxPos.Y {
+		return
+	}
+
+	inRect := func(obj aoi.IObject) bool {
+		p := obj.GetCoordPos()
+		return p.X >= minPos.X && p.X <= maxPos.X && p.Y >= minPos.Y && p.Y <= maxPos.Y
+	}
+
+	startX, startY := t.transPos(minPos)
+	endX, endY := t.transPos(maxPos)
+	if !t.sparse {
+		startX, endX = clampRange(startX, endX, t.towerSizeX)
+		startY, endY = clampRange(startY, endY, t.towerSizeY)
+	}
+
+	// 范围内的灯塔比现有的灯塔还多时, 直接遍历现有的灯塔
+	if area := float64(endX-startX+1) * float64(endY-startY+1); area > float64(t.towers.len()) {
+		stopped := false
+		t.towers.traversal(func(x, y int, tower *Tower) {
+			if stopped || x < startX || x > endX || y < startY || y > endY {
+				return
+			}
+			for _, o := range tower.GetObjs() {
+				if inRect(o) && !cb(o) {
+					stopped = true
+					return
+				}
+			}
+		})
+		if stopped {
+			return
+		}
+	} else {
+		for i := startX; i <= endX; i++ {
+			for j := startY; j <= endY; j++ {
+				tower := t.towers.findTower(i, j)
+				if tower == nil {
+					continue
+				}
+				for _, o := range tower.GetObjs() {
+					if inRect(o) && !cb(o) {
+						return
+					}
+				}
+			}
+		}
+	}
+
+	for _, o := range t.global.GetObjs() {
+		if inRect(o) && !cb(o) {
+			return
+		}
+	}
+}
+
+// QueryKNearest 距离最近的k个对象(包括全局对象), 由近到远排列, pos不是有限值时返回nil
+// 以pos所在灯塔为中心逐圈向外扩展, 直到剩余灯塔不可能更近为止. 地图外的pos从最近的边缘灯塔开始
+func (t *TowerAOI) QueryKNearest(pos linemath.Vector2, k int) []aoi.IObject {
+	if k <= 0 || math.IsNaN(float64(pos.X)) || math.IsInf(float64(pos.X), 0) ||
+		math.IsNaN(float64(pos.Y)) || math.IsInf(float64(pos.Y), 0) {
+		return nil
+	}
+
+	results := make([]_QueryResult, 0, k)
+	add := func(obj aoi.IObject) {
+		results = append(results, _QueryResult{obj: obj, dist: obj.GetCoordPos().Sub(pos).Len()})
+	}
+
+	for _, o := range t.global.GetObjs() {
+		add(o)
+	}
+
+	visit := func(i, j int) {
+		tower := t.towers.findTower(i, j)
+		if tower == nil {
+			return
+		}
+		for _, o := range tower.GetObjs() {
+			add(o)
+		}
+	}
+
+	x, y := t.transPos(pos)
+	if !t.sparse {
+		x, y = clampTower(x, t.towerSizeX), clampTower(y, t.towerSizeY)
+	}
+	for ring := 0; ; ring++ {
+		startX, endX, startY, endY := x-ring, x+ring, y-ring, y+ring
+
+		// 无边界模式下灯塔稀疏, 圈内的灯塔比现有的灯塔还多时, 直接遍历还没访问过的灯塔
+		if t.sparse && float64(2*ring+1)*float64(2*ring+1) > float64(t.towers.len()) {
+			t.towers.traversal(func(i, j int, tower *Tower) {
+				if i <= startX || i >= endX || j <= startY || j >= endY {
+					for _, o := range tower.GetObjs() {
+						add(o)
+					}
+				}
+			})
+			break
+		}
+		// 只遍历这一圈的灯塔
+		for i := startX; i <= endX; i++ {
+			if i == startX || i == endX {
+				for j := startY; j <= endY; j++ {
+					visit(i, j)
+				}
+			} else {
+				visit(i, startY)
+				if endY != startY {
+					visit(i, endY)
+				}
+			}
+		}
+
+		// 已经找到所有对象, 或者覆盖了所有灯塔
+		if len(results) >= len(t.objs) {
+			break
+		}
+		if !t.sparse && startX <= 0 && startY <= 0 && endX >= t.towerSizeX-1 && endY >= t.towerSizeY-1 {
+			break
+		}
+
+		// 圈外对象到pos的最短距离
+		bound := pos.X - (t.minPos.X + float32(startX)*t.towerSize)
+		if d := t.minPos.X + float32(endX+1)*t.towerSize - pos.X; d < bound {
+			bound = d
+		}
+		if d := pos.Y - (t.minPos.Y + float32(startY)*t.towerSize); d < bound {
+			bound = d
+		}
+		if d := t.minPos.Y + float32(endY+1)*t.towerSize - pos.Y; d < bound {
+			bound = d
+		}
+
+		if len(results) >= k {
+			sortQueryResults(results)
+			if results[k-1].dist <= bound {
+				break
+			}
+		}
+	}
+
+	sortQueryResults(results)
+	if len(results) > k {
+		results = results[:k]
+	}
+
+	objs := make([]aoi.IObject, 0, len(results))
+	for _, r := range results {
+		objs = append(objs, r.obj)
+	}
+
+	return objs
+}
+
+func sortQueryResults(results []_QueryResult) {
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].dist < results[j].dist
+	})
+}
+
+func clampTower(i, size int) int {
+	if i < 0 {
+		return 0
+	}
+	if i > size-1 {
+		return size - 1
+	}
+
+	return i
+}
+
+func clampRange(start, end, size int) (int, int) {
+	if start < 0 {
+		start = 0
+	}
+	if end > size-1 {
+		end = size - 1
+	}
+
+	return start, end
+}
@@ -0,0 +1,144 @@
+package toweraoi
+
+import (
+	"aoi"
+	"aoi/base/linemath"
+	"sort"
+)
+
+// RegionRecord 迁移区域时一个对象的记录, 字段都是导出的, 可以直接序列化后发送给其他进程
+type RegionRecord struct {
+	ID      string
+	Pos     linemath.Vector2
+	Watcher bool
+	Visual  float32 // watcher的视野, 不是watcher时为0
+	Global  bool
+	Groups  []int // 所在源AOI中的群组ID, 导入时同一个群组的对象重新建组
+}
+
+// ExtractRegion 把矩形范围内(包括边界)的对象从AOI中移出, 返回对象的记录, 按ID排序
+// 全局对象同样按当前位置归属区域, 一起移出, 记录中Global为true, 导入后仍是全局对象
+// 所有移除在一次批量操作中完成, 范围外的watcher每个最多收到一次离开
+func (t *TowerAOI) ExtractRegion(minPos, maxPos linemath.Vector2) ([]RegionRecord, error) {
+	if minPos.X > maxPos.X || minPos.Y > maxPos.Y {
+		return nil, aoi.ErrPosInvalid
+	}
+
+	var objs []aoi.IObject
+	t.QueryRect(minPos, maxPos, func(obj aoi.IObject) bool {
+		objs = append(objs, obj)
+		return true
+	})
+	sort.Slice(objs, func(i, j int) bool {
+		return objs[i].GetAOIID() < objs[j].GetAOIID()
+	})
+
+	records := make([]RegionRecord, 0, len(objs))
+	for _, obj := range objs {
+		cacheObj := t.objs[obj.GetAOIID()]
+		record := RegionRecord{
+			ID:     obj.GetAOIID(),
+			Pos:    obj.GetCoordPos(),
+			Global: t.global.Existed(obj.GetAOIID()),
+			Groups: append([]int(nil), cacheObj.groups...),
+		}
+		if w, ok := obj.(aoi.IWatcher); ok {
+			record.Watcher = true
+			record.Visual = w.GetVisual()
+		}
+		records = append(records, record)
+	}
+
+	t.Begin()
+	defer t.Commit()
+
+	for _, obj := range objs {
+		if err := t.RemoveFromAOI(obj); err != nil {
+			return records, err
+		}
+	}
+
+	return records, nil
+}
+
+// ImportRegion 按ExtractRegion的记录加入对象, resolve根据ID返回对象, 对象的位置以对象自己为准
+// 加入前检查所有记录, 加入过程中出错时撤销已经做的修改, 有错误时AOI保持不变, watcher也不会收到通知.
+// 源群组在这里重新创建, 返回源群组ID到新群组ID的映射. 所有加入在一次批量操作中完成, 每个watcher最多收到一次进入
+func (t *TowerAOI) ImportRegion(records []RegionRecord, resolve func(id string) aoi.IObject) (map[int]int, error) {
+	objs := make([]aoi.IObject, 0, len(records))
+	imported := make(map[string]bool)
+	for _, record := range records {
+		obj := resolve(record.ID)
+		if obj == nil || obj.GetAOIID() != record.ID {
+			return nil, aoi.ErrObjectInvalid
+		}
+		if _, isWatcher := obj.(aoi.IWatcher); isWatcher != record.Watcher {
+			return nil, aoi.ErrObjectInvalid
+		}
+		if _, ok := t.objs[record.ID]; ok || imported[record.ID] {
+			return nil, aoi.ErrObjectExisted
+		}
+		if !t.isInvalid(obj.GetCoordPos()) {
+			return nil, aoi.ErrPosInvalid
+		}
+		// 同一个对象在一个群组中只能出现一次
+		groups := make(map[int]bool, len(record.Groups))
+		for _, groupID := range record.Groups {
+			if groups[groupID] {
+				return nil, aoi.ErrObjectInvalid
+			}
+			groups[groupID] = true
+		}
+
+		imported[record.ID] = true
+		objs = append(objs, obj)
+	}
+
+	t.Begin()
+	defer t.Commit()
+
+	var added []aoi.IObject
+	groupIDs := make(map[int]int)
+	// rollback 在同一次批量操作中撤销, 进入又离开的对象不会通知watcher
+	rollback := func(err error) (map[int]int, error) {
+		for _, groupID := range groupIDs {
+			t.DestroyGroup(groupID)
+		}
+		for _, obj := range added {
+			t.RemoveFromAOI(obj)
+		}
+		return nil, err
+	}
+
+	members := make(map[int][]aoi.IObject)
+	for i, obj := range objs {
+		if err := t.AddToAOI(obj); err != nil {
+			return rollback(err)
+		}
+		added = append(added, obj)
+		if records[i].Global {
+			if err := t.AddGlobalMarker(obj); err != nil {
+				return rollback(err)
+			}
+		}
+		for _, groupID := range records[i].Groups {
+			members[groupID] = append(members[groupID], obj)
+		}
+	}
+
+	srcGroupIDs := make([]int, 0, len(members))
+	for groupID := range members {
+		srcGroupIDs = append(srcGroupIDs, groupID)
+	}
+	sort.Ints(srcGroupIDs)
+
+	for _, srcGroupID := range srcGroupIDs {
+		groupID, err := t.CreateGroup(members[srcGroupID])
+		if err != nil {
+			return rollback(err)
+		}
+		groupIDs[srcGroupID] = groupID
+	}
+
+	return groupIDs, nil
+}
@@ -0,0 +1,332 @@
+package toweraoi
+
+import (
+	"aoi"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+)
+
+// 快照格式版本, 格式变化时递增, 旧版本的快照需要兼容读取
+const snapshotVersion uint32 = 1
+
+var snapshotMagic = [4]byte{'T', 'A', 'O', 'I'}
+
+const (
+	snapshotFlagGlobal  = 1 << 0
+	snapshotFlagWatcher = 1 << 1
+)
+
+var (
+	ErrSnapshotInvalid = errors.New("snapshot invalid")
+	ErrSnapshotVersion = errors.New("snapshot version not supported")
+)
+
+// Snapshot 保存所有对象的灯塔位置, 全局标记, 群组以及每个watcher视野内的对象, 用于热重启
+// 格式: 头部(magic, 版本), 群组ID种子, 对象列表, 群组列表, 视野列表, 整数都是小端
+func (t *TowerAOI) Snapshot(w io.Writer) error {
+	e := &_Encoder{w: w}
+	e.write(snapshotMagic)
+	e.write(snapshotVersion)
+	e.write(int64(t.groupIDSeed))
+
+	ids := make([]string, 0, len(t.objs))
+	for id := range t.objs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	e.write(uint32(len(ids)))
+	for _, id := range ids {
+		cacheObj := t.objs[id]
+		var flags uint8
+		if t.global.Existed(id) {
+			flags |= snapshotFlagGlobal
+		}
+		if cacheObj.wrapWatcher != nil {
+			flags |= snapshotFlagWatcher
+		}
+
+		e.writeString(id)
+		e.write(flags)
+		e.write(int32(cacheObj.X))
+		e.write(int32(cacheObj.Y))
+		e.write(int32(cacheObj.towerVisual))
+	}
+
+	groupIDs := make([]int, 0, len(t.groups))
+	for groupID := range t.groups {
+		groupIDs = append(groupIDs, groupID)
+	}
+	sort.Ints(groupIDs)
+
+	e.write(uint32(len(groupIDs)))
+	for _, groupID := range groupIDs {
+		e.write(int64(groupID))
+		e.writeIDs(t.groups[groupID].GetObjs())
+	}
+
+	var watcherIDs []string
+	for _, id := range ids {
+		if t.objs[id].wrapWatcher != nil {
+			watcherIDs = append(watcherIDs, id)
+		}
+	}
+
+	e.write(uint32(len(watcherIDs)))
+	for _, id := range watcherIDs {
+		entered := make(map[string]aoi.IObject)
+		for infoID, info := range t.objs[id].wrapWatcher.info {
+			if info.entered {
+				entered[infoID] = info.obj
+			}
+		}
+
+		e.writeString(id)
+		e.writeIDs(entered)
+	}
+
+	return e.err
+}
+
+// Restore 从快照恢复到一个空的AOI, resolve根据ID返回对象, 对象的位置以快照中的灯塔为准
+// 快照中已经在视野内的对象不会再通知进入, 只通知快照之后发生的变化
+// watcher的视野必须和快照时一致, 否则返回ErrSnapshotInvalid
+// 重复的对象, 群组或者群组成员都视为损坏的快照, 返回ErrSnapshotInvalid
+// 返回错误时AOI处于部分恢复的状态, 应该丢弃
+func (t *TowerAOI) Restore(r io.Reader, resolve func(id string) aoi.IObject) error {
+	if len(t.objs) != 0 || len(t.groups) != 0 {
+		return aoi.ErrObjectExisted
+	}
+
+	d := &_Decoder{r: r}
+	var magic [4]byte
+	var version uint32
+	d.read(&magic)
+	d.read(&version)
+	if d.err != nil {
+		return d.err
+	}
+	if magic != snapshotMagic {
+		return ErrSnapshotInvalid
+	}
+	if version != snapshotVersion {
+		return ErrSnapshotVersion
+	}
+
+	var groupIDSeed int64
+	var objCount uint32
+	d.read(&groupIDSeed)
+	d.read(&objCount)
+	restored := make(map[string]aoi.IObject)
+	for i := uint32(0); i < objCount && d.err == nil; i++ {
+		var flags uint8
+		var x, y, towerVisual int32
+		id := d.readString()
+		d.read(&flags)
+		d.read(&x)
+		d.read(&y)
+		d.read(&towerVisual)
+		if d.err != nil {
+			break
+		}
+
+		obj := resolve(id)
+		if err := t.restoreObject(obj, id, flags, int(x), int(y), int(towerVisual)); err != nil {
+			return err
+		}
+		restored[id] = obj
+	}
+
+	var groupCount uint32
+	d.read(&groupCount)
+	for i := uint32(0); i < groupCount && d.err == nil; i++ {
+		var groupID int64
+		d.read(&groupID)
+		members := d.readIDs()
+		if d.err != nil {
+			break
+		}
+
+		if _, ok := t.groups[int(groupID)]; ok {
+			return ErrSnapshotInvalid
+		}
+
+		group := NewTower()
+		t.groups[int(groupID)] = group
+		for _, id := range members {
+			cacheObj, ok := t.objs[id]
+			if !ok || group.Existed(id) {
+				return ErrSnapshotInvalid
+			}
+
+			group.Add(restored[id])
+			cacheObj.groups = append(cacheObj.groups, int(groupID))
+			if cacheObj.wrapWatcher != nil {
+				group.AddWatcher(cacheObj.wrapWatcher)
+			}
+		}
+	}
+	t.groupIDSeed = int(groupIDSeed)
+
+	var watcherCount uint32
+	d.read(&watcherCount)
+	for i := uint32(0); i < watcherCount && d.err == nil; i++ {
+		id := d.readString()
+		entered := d.readIDs()
+		if d.err != nil {
+			break
+		}
+
+		cacheObj, ok := t.objs[id]
+		if !ok || cacheObj.wrapWatcher == nil {
+			return ErrSnapshotInvalid
+		}
+
+		// 标记为已经进入, Flush时只会通知差异; 快照中可见但现在不在灯塔内的对象会收到离开
+		ww := cacheObj.wrapWatcher
+		for _, infoID := range entered {
+			info, ok := ww.info[infoID]
+			if !ok {
+				obj := resolve(infoID)
+				if obj == nil {
+					continue
+				}
+				info = &_CacheInfo{obj: obj}
+				ww.info[infoID] = info
+			}
+			info.entered = true
+		}
+		t.notifyDirty(ww)
+	}
+
+	if d.err != nil {
+		return d.err
+	}
+
+	t.flushWatchers()
+
+	return nil
+}
+
+func (t *TowerAOI) restoreObject(obj aoi.IObject, id string, flags uint8, x, y, towerVisual int) error {
+	if obj == nil || obj.GetAOIID() != id {
+		return aoi.ErrObjectNotExisted
+	}
+
+	if _, ok := t.objs[id]; ok {
+		return ErrSnapshotInvalid
+	}
+
+	w, isWatcher := obj.(aoi.IWatcher)
+	if isWatcher != (flags&snapshotFlagWatcher != 0) {
+		return ErrSnapshotInvalid
+	}
+
+	if !t.sparse && t.towers.findTower(x, y) == nil {
+		return ErrSnapshotInvalid
+	}
+
+	// 灯塔圈数必须和对象当前的视野一致, 损坏的快照可能让watcher关注大量不存在的灯塔
+	expected := 0
+	if isWatcher {
+		expected = t.getWatcherTowerVisual(w)
+	}
+	if towerVisual != expected {
+		return ErrSnapshotInvalid
+	}
+
+	cacheObj := &_CacheObject{X: x, Y: y, towerVisual: towerVisual}
+	t.objs[id] = cacheObj
+	if flags&snapshotFlagGlobal != 0 {
+		t.global.Add(obj)
+	} else {
+		t.towers.getTower(x, y).Add(obj)
+	}
+
+	if isWatcher {
+		ww := t.newWrapWatcher(w)
+		cacheObj.wrapWatcher = ww
+		t.traversalTowerByVisual(x, y, towerVisual, func(towerX, towerY int, tower *Tower) {
+			tower.AddWatcher(ww)
+		})
+		t.global.AddWatcher(ww)
+	}
+
+	return nil
+}
+
+// _Encoder 记录第一个错误, 之后的写入全部忽略
+type _Encoder struct {
+	w   io.Writer
+	err error
+}
+
+func (e *_Encoder) write(v interface{}) {
+	if e.err == nil {
+		e.err = binary.Write(e.w, binary.LittleEndian, v)
+	}
+}
+
+func (e *_Encoder) writeString(s string) {
+	e.write(uint32(len(s)))
+	if e.err == nil {
+		_, e.err = io.WriteString(e.w, s)
+	}
+}
+
+// writeIDs 按ID排序写入, 保证同样的状态得到同样的快照
+func (e *_Encoder) writeIDs(objs map[string]aoi.IObject) {
+	ids := make([]string, 0, len(objs))
+	for id := range objs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	e.write(uint32(len(ids)))
+	for _, id := range ids {
+		e.writeString(id)
+	}
+}
+
+// 单个ID的最大长度, 防止损坏的快照申请过大的内存
+const maxSnapshotIDLen = 1 << 16
+
+type _Decoder struct {
+	r   io.Reader
+	err error
+}
+
+func (d *_Decoder) read(v interface{}) {
+	if d.err == nil {
+		d.err = binary.Read(d.r, binary.LittleEndian, v)
+	}
+}
+
+func (d *_Decoder) readString() string {
+	var n uint32
+	d.read(&n)
+	if d.err != nil {
+		return ""
+	}
+	if n > maxSnapshotIDLen {
+		d.err = ErrSnapshotInvalid
+		return ""
+	}
+
+	buf := make([]byte, n)
+	_, d.err = io.ReadFull(d.r, buf)
+	return string(buf)
+}
+
+func (d *_Decoder) readIDs() []string {
+	var n uint32
+	d.read(&n)
+	var ids []string
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		ids = append(ids, d.readString())
+	}
+
+	return ids
+}
@@ -3,8 +3,10 @@ package toweraoi
 import (
 	"aoi"
 	"aoi/base/linemath"
+	"aoi/metrics"
 	"errors"
 	"math"
+	"time"
 
 	log "github.com/cihub/seelog"
 )
@@ -12,8 +14,8 @@ import (
 // TowerAOI 基于灯塔的AOI实现
 type TowerAOI struct {
 	// 灯塔相关
-	towers         [][]*Tower
-	minPos, maxPos linemath.Vector2 // 地图边界
+	towers         towerGrid
+	minPos, maxPos linemath.Vector2 // 地图边界, 无边界模式下MinPos是灯塔坐标的原点
 	towerSize      float32
 	towerSizeX     int // 灯塔数量
 	towerSizeY     int // 灯塔数量
@@ -28,11 +30,35 @@ type TowerAOI struct {
 
 	// 缓存需要清理的watcher
 	dirtyWatchers map[string]*_WrapWatcher
+	batchDepth    int // 大于0时延迟到Commit再清理
+
+	// 精确视野, 灯塔只作为粗筛
+	exactVisual bool
+	leaveMargin float32 // 已经进入视野的对象, 距离超过视野+leaveMargin才离开
+
+	// 无边界模式, 灯塔按需创建, 空了就释放
+	sparse bool
+
+	// 可见性策略, 为空时不限制
+	canSee func(watcher aoi.IWatcher, obj aoi.IObject) bool
+
+	// 每个watcher最多可见的对象数量, 以及排名的得分
+	maxVisible int
+	score      func(watcher aoi.IWatcher, obj aoi.IObject) float32
+
+	// 按距离分档的同步频率
+	bands []Band
+
+	metrics *metrics.Collector
+
+	// 每次操作后检查内部状态
+	debug bool
 }
 
 type _CacheObject struct {
 	X, Y        int
 	wrapWatcher *_WrapWatcher
+	towerVisual int // 当前关注的灯塔圈数, 小于0表示没有关注灯塔, 视野变化后要按这个取消关注
 	groups      []int
 }
 
@@ -44,30 +70,84 @@ type Config struct {
 	MinPos    linemath.Vector2
 	MaxPos    linemath.Vector2
 	TowerSize float32
+
+	// ExactVisual 开启后灯塔只用于粗筛, 进出视野由与watcher的实际距离决定,
+	// 双方移动(包括同一灯塔内移动)都会重新判断
+	ExactVisual bool
+
+	// LeaveMargin 进出视野的滞后距离, 对象在视野内进入, 超过视野+LeaveMargin才离开,
+	// 避免在视野边缘来回移动时反复进出. 需要开启ExactVisual
+	LeaveMargin float32
+
+	// Sparse 开启后不限制地图边界, 忽略MaxPos, 灯塔按需创建, 没有对象和watcher时释放
+	Sparse bool
+
+	// CanSee 可见性策略(隐身, 阵营, 权限等), 返回false时即使在视野内也不可见,
+	// 对全局对象和群组同样生效. 策略依赖的状态变化后调用Refresh重新判断
+	CanSee func(watcher aoi.IWatcher, obj aoi.IObject) bool
+
+	// MaxVisible 大于0时每个watcher最多看到MaxVisible个对象(包括自己), 按Score从高到低选择,
+	// 对象移动后重新排名. Score为空时按距离, 越近得分越高
+	MaxVisible int
+	Score      func(watcher aoi.IWatcher, obj aoi.IObject) float32
+
+	// Bands 按距离从近到远分档, 决定CollectUpdates中可见对象的同步频率, 超过最远一档的按最远一档
+	Bands []Band
+
+	// Metrics 不为空时记录操作次数, 耗时, 通知数量, 并定期采样灯塔负载
+	Metrics *metrics.Collector
+
+	// Debug 每次操作后调用Validate, 发现错误时记录日志. 开销很大, 只用于调试
+	Debug bool
+}
+
+// Band 距离不超过Distance的对象每Interval次CollectUpdates同步一次
+type Band struct {
+	Distance float32
+	Interval int
 }
 
 func New(cfg *Config) (aoi.IAOI, error) {
-	if cfg.MinPos.X > cfg.MaxPos.X || cfg.MinPos.Y > cfg.MaxPos.Y {
+	if cfg.TowerSize <= 0 || (!cfg.Sparse && (cfg.MinPos.X > cfg.MaxPos.X || cfg.MinPos.Y > cfg.MaxPos.Y)) {
+		return nil, ErrTowerConfigInvalid
+	}
+
+	if cfg.LeaveMargin < 0 || (cfg.LeaveMargin > 0 && !cfg.ExactVisual) {
 		return nil, ErrTowerConfigInvalid
 	}
 
+	for i, band := range cfg.Bands {
+		if band.Interval < 1 || (i > 0 && band.Distance <= cfg.Bands[i-1].Distance) {
+			return nil, ErrTowerConfigInvalid
+		}
+	}
+
 	ta := &TowerAOI{
 		minPos:        cfg.MinPos,
 		maxPos:        cfg.MaxPos,
 		towerSize:     cfg.TowerSize,
 		objs:          make(map[string]*_CacheObject),
 		dirtyWatchers: make(map[string]*_WrapWatcher),
-	}
-
-	ta.towerSizeX = int(math.Ceil(float64((cfg.MaxPos.X - cfg.MinPos.X) / cfg.TowerSize)))
-	ta.towerSizeY = int(math.Ceil(float64((cfg.MaxPos.Y - cfg.MinPos.Y) / cfg.TowerSize)))
-
-	ta.towers = make([][]*Tower, ta.towerSizeX)
-	for x := 0; x < ta.towerSizeX; x++ {
-		ta.towers[x] = make([]*Tower, ta.towerSizeY)
-		for y := 0; y < ta.towerSizeY; y++ {
-			ta.towers[x][y] = NewTower()
-		}
+		exactVisual:   cfg.ExactVisual,
+		leaveMargin:   cfg.LeaveMargin,
+		sparse:        cfg.Sparse,
+		canSee:        cfg.CanSee,
+		maxVisible:    cfg.MaxVisible,
+		score:         cfg.Score,
+		bands:         cfg.Bands,
+		metrics:       cfg.Metrics,
+		debug:         cfg.Debug,
+	}
+	if ta.score == nil {
+		ta.score = distanceScore
+	}
+
+	if cfg.Sparse {
+		ta.towers = newMapTowerGrid()
+	} else {
+		ta.towerSizeX = int(math.Ceil(float64((cfg.MaxPos.X - cfg.MinPos.X) / cfg.TowerSize)))
+		ta.towerSizeY = int(math.Ceil(float64((cfg.MaxPos.Y - cfg.MinPos.Y) / cfg.TowerSize)))
+		ta.towers = newArrayTowerGrid(ta.towerSizeX, ta.towerSizeY)
 	}
 
 	ta.global = NewTower()
@@ -77,6 +157,8 @@ func New(cfg *Config) (aoi.IAOI, error) {
 }
 
 func (t *TowerAOI) AddToAOI(obj aoi.IObject) error {
+	defer t.done(metrics.OpAdd, t.metrics.Start())
+
 	if obj == nil {
 		return aoi.ErrObjectInvalid
 	}
@@ -91,21 +173,20 @@ func (t *TowerAOI) AddToAOI(obj aoi.IObject) error {
 	}
 
 	x, y := t.transPos(pos)
-	t.towers[x][y].Add(obj)
+	t.towers.getTower(x, y).Add(obj)
 	t.objs[obj.GetAOIID()] = &_CacheObject{
 		X: x,
 		Y: y,
 	}
 
 	if w, ok := obj.(aoi.IWatcher); ok {
-		ww := newWrapWatcher(w, t.notifyDirty)
+		ww := t.newWrapWatcher(w)
 		t.objs[obj.GetAOIID()].wrapWatcher = ww
-		if visual := w.GetVisual(); visual > 0 {
-			towerVisual := int(math.Ceil(float64(visual/t.towerSize))) - 1
-			t.traversalTowerByVisual(x, y, towerVisual, func(towerX, towerY int, tower *Tower) {
-				tower.AddWatcher(ww)
-			})
-		}
+		towerVisual := t.getWatcherTowerVisual(w)
+		t.objs[obj.GetAOIID()].towerVisual = towerVisual
+		t.traversalTowerByVisual(x, y, towerVisual, func(towerX, towerY int, tower *Tower) {
+			tower.AddWatcher(ww)
+		})
 
 		t.global.AddWatcher(ww)
 	}
@@ -116,6 +197,8 @@ func (t *TowerAOI) AddToAOI(obj aoi.IObject) error {
 }
 
 func (t *TowerAOI) RemoveFromAOI(obj aoi.IObject) error {
+	defer t.done(metrics.OpRemove, t.metrics.Start())
+
 	if obj == nil {
 		return aoi.ErrObjectInvalid
 	}
@@ -147,20 +230,19 @@ func (t *TowerAOI) RemoveFromAOI(obj aoi.IObject) error {
 	if t.global.Existed(obj.GetAOIID()) {
 		// 如果是全局object, 从全局系统中移除
 		t.global.Remove(obj)
-		if cacheObj.wrapWatcher != nil {
-			t.global.RemoveWatcher(cacheObj.wrapWatcher)
-		}
 	} else {
 		// 从灯塔系统中移除
-		t.towers[cacheObj.X][cacheObj.Y].Remove(obj)
-		if cacheObj.wrapWatcher != nil {
-			if visual := cacheObj.wrapWatcher.GetVisual(); visual > 0 {
-				towerVisual := int(math.Ceil(float64(visual/t.towerSize))) - 1
-				t.traversalTowerByVisual(cacheObj.X, cacheObj.Y, towerVisual, func(towerX, towerY int, tower *Tower) {
-					tower.RemoveWatcher(cacheObj.wrapWatcher)
-				})
-			}
-		}
+		t.towers.getTower(cacheObj.X, cacheObj.Y).Remove(obj)
+		t.towers.release(cacheObj.X, cacheObj.Y)
+	}
+
+	// 全局watcher同样关注着灯塔, 按加入时的视野取消关注
+	if cacheObj.wrapWatcher != nil {
+		t.global.RemoveWatcher(cacheObj.wrapWatcher)
+		t.traversalTowerByVisual(cacheObj.X, cacheObj.Y, cacheObj.towerVisual, func(towerX, towerY int, tower *Tower) {
+			tower.RemoveWatcher(cacheObj.wrapWatcher)
+			t.towers.release(towerX, towerY)
+		})
 	}
 
 	t.flushWatchers()
@@ -169,6 +251,8 @@ func (t *TowerAOI) RemoveFromAOI(obj aoi.IObject) error {
 }
 
 func (t *TowerAOI) Move(obj aoi.IObject) error {
+	defer t.done(metrics.OpMove, t.metrics.Start())
+
 	if obj == nil {
 		return aoi.ErrObjectInvalid
 	}
@@ -185,30 +269,43 @@ func (t *TowerAOI) Move(obj aoi.IObject) error {
 	}
 	newX, newY := t.transPos(pos)
 	if oldX == newX && oldY == newY {
+		if t.trackMoves() {
+			// 全局对象对其他watcher总是可见, 只需要重新判断它自己的视野
+			if !t.global.Existed(obj.GetAOIID()) {
+				t.markMoved(cacheObj)
+			} else if cacheObj.wrapWatcher != nil {
+				t.notifyDirty(cacheObj.wrapWatcher)
+			}
+			t.flushWatchers()
+		}
 		return nil
 	}
 	cacheObj.X = newX
 	cacheObj.Y = newY
 
-	if t.global.Existed(obj.GetAOIID()) {
-		return nil
+	global := t.global.Existed(obj.GetAOIID())
+	if !global {
+		oldTower := t.towers.getTower(oldX, oldY)
+		newTower := t.towers.getTower(newX, newY)
+		oldTower.Remove(obj)
+		newTower.Add(obj)
 	}
 
-	oldTower := t.towers[oldX][oldY]
-	newTower := t.towers[newX][newY]
-	oldTower.Remove(obj)
-	newTower.Add(obj)
-
 	if cacheObj.wrapWatcher != nil {
-		if visual := cacheObj.wrapWatcher.GetVisual(); visual > 0 {
-			towerVisual := int(math.Ceil(float64(visual/t.towerSize))) - 1
-			t.traversalTowerByVisual(oldX, oldY, towerVisual, func(towerX, towerY int, tower *Tower) {
-				tower.RemoveWatcher(cacheObj.wrapWatcher)
-			})
-			t.traversalTowerByVisual(newX, newY, towerVisual, func(towerX, towerY int, tower *Tower) {
-				tower.AddWatcher(cacheObj.wrapWatcher)
-			})
+		t.moveWatcher(cacheObj, oldX, oldY)
+	}
+	t.towers.release(oldX, oldY)
+
+	if global {
+		if t.trackMoves() && cacheObj.wrapWatcher != nil {
+			t.notifyDirty(cacheObj.wrapWatcher)
 		}
+		t.flushWatchers()
+		return nil
+	}
+
+	if t.trackMoves() {
+		t.markMoved(cacheObj)
 	}
 
 	t.flushWatchers()
@@ -216,7 +313,101 @@ func (t *TowerAOI) Move(obj aoi.IObject) error {
 	return nil
 }
 
+// UpdateVisual watcher的视野变化后调用, 按新旧视野的差异增减关注的灯塔, 通知进入和离开
+func (t *TowerAOI) UpdateVisual(watcher aoi.IWatcher) error {
+	if watcher == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := t.objs[watcher.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	ww := cacheObj.wrapWatcher
+	if ww == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	oldVisual, newVisual := cacheObj.towerVisual, t.getWatcherTowerVisual(ww)
+	cacheObj.towerVisual = newVisual
+	if newVisual > oldVisual {
+		t.traversalTowerByVisual(cacheObj.X, cacheObj.Y, newVisual, func(towerX, towerY int, tower *Tower) {
+			if !inTowerRange(cacheObj.X, cacheObj.Y, oldVisual, towerX, towerY) {
+				tower.AddWatcher(ww)
+			}
+		})
+	} else if newVisual < oldVisual {
+		t.traversalTowerByVisual(cacheObj.X, cacheObj.Y, oldVisual, func(towerX, towerY int, tower *Tower) {
+			if !inTowerRange(cacheObj.X, cacheObj.Y, newVisual, towerX, towerY) {
+				tower.RemoveWatcher(ww)
+				t.towers.release(towerX, towerY)
+			}
+		})
+	}
+
+	// 精确视野下灯塔不变时距离也可能跨过边界, 限制数量时排名也可能变化
+	if t.trackMoves() {
+		t.notifyDirty(ww)
+	}
+
+	t.flushWatchers()
+
+	return nil
+}
+
+// CollectUpdates 每个tick对每个watcher调用一次, 返回视野内到了同步时间的对象
+// 对象按与watcher的距离所在的档位决定同步间隔, 刚进入视野的对象从进入时开始计时
+func (t *TowerAOI) CollectUpdates(watcher aoi.IWatcher) ([]aoi.IObject, error) {
+	if watcher == nil {
+		return nil, aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := t.objs[watcher.GetAOIID()]
+	if !ok {
+		return nil, aoi.ErrObjectNotExisted
+	}
+
+	ww := cacheObj.wrapWatcher
+	if ww == nil {
+		return nil, aoi.ErrObjectInvalid
+	}
+
+	ww.tick++
+	var objs []aoi.IObject
+	for _, info := range ww.info {
+		if !info.entered {
+			continue
+		}
+
+		interval := t.getSyncInterval(ww, info.obj)
+		if ww.tick-info.syncTick >= interval {
+			info.syncTick = ww.tick
+			objs = append(objs, info.obj)
+		}
+	}
+
+	return objs, nil
+}
+
+func (t *TowerAOI) getSyncInterval(ww *_WrapWatcher, obj aoi.IObject) int {
+	if len(t.bands) == 0 {
+		return 1
+	}
+
+	dist := ww.GetCoordPos().Sub(obj.GetCoordPos()).Len()
+	for _, band := range t.bands {
+		if dist <= band.Distance {
+			return band.Interval
+		}
+	}
+
+	return t.bands[len(t.bands)-1].Interval
+}
+
 func (t *TowerAOI) AddGlobalMarker(obj aoi.IObject) error {
+	defer t.done(metrics.OpAddGlobal, t.metrics.Start())
+
 	if obj == nil {
 		return aoi.ErrObjectInvalid
 	}
@@ -230,7 +421,8 @@ func (t *TowerAOI) AddGlobalMarker(obj aoi.IObject) error {
 		return aoi.ErrObjectExisted
 	}
 
-	t.towers[cacheObj.X][cacheObj.Y].Remove(obj)
+	t.towers.getTower(cacheObj.X, cacheObj.Y).Remove(obj)
+	t.towers.release(cacheObj.X, cacheObj.Y)
 
 	t.global.Add(obj)
 
@@ -240,6 +432,8 @@ func (t *TowerAOI) AddGlobalMarker(obj aoi.IObject) error {
 }
 
 func (t *TowerAOI) RemoveGlobalMarker(obj aoi.IObject) error {
+	defer t.done(metrics.OpRemoveGlobal, t.metrics.Start())
+
 	if obj == nil {
 		return aoi.ErrObjectInvalid
 	}
@@ -255,16 +449,34 @@ func (t *TowerAOI) RemoveGlobalMarker(obj aoi.IObject) error {
 
 	t.global.Remove(obj)
 
-	x, y := t.transPos(pos)
-	t.towers[x][y].Add(obj)
-	t.objs[obj.GetAOIID()].X = x
-	t.objs[obj.GetAOIID()].Y = y
+	// 全局watcher一直关注着原来位置的灯塔, 回到新位置时一起移动
+	cacheObj := t.objs[obj.GetAOIID()]
+	oldX, oldY := cacheObj.X, cacheObj.Y
+	cacheObj.X, cacheObj.Y = t.transPos(pos)
+	t.towers.getTower(cacheObj.X, cacheObj.Y).Add(obj)
+	if cacheObj.wrapWatcher != nil && (oldX != cacheObj.X || oldY != cacheObj.Y) {
+		t.moveWatcher(cacheObj, oldX, oldY)
+	}
 
 	t.flushWatchers()
 
 	return nil
 }
 
+// moveWatcher watcher从旧位置关注的灯塔移动到新位置(cacheObj.X/Y)的灯塔
+// 视野可能已经变化但还没有调用UpdateVisual, 按当前关注的圈数移动
+func (t *TowerAOI) moveWatcher(cacheObj *_CacheObject, oldX, oldY int) {
+	t.traversalTowerByVisual(oldX, oldY, cacheObj.towerVisual, func(towerX, towerY int, tower *Tower) {
+		tower.RemoveWatcher(cacheObj.wrapWatcher)
+	})
+	t.traversalTowerByVisual(cacheObj.X, cacheObj.Y, cacheObj.towerVisual, func(towerX, towerY int, tower *Tower) {
+		tower.AddWatcher(cacheObj.wrapWatcher)
+	})
+	t.traversalTowerByVisual(oldX, oldY, cacheObj.towerVisual, func(towerX, towerY int, tower *Tower) {
+		t.towers.release(towerX, towerY)
+	})
+}
+
 func (t *TowerAOI) Traversal(obj aoi.IObject, cb func(obj aoi.IWatcher) bool) {
 	if obj == nil {
 		return
@@ -275,6 +487,8 @@ func (t *TowerAOI) Traversal(obj aoi.IObject, cb func(obj aoi.IWatcher) bool) {
 		return
 	}
 
+	cb = t.filterTraversal(obj, cb)
+
 	// 全局对象, 直接遍历所有的watcher就可以
 	if t.global.Existed(obj.GetAOIID()) {
 		t.global.Traversal(obj, cb)
@@ -292,7 +506,7 @@ func (t *TowerAOI) Traversal(obj aoi.IObject, cb func(obj aoi.IWatcher) bool) {
 		})
 	}
 
-	tower := t.towers[cacheObject.X][cacheObject.Y]
+	tower := t.towers.getTower(cacheObject.X, cacheObject.Y)
 	for _, w := range tower.GetWatchers() {
 		if _, called := calledWatchers[w.GetAOIID()]; !called {
 			if !cb(w) {
@@ -304,6 +518,8 @@ func (t *TowerAOI) Traversal(obj aoi.IObject, cb func(obj aoi.IWatcher) bool) {
 }
 
 func (t *TowerAOI) CreateGroup(objs []aoi.IObject) (int, error) {
+	defer t.done(metrics.OpCreateGroup, t.metrics.Start())
+
 	if len(objs) == 0 {
 		return 0, aoi.ErrObjectInvalid
 	}
@@ -320,7 +536,10 @@ func (t *TowerAOI) CreateGroup(objs []aoi.IObject) (int, error) {
 	t.groupIDSeed++
 	t.groups[t.groupIDSeed] = group
 	for _, o := range objs {
-		group.Add(o)
+		// 重复的对象只加入一次
+		if group.Add(o) != nil {
+			continue
+		}
 
 		cacheObj := t.objs[o.GetAOIID()]
 		if cacheObj.groups == nil {
@@ -339,6 +558,8 @@ func (t *TowerAOI) CreateGroup(objs []aoi.IObject) (int, error) {
 }
 
 func (t *TowerAOI) DestroyGroup(groupID int) error {
+	defer t.done(metrics.OpDestroyGroup, t.metrics.Start())
+
 	group, ok := t.groups[groupID]
 	if !ok {
 		return aoi.ErrGroupNotExisted
@@ -349,6 +570,7 @@ func (t *TowerAOI) DestroyGroup(groupID int) error {
 		for i, v := range cacheObj.groups {
 			if v == groupID {
 				cacheObj.groups = append(cacheObj.groups[:i], cacheObj.groups[i+1:]...)
+				break
 			}
 		}
 	}
@@ -362,6 +584,8 @@ func (t *TowerAOI) DestroyGroup(groupID int) error {
 }
 
 func (t *TowerAOI) AddToGroup(obj aoi.IObject, groupID int) error {
+	defer t.done(metrics.OpAddToGroup, t.metrics.Start())
+
 	if obj == nil {
 		return aoi.ErrObjectInvalid
 	}
@@ -393,6 +617,8 @@ func (t *TowerAOI) AddToGroup(obj aoi.IObject, groupID int) error {
 }
 
 func (t *TowerAOI) RemoveFromGroup(obj aoi.IObject, groupID int) error {
+	defer t.done(metrics.OpRemoveFromGroup, t.metrics.Start())
+
 	if obj == nil {
 		return aoi.ErrObjectInvalid
 	}
@@ -419,6 +645,7 @@ func (t *TowerAOI) RemoveFromGroup(obj aoi.IObject, groupID int) error {
 	for i := range cacheObj.groups {
 		if cacheObj.groups[i] == groupID {
 			cacheObj.groups = append(cacheObj.groups[:i], cacheObj.groups[i+1:]...)
+			break
 		}
 	}
 
@@ -445,10 +672,74 @@ func (t *TowerAOI) TraversalGroup(obj aoi.IObject, groupID int, cb func(watcher
 		return
 	}
 
-	group.Traversal(obj, cb)
+	group.Traversal(obj, t.filterTraversal(obj, cb))
+}
+
+// Refresh 对象的可见性策略依赖的状态变化后调用, 重新判断能看到它的watcher, 以及它(如果是watcher)能看到的对象
+func (t *TowerAOI) Refresh(obj aoi.IObject) error {
+	if obj == nil {
+		return aoi.ErrObjectInvalid
+	}
+
+	cacheObj, ok := t.objs[obj.GetAOIID()]
+	if !ok {
+		return aoi.ErrObjectNotExisted
+	}
+
+	if t.global.Existed(obj.GetAOIID()) {
+		t.markWatchersDirty(t.global)
+	} else {
+		t.markWatchersDirty(t.towers.getTower(cacheObj.X, cacheObj.Y))
+	}
+
+	for _, groupID := range cacheObj.groups {
+		if group, ok := t.groups[groupID]; ok {
+			t.markWatchersDirty(group)
+		}
+	}
+
+	if cacheObj.wrapWatcher != nil {
+		t.notifyDirty(cacheObj.wrapWatcher)
+	}
+
+	t.flushWatchers()
+
+	return nil
+}
+
+func (t *TowerAOI) markWatchersDirty(tower *Tower) {
+	for _, w := range tower.GetWatchers() {
+		if ww, ok := w.(*_WrapWatcher); ok {
+			t.notifyDirty(ww)
+		}
+	}
+}
+
+// filterTraversal 遍历watcher时跳过可见性策略不允许看到obj的watcher
+func (t *TowerAOI) filterTraversal(obj aoi.IObject, cb func(watcher aoi.IWatcher) bool) func(watcher aoi.IWatcher) bool {
+	if t.canSee == nil {
+		return cb
+	}
+
+	return func(watcher aoi.IWatcher) bool {
+		w := watcher
+		if ww, ok := watcher.(*_WrapWatcher); ok {
+			w = ww.IWatcher
+		}
+		if !t.canSee(w, obj) {
+			return true
+		}
+		return cb(watcher)
+	}
 }
 
 func (t *TowerAOI) isInvalid(pos linemath.Vector2) bool {
+	// 无边界模式下灯塔坐标必须在int32范围内, 和快照的格式一致, NaN和Inf也不在范围内
+	if t.sparse {
+		x, y := t.towerIndex(pos)
+		return x >= math.MinInt32 && x <= math.MaxInt32 && y >= math.MinInt32 && y <= math.MaxInt32
+	}
+
 	if pos.X < t.minPos.X || pos.X > t.maxPos.X || pos.Y < t.minPos.Y || pos.Y > t.maxPos.Y {
 		return false
 	}
@@ -456,22 +747,84 @@ func (t *TowerAOI) isInvalid(pos linemath.Vector2) bool {
 	return true
 }
 
+// transPos 无边界模式下查询的范围可能超出int32, 取边界的灯塔
 func (t *TowerAOI) transPos(pos linemath.Vector2) (int, int) {
+	fx, fy := t.towerIndex(pos)
+	if t.sparse {
+		return int(clampInt32(fx)), int(clampInt32(fy))
+	}
+
+	x, y := int(fx), int(fy)
+	// 正好在最大边界上的对象归到最后一个灯塔
+	if x > t.towerSizeX-1 {
+		x = t.towerSizeX - 1
+	}
+	if y > t.towerSizeY-1 {
+		y = t.towerSizeY - 1
+	}
+
+	return x, y
+}
+
+func (t *TowerAOI) towerIndex(pos linemath.Vector2) (float64, float64) {
 	x := math.Floor(float64((pos.X - t.minPos.X) / t.towerSize))
 	y := math.Floor(float64((pos.Y - t.minPos.Y) / t.towerSize))
-	return int(x), int(y)
+	return x, y
+}
+
+// clampInt32 NaN按下边界处理
+func clampInt32(v float64) float64 {
+	if !(v >= math.MinInt32) {
+		return math.MinInt32
+	}
+	if v > math.MaxInt32 {
+		return math.MaxInt32
+	}
+
+	return v
+}
+
+// getTowerVisual 视野对应的灯塔圈数, 精确视野下需要完整覆盖视野圆, 包括离开的滞后距离
+func (t *TowerAOI) getTowerVisual(visual float32) int {
+	visual += t.leaveMargin
+	towerVisual := int(math.Ceil(float64(visual / t.towerSize)))
+	if !t.exactVisual {
+		towerVisual--
+	}
+
+	return towerVisual
 }
 
+// getWatcherTowerVisual watcher当前视野对应的灯塔圈数, 视野不大于0时返回-1, 不关注任何灯塔
+func (t *TowerAOI) getWatcherTowerVisual(w aoi.IWatcher) int {
+	visual := w.GetVisual()
+	if visual <= 0 {
+		return -1
+	}
+
+	return t.getTowerVisual(visual)
+}
+
+// traversalTowerByVisual visual小于0时不遍历
 func (t *TowerAOI) traversalTowerByVisual(x, y, visual int, cb func(towerX, towerY int, tower *Tower)) {
+	if visual < 0 {
+		return
+	}
+
 	startX, endX, startY, endY := t.getTowerRange(x, y, visual)
 	for i := startX; i <= endX; i++ {
 		for j := startY; j <= endY; j++ {
-			cb(i, j, t.towers[i][j])
+			cb(i, j, t.towers.getTower(i, j))
 		}
 	}
 }
 
+// getTowerRange 无边界模式下灯塔坐标限制在int32范围内, 和快照的格式一致
 func (t *TowerAOI) getTowerRange(x, y, i int) (int, int, int, int) {
+	if t.sparse {
+		return clampSparse(x, -i), clampSparse(x, i), clampSparse(y, -i), clampSparse(y, i)
+	}
+
 	startX := x - i
 	if startX < 0 {
 		startX = 0
@@ -492,13 +845,165 @@ func (t *TowerAOI) getTowerRange(x, y, i int) (int, int, int, int) {
 	return startX, endX, startY, endY
 }
 
+// clampSparse 返回x+d, 超出int32范围时取边界, 不会溢出
+func clampSparse(x, d int) int {
+	if d < 0 && x < math.MinInt32-d {
+		return math.MinInt32
+	}
+	if d > 0 && x > math.MaxInt32-d {
+		return math.MaxInt32
+	}
+
+	v := x + d
+	if v < math.MinInt32 {
+		return math.MinInt32
+	}
+	if v > math.MaxInt32 {
+		return math.MaxInt32
+	}
+
+	return v
+}
+
+// inTowerRange 灯塔(towerX, towerY)是否在以(x, y)为中心visual圈的范围内
+func inTowerRange(x, y, visual, towerX, towerY int) bool {
+	return towerX >= x-visual && towerX <= x+visual && towerY >= y-visual && towerY <= y+visual
+}
+
+// newWrapWatcher 按配置设置过滤和数量限制
+func (t *TowerAOI) newWrapWatcher(w aoi.IWatcher) *_WrapWatcher {
+	ww := newWrapWatcher(w, t.notifyDirty)
+	if t.exactVisual || t.canSee != nil {
+		ww.filter = t.isVisible
+	}
+	if t.maxVisible > 0 {
+		ww.maxVisible = t.maxVisible
+		ww.score = t.scoreInfo
+	}
+
+	return ww
+}
+
+// trackMoves 同一个灯塔内移动也需要重新判断, 精确视野和限制数量时都依赖实际距离
+func (t *TowerAOI) trackMoves() bool {
+	return t.exactVisual || t.maxVisible > 0
+}
+
+func (t *TowerAOI) scoreInfo(ww *_WrapWatcher, info *_CacheInfo) float32 {
+	return t.score(ww.IWatcher, info.obj)
+}
+
+// distanceScore 默认得分, 越近越高
+func distanceScore(watcher aoi.IWatcher, obj aoi.IObject) float32 {
+	return -watcher.GetCoordPos().Sub(obj.GetCoordPos()).Len()
+}
+
+// isVisible 灯塔粗筛之后的过滤, 先判断可见性策略, 再判断精确视野
+func (t *TowerAOI) isVisible(ww *_WrapWatcher, info *_CacheInfo) bool {
+	if t.canSee != nil && !t.canSee(ww.IWatcher, info.obj) {
+		return false
+	}
+
+	return !t.exactVisual || t.inVisual(ww, info)
+}
+
+// inVisual 精确视野过滤, 全局对象和同群组的对象不受距离限制
+func (t *TowerAOI) inVisual(ww *_WrapWatcher, info *_CacheInfo) bool {
+	id := info.obj.GetAOIID()
+	if t.global.Existed(id) || t.inSameGroup(ww.GetAOIID(), id) {
+		return true
+	}
+
+	// 已经进入视野的对象按离开半径判断
+	dist := ww.GetCoordPos().Sub(info.obj.GetCoordPos()).Len()
+	if info.entered {
+		return dist <= ww.GetVisual()+t.leaveMargin
+	}
+
+	return dist <= ww.GetVisual()
+}
+
+func (t *TowerAOI) inSameGroup(id1, id2 string) bool {
+	obj1, ok1 := t.objs[id1]
+	obj2, ok2 := t.objs[id2]
+	if !ok1 || !ok2 {
+		return false
+	}
+
+	for _, g1 := range obj1.groups {
+		for _, g2 := range obj2.groups {
+			if g1 == g2 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// markMoved 对象移动后, 关注它的watcher以及它自己(如果是watcher)需要重新判断距离
+func (t *TowerAOI) markMoved(cacheObj *_CacheObject) {
+	for _, w := range t.towers.getTower(cacheObj.X, cacheObj.Y).GetWatchers() {
+		if ww, ok := w.(*_WrapWatcher); ok {
+			t.notifyDirty(ww)
+		}
+	}
+
+	if cacheObj.wrapWatcher != nil {
+		t.notifyDirty(cacheObj.wrapWatcher)
+	}
+}
+
+// done 操作结束时记录指标, 定期采样灯塔负载
+func (t *TowerAOI) done(op metrics.Op, start time.Time) {
+	if t.metrics == nil {
+		return
+	}
+
+	t.metrics.Done(op, start)
+	if t.metrics.ShouldSample() {
+		t.metrics.SampleTowers(func(observe func(objs int)) {
+			t.towers.traversal(func(x, y int, tower *Tower) {
+				observe(tower.GetObjsLen())
+			})
+		})
+	}
+}
+
+// Begin 开始批量操作, 之后的操作不再立即通知watcher, 可以嵌套
+func (t *TowerAOI) Begin() {
+	t.batchDepth++
+}
+
+// Commit 结束批量操作, 最外层的Commit统一通知, 每个watcher最多收到一次进入和一次离开
+func (t *TowerAOI) Commit() {
+	if t.batchDepth == 0 {
+		return
+	}
+
+	t.batchDepth--
+	t.flushWatchers()
+}
+
 func (t *TowerAOI) notifyDirty(ww *_WrapWatcher) {
+	// 批量操作中同一个ID的watcher被移除后又加入, 旧的需要先清理
+	if old, ok := t.dirtyWatchers[ww.GetAOIID()]; ok && old != ww {
+		t.metrics.Flush(old.Flush())
+	}
 	t.dirtyWatchers[ww.GetAOIID()] = ww
 }
 
 func (t *TowerAOI) flushWatchers() {
+	if t.batchDepth > 0 {
+		return
+	}
+
 	for id, w := range t.dirtyWatchers {
-		w.Flush()
+		t.metrics.Flush(w.Flush())
 		delete(t.dirtyWatchers, id)
 	}
+
+	if t.debug {
+		t.debugValidate()
+	}
 }
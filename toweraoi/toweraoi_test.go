@@ -2,8 +2,14 @@ package toweraoi
 
 import (
 	"aoi"
+	"aoi/aoitest"
 	"aoi/base/linemath"
+	"aoi/metrics"
+	"bytes"
+	"encoding/json"
+	"expvar"
 	"fmt"
+	"math"
 	"math/rand"
 	"testing"
 )
@@ -26,13 +32,13 @@ func TestTowerAOI_AddRemove(t *testing.T) {
 
 	o2 := &aoi.TestWatcher{ID: "obj:2", Visual: 2, Pos: linemath.Vector2{X: -3.5, Y: -3.5}}
 	t.Log("Obj2 Add")
-	ta.Add(o2)
+	ta.AddToAOI(o2)
 
 	t.Log("Obj1 Remove")
-	ta.Remove(o1)
+	ta.RemoveFromAOI(o1)
 
 	t.Log("Obj2 Remove")
-	ta.Remove(o2)
+	ta.RemoveFromAOI(o2)
 }
 
 func TestTowerAOI_Global(t *testing.T) {
@@ -49,11 +55,11 @@ func TestTowerAOI_Global(t *testing.T) {
 
 	o1 := &aoi.TestWatcher{ID: "obj:1", Visual: 2}
 	t.Log("Obj1 Add", o1.Pos)
-	ta.Add(o1)
+	ta.AddToAOI(o1)
 
 	global := &aoi.TestMarker{ID: "global"}
 	t.Log("Marker Add normal", global.Pos)
-	ta.Add(global)
+	ta.AddToAOI(global)
 
 	t.Log("Marker Add global")
 	ta.AddGlobalMarker(global)
@@ -82,13 +88,13 @@ func TestTowerAOI_Move(t *testing.T) {
 	pos := linemath.Vector2{X: -5, Y: -5}
 	o1 := &aoi.TestWatcher{ID: "obj:1", Visual: 2, Pos: pos}
 	t.Log("Obj1 Add", o1.Pos)
-	ta.Add(o1)
+	ta.AddToAOI(o1)
 
 	pos.X = -3.5
 	pos.Y = -3.5
 	o2 := &aoi.TestWatcher{ID: "obj:2", Visual: 2, Pos: pos}
 	t.Log("Obj2 Add", o2.Pos)
-	ta.Add(o2)
+	ta.AddToAOI(o2)
 
 	o1.Pos.X = -4.2
 	o1.Pos.Y = -4.2
@@ -106,10 +112,10 @@ func TestTowerAOI_Move(t *testing.T) {
 	ta.Move(o1)
 
 	t.Log("Obj1 Remove")
-	ta.Remove(o1)
+	ta.RemoveFromAOI(o1)
 
 	t.Log("Obj2 Remove")
-	ta.Remove(o2)
+	ta.RemoveFromAOI(o2)
 }
 
 func TestTowerAOI_Group(t *testing.T) {
@@ -126,11 +132,11 @@ func TestTowerAOI_Group(t *testing.T) {
 
 	o1 := &aoi.TestWatcher{ID: "obj:1", Visual: 2, Pos: linemath.Vector2{}}
 	t.Log("Obj1 Add", o1.Pos)
-	ta.Add(o1)
+	ta.AddToAOI(o1)
 
 	o2 := &aoi.TestWatcher{ID: "obj:2", Visual: 2, Pos: linemath.Vector2{X: -3.5, Y: -3.5}}
 	t.Log("Obj2 Add", o2.Pos)
-	ta.Add(o2)
+	ta.AddToAOI(o2)
 
 	t.Log("CreateGroup o1 o2")
 	id, _ := ta.CreateGroup([]aoi.IObject{o1, o2})
@@ -158,11 +164,11 @@ func TestTowerAOI_Traversal(t *testing.T) {
 
 	o1 := &aoi.TestWatcher{ID: "obj:1", Visual: 2}
 	t.Log("Obj1 Add", o1.Pos)
-	ta.Add(o1)
+	ta.AddToAOI(o1)
 
 	o2 := &aoi.TestWatcher{ID: "obj:2", Visual: 2}
 	t.Log("Obj2 Add", o2.Pos)
-	ta.Add(o2)
+	ta.AddToAOI(o2)
 
 	t.Log("Traversal o1, o2")
 	ta.Traversal(o1, func(w aoi.IWatcher) bool {
@@ -203,6 +209,745 @@ func TestTowerAOI_Traversal(t *testing.T) {
 	})
 }
 
+func TestTowerAOI_ExactVisual(t *testing.T) {
+	ta, err := New(&Config{
+		MinPos:      linemath.Vector2{X: -10, Y: -10},
+		MaxPos:      linemath.Vector2{X: 10, Y: 10},
+		TowerSize:   2,
+		ExactVisual: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := aoitest.NewWatcher("watcher", linemath.Vector2{X: 0.5, Y: 0.5}, 3)
+	near := &aoi.TestMarker{ID: "near", Pos: linemath.Vector2{X: 2.5, Y: 0.5}}
+	corner := &aoi.TestMarker{ID: "corner", Pos: linemath.Vector2{X: 3, Y: 3}}
+	ta.AddToAOI(w)
+	ta.AddToAOI(near)
+	ta.AddToAOI(corner)
+
+	if !w.Sees("watcher") || !w.Sees("near") {
+		t.Fatal("near objects should be visible", w.Visible())
+	}
+	if w.Sees("corner") {
+		t.Fatal("corner of the tower square should not be visible")
+	}
+
+	// 同一灯塔内移动也要重新判断
+	corner.Pos = linemath.Vector2{X: 2.5, Y: 2.5}
+	ta.Move(corner)
+	if !w.Sees("corner") {
+		t.Fatal("corner moved into visual")
+	}
+
+	w.Pos = linemath.Vector2{X: 0.5, Y: -1.5}
+	ta.Move(w)
+	if w.Sees("corner") || !w.Sees("near") {
+		t.Fatal("watcher moved, visible set not updated", w.Visible())
+	}
+
+	// 全局对象不受距离限制
+	ta.AddGlobalMarker(corner)
+	if !w.Sees("corner") {
+		t.Fatal("global marker should be visible")
+	}
+	ta.RemoveGlobalMarker(corner)
+	if w.Sees("corner") {
+		t.Fatal("corner back to the towers, out of visual")
+	}
+
+	ta.RemoveFromAOI(near)
+	if w.Sees("near") {
+		t.Fatal("removed object still visible")
+	}
+}
+
+func TestTowerAOI_Query(t *testing.T) {
+	ta, err := New(&Config{
+		MinPos:    linemath.Vector2{X: -10, Y: -10},
+		MaxPos:    linemath.Vector2{X: 10, Y: 10},
+		TowerSize: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		ta.AddToAOI(&aoi.TestMarker{ID: fmt.Sprintf("obj:%d", i), Pos: linemath.Vector2{X: float32(i), Y: 0}})
+	}
+	global := &aoi.TestMarker{ID: "global", Pos: linemath.Vector2{X: -9, Y: -9}}
+	ta.AddToAOI(global)
+	ta.AddGlobalMarker(global)
+
+	q := ta.(aoi.IAOIQuery)
+
+	var ids []string
+	q.QueryRadius(linemath.Vector2{X: 0.5, Y: 0}, 1.5, func(obj aoi.IObject) bool {
+		ids = append(ids, obj.GetAOIID())
+		return true
+	})
+	if len(ids) != 3 {
+		t.Fatal("QueryRadius", ids)
+	}
+
+	count := 0
+	q.QueryRect(linemath.Vector2{X: -10, Y: -10}, linemath.Vector2{X: 3, Y: 0}, func(obj aoi.IObject) bool {
+		count++
+		return true
+	})
+	if count != 5 {
+		t.Fatal("QueryRect", count)
+	}
+
+	nearest := q.QueryKNearest(linemath.Vector2{X: 9.5, Y: 9.5}, 3)
+	if len(nearest) != 3 || nearest[0].GetAOIID() != "obj:9" || nearest[2].GetAOIID() != "obj:7" {
+		t.Fatal("QueryKNearest", nearest)
+	}
+
+	nearest = q.QueryKNearest(linemath.Vector2{X: -9.5, Y: -9.5}, 1)
+	if len(nearest) != 1 || nearest[0].GetAOIID() != "global" {
+		t.Fatal("QueryKNearest global", nearest)
+	}
+
+	if len(q.QueryKNearest(linemath.Vector2{}, 100)) != 11 {
+		t.Fatal("QueryKNearest all")
+	}
+
+	// 地图外很远的位置从边缘的灯塔开始找, 不能逐圈扩展过去
+	nearest = q.QueryKNearest(linemath.Vector2{X: 1e7, Y: 1}, 2)
+	if len(nearest) != 2 || nearest[0].GetAOIID() != "obj:9" || nearest[1].GetAOIID() != "obj:8" {
+		t.Fatal("QueryKNearest far away", nearest)
+	}
+	if nearest := q.QueryKNearest(linemath.Vector2{X: float32(math.NaN())}, 1); len(nearest) != 0 {
+		t.Fatal("QueryKNearest NaN", nearest)
+	}
+}
+
+func TestTowerAOI_Batch(t *testing.T) {
+	ta, err := New(&Config{
+		MinPos:    linemath.Vector2{X: -10, Y: -10},
+		MaxPos:    linemath.Vector2{X: 10, Y: 10},
+		TowerSize: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := ta.(aoi.IAOIBatch)
+
+	w := aoitest.NewWatcher("watcher", linemath.Vector2{}, 4)
+	ta.AddToAOI(w)
+	w.Reset()
+
+	objs := make([]*aoi.TestMarker, 0, 20)
+	batch.Begin()
+	for i := 0; i < 20; i++ {
+		o := &aoi.TestMarker{ID: fmt.Sprintf("obj:%d", i), Pos: linemath.Vector2{X: -9, Y: -9}}
+		ta.AddToAOI(o)
+		o.Pos = linemath.Vector2{X: float32(i%4) - 2, Y: 0}
+		ta.Move(o)
+		objs = append(objs, o)
+	}
+	if len(w.Enters) != 0 {
+		t.Fatal("watcher notified before commit")
+	}
+	batch.Commit()
+	if len(w.Enters) != 1 || len(w.Visible()) != 21 {
+		t.Fatal("expect exactly one enter batch", len(w.Enters), len(w.Visible()))
+	}
+
+	// 进入又离开的对象不产生事件
+	batch.Begin()
+	for _, o := range objs[:10] {
+		o.Pos = linemath.Vector2{X: 9, Y: 9}
+		ta.Move(o)
+	}
+	flicker := &aoi.TestMarker{ID: "flicker"}
+	ta.AddToAOI(flicker)
+	ta.RemoveFromAOI(flicker)
+	batch.Commit()
+	if len(w.Enters) != 1 || len(w.Leaves) != 1 || len(w.Visible()) != 11 {
+		t.Fatal("expect exactly one leave batch", len(w.Enters), len(w.Leaves), len(w.Visible()))
+	}
+}
+
+func TestTowerAOI_Sparse(t *testing.T) {
+	a, err := New(&Config{
+		TowerSize: 10,
+		Sparse:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ta := a.(*TowerAOI)
+
+	// 视野15, 关注周围一圈共9个灯塔
+	w := aoitest.NewWatcher("watcher", linemath.Vector2{X: 1e6 + 5, Y: -1e6 + 5}, 15)
+	o := &aoi.TestMarker{ID: "obj", Pos: linemath.Vector2{X: 1e6 + 12, Y: -1e6 + 5}}
+	far := &aoi.TestMarker{ID: "far", Pos: linemath.Vector2{X: -1e6, Y: 1e6}}
+	ta.AddToAOI(w)
+	ta.AddToAOI(o)
+	ta.AddToAOI(far)
+	if !w.Sees("obj") || w.Sees("far") {
+		t.Fatal("visible", w.Visible())
+	}
+	if ta.towers.len() != 10 {
+		t.Fatal("tower count", ta.towers.len())
+	}
+
+	w.Pos = linemath.Vector2{X: -1e6 + 5, Y: 1e6 + 5}
+	ta.Move(w)
+	if w.Sees("obj") || !w.Sees("far") {
+		t.Fatal("watcher moved", w.Visible())
+	}
+	// 原来的灯塔只剩obj所在的一个
+	if ta.towers.len() != 10 {
+		t.Fatal("tower count after move", ta.towers.len())
+	}
+
+	q := a.(aoi.IAOIQuery)
+	nearest := q.QueryKNearest(linemath.Vector2{}, 5)
+	if len(nearest) != 3 {
+		t.Fatal("QueryKNearest", nearest)
+	}
+	count := 0
+	q.QueryRect(linemath.Vector2{X: 0, Y: -2e6}, linemath.Vector2{X: 2e6, Y: 0}, func(obj aoi.IObject) bool {
+		count++
+		return true
+	})
+	if count != 1 {
+		t.Fatal("QueryRect", count)
+	}
+
+	ta.RemoveFromAOI(w)
+	ta.RemoveFromAOI(o)
+	ta.RemoveFromAOI(far)
+	if ta.towers.len() != 0 {
+		t.Fatal("towers should be released", ta.towers.len())
+	}
+}
+
+func TestTowerAOI_UpdateVisual(t *testing.T) {
+	for _, exact := range []bool{false, true} {
+		a, err := New(&Config{
+			MinPos:      linemath.Vector2{X: -100, Y: -100},
+			MaxPos:      linemath.Vector2{X: 100, Y: 100},
+			TowerSize:   10,
+			ExactVisual: exact,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ta := a.(*TowerAOI)
+
+		w := aoitest.NewWatcher("watcher", linemath.Vector2{X: 5, Y: 5}, 10)
+		near := &aoi.TestMarker{ID: "near", Pos: linemath.Vector2{X: 8, Y: 5}}
+		far := &aoi.TestMarker{ID: "far", Pos: linemath.Vector2{X: 35, Y: 5}}
+		ta.AddToAOI(w)
+		ta.AddToAOI(near)
+		ta.AddToAOI(far)
+		if !w.Sees("near") || w.Sees("far") {
+			t.Fatal(exact, "visible", w.Visible())
+		}
+
+		w.Visual = 40
+		enterCalls := len(w.Enters)
+		if err := ta.UpdateVisual(w); err != nil {
+			t.Fatal(err)
+		}
+		if !w.Sees("near") || !w.Sees("far") || len(w.Enters) != enterCalls+1 {
+			t.Fatal(exact, "visual grow", w.Visible(), len(w.Enters))
+		}
+
+		w.Visual = 2
+		leaveCalls := len(w.Leaves)
+		ta.UpdateVisual(w)
+		if exact && w.Sees("near") || !exact && !w.Sees("near") || w.Sees("far") || len(w.Leaves) != leaveCalls+1 {
+			t.Fatal(exact, "visual shrink", w.Visible(), len(w.Leaves))
+		}
+
+		w.Visual = 0
+		ta.UpdateVisual(w)
+		if len(w.Visible()) != 0 {
+			t.Fatal(exact, "visual zero", w.Visible())
+		}
+
+		// 视野变化后没有调用UpdateVisual, 移除时也不能留下关注
+		w.Visual = 10
+		ta.UpdateVisual(w)
+		w.Visual = 60
+		ta.RemoveFromAOI(w)
+		ta.towers.traversal(func(x, y int, tower *Tower) {
+			if tower.ExistedWatcher("watcher") {
+				t.Fatal(exact, "stale watcher in tower", x, y)
+			}
+		})
+
+		if ta.UpdateVisual(w) != aoi.ErrObjectNotExisted {
+			t.Fatal("removed watcher")
+		}
+		if ta.UpdateVisual(aoitest.NewWatcher("near", near.Pos, 1)) != aoi.ErrObjectInvalid {
+			t.Fatal("not a watcher")
+		}
+	}
+}
+
+func TestTowerAOI_CanSee(t *testing.T) {
+	hidden := make(map[string]bool)
+	ta, err := New(&Config{
+		MinPos:    linemath.Vector2{X: -100, Y: -100},
+		MaxPos:    linemath.Vector2{X: 100, Y: 100},
+		TowerSize: 10,
+		CanSee: func(watcher aoi.IWatcher, obj aoi.IObject) bool {
+			return !hidden[obj.GetAOIID()] || watcher.GetAOIID() == "gm"
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	player := aoitest.NewWatcher("player", linemath.Vector2{}, 20)
+	gm := aoitest.NewWatcher("gm", linemath.Vector2{X: 1}, 20)
+	hidden["gm"] = true
+	hidden["stealth"] = true
+	stealth := &aoi.TestMarker{ID: "stealth", Pos: linemath.Vector2{X: 2}}
+	ta.AddToAOI(player)
+	ta.AddToAOI(gm)
+	ta.AddToAOI(stealth)
+	if player.Sees("gm") || player.Sees("stealth") || !gm.Sees("stealth") || !gm.Sees("player") {
+		t.Fatal("hidden", player.Visible(), gm.Visible())
+	}
+
+	var ids []string
+	ta.Traversal(stealth, func(w aoi.IWatcher) bool {
+		ids = append(ids, w.GetAOIID())
+		return true
+	})
+	if len(ids) != 1 || ids[0] != "gm" {
+		t.Fatal("Traversal", ids)
+	}
+
+	hidden["stealth"] = false
+	ta.(*TowerAOI).Refresh(stealth)
+	if !player.Sees("stealth") {
+		t.Fatal("stealth broken", player.Visible())
+	}
+
+	hidden["stealth"] = true
+	ta.(*TowerAOI).Refresh(stealth)
+	if player.Sees("stealth") {
+		t.Fatal("stealth again", player.Visible())
+	}
+
+	// 全局对象和群组同样受策略限制
+	ta.AddGlobalMarker(stealth)
+	groupID, _ := ta.CreateGroup([]aoi.IObject{player, gm})
+	if player.Sees("stealth") || player.Sees("gm") || !gm.Sees("stealth") {
+		t.Fatal("global and group", player.Visible(), gm.Visible())
+	}
+	ta.DestroyGroup(groupID)
+
+	if ta.(*TowerAOI).Refresh(&aoi.TestMarker{ID: "none"}) != aoi.ErrObjectNotExisted {
+		t.Fatal("refresh not existed")
+	}
+}
+
+func TestTowerAOI_LeaveMargin(t *testing.T) {
+	if _, err := New(&Config{TowerSize: 10, LeaveMargin: 2, Sparse: true}); err != ErrTowerConfigInvalid {
+		t.Fatal("LeaveMargin requires ExactVisual", err)
+	}
+
+	ta, err := New(&Config{
+		MinPos:      linemath.Vector2{X: -100, Y: -100},
+		MaxPos:      linemath.Vector2{X: 100, Y: 100},
+		TowerSize:   10,
+		ExactVisual: true,
+		LeaveMargin: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := aoitest.NewWatcher("watcher", linemath.Vector2{X: 0.5, Y: 0.5}, 10)
+	o := &aoi.TestMarker{ID: "obj", Pos: linemath.Vector2{X: 11, Y: 0.5}}
+	ta.AddToAOI(w)
+	ta.AddToAOI(o)
+	if w.Sees("obj") {
+		t.Fatal("outside enter radius")
+	}
+
+	// 在视野边缘来回移动, 只进入一次
+	for i := 0; i < 10; i++ {
+		if i%2 == 0 {
+			o.Pos.X = 10
+		} else {
+			o.Pos.X = 11.5
+		}
+		ta.Move(o)
+	}
+	if !w.Sees("obj") || len(w.Enters) != 2 || len(w.Leaves) != 0 {
+		t.Fatal("jitter", w.Visible(), len(w.Enters), len(w.Leaves))
+	}
+
+	// 跨过灯塔边界仍然在离开半径内
+	o.Pos.X = 12.4
+	ta.Move(o)
+	if !w.Sees("obj") {
+		t.Fatal("inside leave radius")
+	}
+
+	o.Pos.X = 12.6
+	ta.Move(o)
+	if w.Sees("obj") {
+		t.Fatal("outside leave radius")
+	}
+
+	o.Pos.X = 11
+	ta.Move(o)
+	if w.Sees("obj") {
+		t.Fatal("must re-enter within visual")
+	}
+}
+
+func TestTowerAOI_MaxVisible(t *testing.T) {
+	ta, err := New(&Config{
+		MinPos:     linemath.Vector2{X: -100, Y: -100},
+		MaxPos:     linemath.Vector2{X: 100, Y: 100},
+		TowerSize:  10,
+		MaxVisible: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := aoitest.NewWatcher("watcher", linemath.Vector2{X: 0.5, Y: 0.5}, 50)
+	ta.AddToAOI(w)
+	objs := make([]*aoi.TestMarker, 0, 5)
+	for i := 1; i <= 5; i++ {
+		o := &aoi.TestMarker{ID: fmt.Sprintf("obj:%d", i), Pos: linemath.Vector2{X: 0.5 + float32(i), Y: 0.5}}
+		objs = append(objs, o)
+		ta.AddToAOI(o)
+	}
+	if len(w.Visible()) != 3 || !w.Sees("watcher") || !w.Sees("obj:1") || !w.Sees("obj:2") {
+		t.Fatal("nearest", w.Visible())
+	}
+
+	// 同一个灯塔内移动, 排名变化
+	enterCalls, leaveCalls := len(w.Enters), len(w.Leaves)
+	objs[4].Pos = linemath.Vector2{X: 0.5, Y: 1}
+	ta.Move(objs[4])
+	if len(w.Visible()) != 3 || !w.Sees("obj:5") || w.Sees("obj:2") || len(w.Enters) != enterCalls+1 || len(w.Leaves) != leaveCalls+1 {
+		t.Fatal("rank changed", w.Visible())
+	}
+
+	ta.RemoveFromAOI(objs[0])
+	if len(w.Visible()) != 3 || !w.Sees("obj:2") {
+		t.Fatal("removed", w.Visible())
+	}
+
+	// 自定义得分, boss总是优先
+	ta, _ = New(&Config{
+		MinPos:     linemath.Vector2{X: -100, Y: -100},
+		MaxPos:     linemath.Vector2{X: 100, Y: 100},
+		TowerSize:  10,
+		MaxVisible: 2,
+		Score: func(watcher aoi.IWatcher, obj aoi.IObject) float32 {
+			if obj.GetAOIID() == "boss" {
+				return 100
+			}
+			return -watcher.GetCoordPos().Sub(obj.GetCoordPos()).Len()
+		},
+	})
+	w = aoitest.NewWatcher("watcher", linemath.Vector2{}, 50)
+	ta.AddToAOI(w)
+	ta.AddToAOI(&aoi.TestMarker{ID: "boss", Pos: linemath.Vector2{X: 40}})
+	ta.AddToAOI(&aoi.TestMarker{ID: "near", Pos: linemath.Vector2{X: 1}})
+	if len(w.Visible()) != 2 || !w.Sees("boss") || !w.Sees("watcher") {
+		t.Fatal("score", w.Visible())
+	}
+}
+
+func TestTowerAOI_CollectUpdates(t *testing.T) {
+	if _, err := New(&Config{TowerSize: 10, Sparse: true, Bands: []Band{{Distance: 10, Interval: 1}, {Distance: 5, Interval: 2}}}); err != ErrTowerConfigInvalid {
+		t.Fatal("bands must be ascending", err)
+	}
+
+	a, err := New(&Config{
+		MinPos:    linemath.Vector2{X: -100, Y: -100},
+		MaxPos:    linemath.Vector2{X: 100, Y: 100},
+		TowerSize: 10,
+		Bands:     []Band{{Distance: 5, Interval: 1}, {Distance: 15, Interval: 3}, {Distance: 50, Interval: 6}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ta := a.(*TowerAOI)
+
+	w := aoitest.NewWatcher("watcher", linemath.Vector2{}, 50)
+	ta.AddToAOI(w)
+	ta.AddToAOI(&aoi.TestMarker{ID: "near", Pos: linemath.Vector2{X: 2}})
+	ta.AddToAOI(&aoi.TestMarker{ID: "mid", Pos: linemath.Vector2{X: 10}})
+	ta.AddToAOI(&aoi.TestMarker{ID: "far", Pos: linemath.Vector2{X: 30}})
+
+	counts := make(map[string]int)
+	collect := func(ticks int) {
+		for i := 0; i < ticks; i++ {
+			objs, err := ta.CollectUpdates(w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, o := range objs {
+				counts[o.GetAOIID()]++
+			}
+		}
+	}
+
+	collect(6)
+	if counts["watcher"] != 6 || counts["near"] != 6 || counts["mid"] != 2 || counts["far"] != 1 {
+		t.Fatal("bands", counts)
+	}
+
+	// 新进入的对象从进入时开始计时
+	ta.AddToAOI(&aoi.TestMarker{ID: "late", Pos: linemath.Vector2{X: 12}})
+	collect(2)
+	if counts["late"] != 0 {
+		t.Fatal("late synced too early", counts)
+	}
+	collect(1)
+	if counts["late"] != 1 {
+		t.Fatal("late", counts)
+	}
+
+	if _, err := ta.CollectUpdates(&aoi.TestWatcher{ID: "none"}); err != aoi.ErrObjectNotExisted {
+		t.Fatal("not existed", err)
+	}
+}
+
+func TestTowerAOI_Snapshot(t *testing.T) {
+	newAOI := func() *TowerAOI {
+		a, err := New(&Config{
+			MinPos:    linemath.Vector2{X: -100, Y: -100},
+			MaxPos:    linemath.Vector2{X: 100, Y: 100},
+			TowerSize: 10,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a.(*TowerAOI)
+	}
+
+	ta := newAOI()
+	w1 := aoitest.NewWatcher("w1", linemath.Vector2{}, 15)
+	w2 := aoitest.NewWatcher("w2", linemath.Vector2{X: 50, Y: 50}, 15)
+	o1 := &aoi.TestMarker{ID: "o1", Pos: linemath.Vector2{X: 5, Y: 5}}
+	g := &aoi.TestMarker{ID: "global", Pos: linemath.Vector2{X: -80, Y: -80}}
+	for _, o := range []aoi.IObject{w1, w2, o1, g} {
+		ta.AddToAOI(o)
+	}
+	ta.AddGlobalMarker(g)
+	groupID, _ := ta.CreateGroup([]aoi.IObject{w1, w2})
+	ta.CreateGroup([]aoi.IObject{o1})
+	ta.DestroyGroup(groupID + 1)
+
+	var buf bytes.Buffer
+	if err := ta.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// 热重启后的对象, 客户端已经知道的视野保持不变
+	restored := make(map[string]aoi.IObject)
+	r1 := aoitest.NewWatcher("w1", w1.Pos, 15)
+	r2 := aoitest.NewWatcher("w2", w2.Pos, 15)
+	for _, o := range []aoi.IObject{r1, r2, &aoi.TestMarker{ID: "o1", Pos: o1.Pos}, &aoi.TestMarker{ID: "global", Pos: g.Pos}} {
+		restored[o.GetAOIID()] = o
+	}
+	resolve := func(id string) aoi.IObject {
+		return restored[id]
+	}
+
+	rt := newAOI()
+	if err := rt.Restore(bytes.NewReader(data), resolve); err != nil {
+		t.Fatal(err)
+	}
+	if len(r1.Enters) != 0 || len(r2.Enters) != 0 || len(r1.Leaves) != 0 || len(r2.Leaves) != 0 {
+		t.Fatal("spurious events", r1.Visible(), r2.Visible())
+	}
+
+	var again bytes.Buffer
+	rt.Snapshot(&again)
+	if !bytes.Equal(data, again.Bytes()) {
+		t.Fatal("snapshot changed after restore")
+	}
+
+	// 恢复后的状态正常工作
+	if rt.groupIDSeed != ta.groupIDSeed || len(rt.groups) != 1 {
+		t.Fatal("groups", rt.groupIDSeed, len(rt.groups))
+	}
+	rt.DestroyGroup(groupID)
+	if len(r1.Enters) != 0 || len(r1.Leaves) != 1 {
+		t.Fatal("group destroyed", len(r1.Enters), len(r1.Leaves))
+	}
+	r2.Pos = linemath.Vector2{X: 2, Y: 2}
+	rt.Move(r2)
+	// 全局标记在快照时已经可见, 不会重复进入
+	if !r1.Sees("w2") || !r2.Sees("o1") || r2.Sees("global") {
+		t.Fatal("move after restore", r1.Visible(), r2.Visible())
+	}
+
+	if rt.Restore(bytes.NewReader(data), resolve) != aoi.ErrObjectExisted {
+		t.Fatal("restore into non-empty aoi")
+	}
+	bad := append([]byte(nil), data...)
+	bad[4] = 99
+	if newAOI().Restore(bytes.NewReader(bad), resolve) != ErrSnapshotVersion {
+		t.Fatal("version")
+	}
+	if newAOI().Restore(bytes.NewReader(data[:len(data)-3]), resolve) == nil {
+		t.Fatal("truncated")
+	}
+
+	// 视野和快照不一致时拒绝恢复, 不按快照中的灯塔圈数关注灯塔
+	restored["w1"] = aoitest.NewWatcher("w1", w1.Pos, 1e6)
+	if newAOI().Restore(bytes.NewReader(data), resolve) != ErrSnapshotInvalid {
+		t.Fatal("visual mismatch")
+	}
+}
+
+type snapshotObj struct {
+	id           string
+	flags        uint8
+	x, y, visual int32
+}
+
+type snapshotGroup struct {
+	id      int64
+	members []string
+}
+
+// encodeSnapshot 按快照格式直接写入, 用来构造损坏的快照
+func encodeSnapshot(objs []snapshotObj, groups []snapshotGroup) []byte {
+	var buf bytes.Buffer
+	e := &_Encoder{w: &buf}
+	e.write(snapshotMagic)
+	e.write(snapshotVersion)
+	e.write(int64(0))
+	e.write(uint32(len(objs)))
+	for _, o := range objs {
+		e.writeString(o.id)
+		e.write(o.flags)
+		e.write(o.x)
+		e.write(o.y)
+		e.write(o.visual)
+	}
+	e.write(uint32(len(groups)))
+	for _, g := range groups {
+		e.write(g.id)
+		e.write(uint32(len(g.members)))
+		for _, id := range g.members {
+			e.writeString(id)
+		}
+	}
+	e.write(uint32(0))
+
+	return buf.Bytes()
+}
+
+func TestTowerAOI_SnapshotCorrupt(t *testing.T) {
+	objs := map[string]aoi.IObject{
+		"o": &aoi.TestMarker{ID: "o", Pos: linemath.Vector2{X: 5, Y: 5}},
+		"p": &aoi.TestMarker{ID: "p", Pos: linemath.Vector2{X: 5, Y: 5}},
+	}
+	restore := func(data []byte) error {
+		a, _ := New(&Config{
+			MinPos:    linemath.Vector2{X: -100, Y: -100},
+			MaxPos:    linemath.Vector2{X: 100, Y: 100},
+			TowerSize: 10,
+		})
+		return a.(*TowerAOI).Restore(bytes.NewReader(data), func(id string) aoi.IObject { return objs[id] })
+	}
+
+	o, p := snapshotObj{id: "o", x: 10, y: 10}, snapshotObj{id: "p", x: 10, y: 10}
+	if err := restore(encodeSnapshot([]snapshotObj{o, p}, []snapshotGroup{{1, []string{"o", "p"}}})); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string][]byte{
+		"duplicate object": encodeSnapshot([]snapshotObj{o, o}, nil),
+		"duplicate group":  encodeSnapshot([]snapshotObj{o, p}, []snapshotGroup{{1, []string{"o"}}, {1, []string{"p"}}}),
+		"duplicate member": encodeSnapshot([]snapshotObj{o}, []snapshotGroup{{1, []string{"o", "o"}}}),
+	}
+	for name, data := range cases {
+		if err := restore(data); err != ErrSnapshotInvalid {
+			t.Fatal(name, err)
+		}
+	}
+}
+
+func TestTowerAOI_SparseTowerRange(t *testing.T) {
+	a, err := New(&Config{
+		TowerSize: 10,
+		Sparse:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ta := a.(*TowerAOI)
+
+	startX, endX, startY, endY := ta.getTowerRange(math.MaxInt32-1, math.MinInt32+1, 5)
+	if startX != math.MaxInt32-6 || endX != math.MaxInt32 || startY != math.MinInt32 || endY != math.MinInt32+6 {
+		t.Fatal("range", startX, endX, startY, endY)
+	}
+	startX, endX, _, _ = ta.getTowerRange(0, 0, math.MaxInt64)
+	if startX != math.MinInt32 || endX != math.MaxInt32 {
+		t.Fatal("overflow", startX, endX)
+	}
+}
+
+// TestTowerAOI_SparseFarPos 灯塔坐标超出int32的位置不能加入, 否则快照会截断坐标
+func TestTowerAOI_SparseFarPos(t *testing.T) {
+	a, err := New(&Config{
+		TowerSize: 1,
+		Sparse:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ta := a.(*TowerAOI)
+
+	// 灯塔坐标1e9还在int32范围内
+	w := aoitest.NewWatcher("w", linemath.Vector2{X: 1e9, Y: 1e9}, 100)
+	o := &aoi.TestMarker{ID: "o", Pos: linemath.Vector2{X: 1e9 + 64, Y: 1e9}}
+	if ta.AddToAOI(w) != nil || ta.AddToAOI(o) != nil || !w.Sees("o") {
+		t.Fatal("add", w.Visible())
+	}
+
+	far := &aoi.TestMarker{ID: "far", Pos: linemath.Vector2{X: 5e9, Y: 5e9}}
+	if ta.AddToAOI(far) != aoi.ErrPosInvalid {
+		t.Fatal("add far")
+	}
+	o.Pos = far.Pos
+	if ta.Move(o) != aoi.ErrPosInvalid || !w.Sees("o") {
+		t.Fatal("move far", w.Visible())
+	}
+	if err := ta.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := ta.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	objs := map[string]aoi.IObject{"w": aoitest.NewWatcher("w", w.Pos, 100), "o": o}
+	rt, _ := New(&Config{TowerSize: 1, Sparse: true})
+	if err := rt.(*TowerAOI).Restore(&buf, func(id string) aoi.IObject { return objs[id] }); err != nil {
+		t.Fatal(err)
+	}
+	if rt.(*TowerAOI).objs["o"].X != ta.objs["o"].X {
+		t.Fatal("restored tower", rt.(*TowerAOI).objs["o"].X, ta.objs["o"].X)
+	}
+}
+
 func BenchmarkTowerAOI_200000_10000_Traversal(b *testing.B) {
 	b.ReportAllocs()
 
@@ -222,7 +967,7 @@ func BenchmarkTowerAOI_200000_10000_Traversal(b *testing.B) {
 		o := &aoi.TestMarker{ID: fmt.Sprintf("obj:%d", i)}
 		o.Pos.X = rand.Float32() * 8000
 		o.Pos.Y = rand.Float32() * 8000
-		ta.Add(o)
+		ta.AddToAOI(o)
 
 		objs[i] = o
 	}
@@ -231,7 +976,7 @@ func BenchmarkTowerAOI_200000_10000_Traversal(b *testing.B) {
 		w := &aoi.TestWatcher{ID: fmt.Sprintf("watcher:%d", i), Visual: 100}
 		w.Pos.X = rand.Float32() * 8000
 		w.Pos.Y = rand.Float32() * 8000
-		ta.Add(w)
+		ta.AddToAOI(w)
 	}
 
 	b.ResetTimer()
@@ -261,7 +1006,7 @@ func BenchmarkTowerAOI_200000_10000_Move(b *testing.B) {
 		o := &aoi.TestMarker{ID: fmt.Sprintf("obj:%d", i)}
 		o.Pos.X = rand.Float32() * 8000
 		o.Pos.Y = rand.Float32() * 8000
-		ta.Add(o)
+		ta.AddToAOI(o)
 
 		objs[i] = o
 	}
@@ -270,7 +1015,7 @@ func BenchmarkTowerAOI_200000_10000_Move(b *testing.B) {
 		w := &aoi.TestWatcher{ID: fmt.Sprintf("watcher:%d", i), Visual: 100}
 		w.Pos.X = rand.Float32() * 8000
 		w.Pos.Y = rand.Float32() * 8000
-		ta.Add(w)
+		ta.AddToAOI(w)
 	}
 
 	b.ResetTimer()
@@ -300,7 +1045,7 @@ func BenchmarkTowerAOI_200000_10000_MoveWatcher(b *testing.B) {
 		o := &aoi.TestMarker{ID: fmt.Sprintf("obj:%d", i)}
 		o.Pos.X = rand.Float32() * 8000
 		o.Pos.Y = rand.Float32() * 8000
-		ta.Add(o)
+		ta.AddToAOI(o)
 	}
 
 	objs := make(map[int]*aoi.TestWatcher)
@@ -308,7 +1053,7 @@ func BenchmarkTowerAOI_200000_10000_MoveWatcher(b *testing.B) {
 		w := &aoi.TestWatcher{ID: fmt.Sprintf("watcher:%d", i), Visual: 100}
 		w.Pos.X = rand.Float32() * 8000
 		w.Pos.Y = rand.Float32() * 8000
-		ta.Add(w)
+		ta.AddToAOI(w)
 
 		objs[i] = w
 	}
@@ -321,3 +1066,477 @@ func BenchmarkTowerAOI_200000_10000_MoveWatcher(b *testing.B) {
 		ta.Move(objs[index])
 	}
 }
+
+func TestTowerAOI_Region(t *testing.T) {
+	newAOI := func() *TowerAOI {
+		a, err := New(&Config{
+			MinPos:    linemath.Vector2{X: -100, Y: -100},
+			MaxPos:    linemath.Vector2{X: 100, Y: 100},
+			TowerSize: 10,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a.(*TowerAOI)
+	}
+
+	// 区域是x >= 0的一半地图, 两边的watcher都能看到边界附近的对象
+	src, dst := newAOI(), newAOI()
+	left := aoitest.NewWatcher("left", linemath.Vector2{X: -5}, 25)
+	right := aoitest.NewWatcher("right", linemath.Vector2{X: 5}, 25)
+	o1 := &aoi.TestMarker{ID: "o1", Pos: linemath.Vector2{X: 8}}
+	o2 := &aoi.TestMarker{ID: "o2", Pos: linemath.Vector2{X: 12}}
+	for _, o := range []aoi.IObject{left, right, o1, o2} {
+		src.AddToAOI(o)
+	}
+	src.CreateGroup([]aoi.IObject{left, o2})
+	src.CreateGroup([]aoi.IObject{right, o1})
+
+	// 目标AOI中已经在边界另一侧的watcher
+	remote := aoitest.NewWatcher("remote", linemath.Vector2{X: -5}, 25)
+	dst.AddToAOI(remote)
+
+	left.Reset()
+	records, err := src.ExtractRegion(linemath.Vector2{X: 0, Y: -100}, linemath.Vector2{X: 100, Y: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0].ID != "o1" || records[2].ID != "right" || !records[2].Watcher || records[2].Visual != 25 {
+		t.Fatal("records", records)
+	}
+	if len(left.Leaves) != 1 || left.Sees("right") || left.Sees("o1") || left.Sees("o2") || !left.Sees("left") {
+		t.Fatal("source leave", len(left.Leaves), left.Visible())
+	}
+	if len(right.Visible()) != 0 || len(src.objs) != 1 {
+		t.Fatal("extracted", right.Visible(), len(src.objs))
+	}
+	// 留在源AOI的成员所在的群组保留
+	if len(src.groups) != 1 {
+		t.Fatal("source groups", len(src.groups))
+	}
+
+	objs := map[string]aoi.IObject{"o1": o1, "o2": o2, "right": right}
+	resolve := func(id string) aoi.IObject {
+		return objs[id]
+	}
+
+	right.Reset()
+	groupIDs, err := dst.ImportRegion(records, resolve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remote.Enters) != 2 || !remote.Sees("o1") || !remote.Sees("o2") || !remote.Sees("right") {
+		t.Fatal("destination enter", len(remote.Enters), remote.Visible())
+	}
+	if !right.Sees("remote") || !right.Sees("o1") || len(right.Enters) != 1 {
+		t.Fatal("imported watcher", len(right.Enters), right.Visible())
+	}
+	// 源群组{left, o2}只导入了o2, {right, o1}整体导入
+	if len(groupIDs) != 2 || len(dst.groups) != 2 || dst.groups[groupIDs[records[0].Groups[0]]].GetObjsLen() != 2 {
+		t.Fatal("groups", groupIDs)
+	}
+
+	// 重复导入, 记录错误都不做修改
+	if _, err := dst.ImportRegion(records, resolve); err != aoi.ErrObjectExisted {
+		t.Fatal("import existed", err)
+	}
+	bad := []RegionRecord{{ID: "o3"}}
+	if _, err := src.ImportRegion(bad, resolve); err != aoi.ErrObjectInvalid || len(src.objs) != 1 {
+		t.Fatal("import invalid", err)
+	}
+}
+
+// flipMarker 第一次读取位置之后跑到地图外, 用于在检查之后制造错误
+type flipMarker struct {
+	aoi.TestMarker
+	reads int
+}
+
+func (m *flipMarker) GetCoordPos() linemath.Vector2 {
+	m.reads++
+	if m.reads > 1 {
+		return linemath.Vector2{X: 1000}
+	}
+	return m.Pos
+}
+
+func TestTowerAOI_RegionGlobal(t *testing.T) {
+	newAOI := func() *TowerAOI {
+		a, err := New(&Config{
+			MinPos:    linemath.Vector2{X: -100, Y: -100},
+			MaxPos:    linemath.Vector2{X: 100, Y: 100},
+			TowerSize: 10,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a.(*TowerAOI)
+	}
+
+	// 全局对象按位置归属区域, 一起移出, 导入后仍是全局对象
+	src, dst := newAOI(), newAOI()
+	w := aoitest.NewWatcher("watcher", linemath.Vector2{X: -50}, 10)
+	g := &aoi.TestMarker{ID: "global", Pos: linemath.Vector2{X: 50}}
+	o := &aoi.TestMarker{ID: "obj", Pos: linemath.Vector2{X: 60}}
+	for _, obj := range []aoi.IObject{w, g, o} {
+		src.AddToAOI(obj)
+	}
+	src.AddGlobalMarker(g)
+	records, err := src.ExtractRegion(linemath.Vector2{X: 0, Y: -100}, linemath.Vector2{X: 100, Y: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].ID != "global" || !records[0].Global || records[1].Global {
+		t.Fatal("records", records)
+	}
+	if w.Sees("global") || src.global.GetObjsLen() != 0 {
+		t.Fatal("global extracted", w.Visible())
+	}
+
+	remote := aoitest.NewWatcher("remote", linemath.Vector2{X: -50}, 10)
+	dst.AddToAOI(remote)
+	objs := map[string]aoi.IObject{"global": g, "obj": o}
+	resolve := func(id string) aoi.IObject {
+		return objs[id]
+	}
+	if _, err := dst.ImportRegion(records, resolve); err != nil {
+		t.Fatal(err)
+	}
+	if !remote.Sees("global") || remote.Sees("obj") || !dst.global.Existed("global") {
+		t.Fatal("global imported", remote.Visible())
+	}
+
+	// 记录中同一个群组重复出现
+	bad := []RegionRecord{{ID: "other", Groups: []int{1, 1}}}
+	objs["other"] = &aoi.TestMarker{ID: "other"}
+	if _, err := src.ImportRegion(bad, resolve); err != aoi.ErrObjectInvalid || len(src.objs) != 1 {
+		t.Fatal("duplicate group", err)
+	}
+
+	// 检查之后加入失败, 已经加入的对象撤销, watcher不会收到通知
+	target := newAOI()
+	watcher := aoitest.NewWatcher("target", linemath.Vector2{}, 50)
+	target.AddToAOI(watcher)
+	watcher.Reset()
+	objs["flip"] = &flipMarker{TestMarker: aoi.TestMarker{ID: "flip"}}
+	flip := []RegionRecord{{ID: "other", Groups: []int{1}}, {ID: "flip", Groups: []int{1}}}
+	if _, err := target.ImportRegion(flip, resolve); err != aoi.ErrPosInvalid {
+		t.Fatal("flip", err)
+	}
+	if len(target.objs) != 1 || len(target.groups) != 0 || len(watcher.Enters) != 0 || len(watcher.Leaves) != 0 {
+		t.Fatal("rollback", len(target.objs), len(target.groups), watcher.Enters, watcher.Leaves)
+	}
+}
+
+func TestTowerAOI_Metrics(t *testing.T) {
+	collector, err := metrics.New(&metrics.Config{Name: "toweraoi_test", SampleInterval: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ta, _ := New(&Config{
+		MinPos:    linemath.Vector2{X: -50, Y: -50},
+		MaxPos:    linemath.Vector2{X: 50, Y: 50},
+		TowerSize: 10,
+		Metrics:   collector,
+	})
+
+	w := aoitest.NewWatcher("watcher", linemath.Vector2{}, 10)
+	o := &aoi.TestMarker{ID: "obj", Pos: linemath.Vector2{X: 1}}
+	ta.AddToAOI(w)
+	ta.AddToAOI(o)
+	o.Pos = linemath.Vector2{X: 40, Y: 40}
+	ta.Move(o)
+	ta.RemoveFromAOI(o)
+
+	var vars struct {
+		Add       int64 `json:"add"`
+		Move      int64 `json:"move"`
+		Remove    int64 `json:"remove"`
+		Enter     int64 `json:"enter"`
+		Leave     int64 `json:"leave"`
+		TowerObjs struct {
+			Count int64 `json:"count"`
+		} `json:"tower_objs"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("toweraoi_test").String()), &vars); err != nil {
+		t.Fatal(err)
+	}
+	// 进入: watcher自己, obj; 离开: obj移出视野
+	if vars.Add != 2 || vars.Move != 1 || vars.Remove != 1 || vars.Enter != 2 || vars.Leave != 1 || vars.TowerObjs.Count != 1 {
+		t.Fatal("metrics", expvar.Get("toweraoi_test").String())
+	}
+
+	// 全局标记和群组的操作也统计, 失败的操作同样计数
+	ta.AddGlobalMarker(w)
+	ta.RemoveGlobalMarker(w)
+	groupID, _ := ta.CreateGroup([]aoi.IObject{w})
+	ta.AddToGroup(w, groupID)
+	ta.RemoveFromGroup(w, groupID)
+	ta.DestroyGroup(groupID)
+	var ops map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(expvar.Get("toweraoi_test").String()), &ops); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"add_global", "remove_global", "create_group", "destroy_group", "add_to_group", "remove_from_group"} {
+		if string(ops[name]) != "1" {
+			t.Fatal(name, string(ops[name]))
+		}
+	}
+}
+
+func TestTowerAOI_GroupDuplicate(t *testing.T) {
+	ta, err := New(&Config{
+		MinPos:    linemath.Vector2{X: -100, Y: -100},
+		MaxPos:    linemath.Vector2{X: 100, Y: 100},
+		TowerSize: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := aoitest.NewWatcher("watcher", linemath.Vector2{X: -90, Y: -90}, 10)
+	m := &aoi.TestMarker{ID: "member", Pos: linemath.Vector2{X: 90, Y: 90}}
+	ta.AddToAOI(w)
+	ta.AddToAOI(m)
+
+	// 重复的对象只加入一次, 销毁后不能留下计数
+	groupID, err := ta.CreateGroup([]aoi.IObject{w, m, m, w})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !w.Sees("member") {
+		t.Fatal("group member not visible")
+	}
+
+	if err := ta.DestroyGroup(groupID); err != nil {
+		t.Fatal(err)
+	}
+	if w.Sees("member") {
+		t.Fatal("group member visible after destroy")
+	}
+}
+
+func TestTowerAOI_RemoveFromGroup(t *testing.T) {
+	ta, err := New(&Config{
+		MinPos:    linemath.Vector2{X: -100, Y: -100},
+		MaxPos:    linemath.Vector2{X: 100, Y: 100},
+		TowerSize: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := aoitest.NewWatcher("watcher", linemath.Vector2{X: -90, Y: -90}, 10)
+	m := &aoi.TestMarker{ID: "member", Pos: linemath.Vector2{X: 90, Y: 90}}
+	ta.AddToAOI(w)
+	ta.AddToAOI(m)
+
+	// 对象同时在两个组中, 从第一个组中移除后第二个组不受影响
+	first, err := ta.CreateGroup([]aoi.IObject{w, m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ta.CreateGroup([]aoi.IObject{w, m})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ta.RemoveFromGroup(m, first); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Sees("member") {
+		t.Fatal("member in second group not visible")
+	}
+	if err := ta.RemoveFromGroup(m, second); err != nil {
+		t.Fatal(err)
+	}
+	if w.Sees("member") {
+		t.Fatal("member visible after leaving all groups")
+	}
+}
+
+func TestTowerAOI_Validate(t *testing.T) {
+	configs := []*Config{
+		{MinPos: linemath.Vector2{X: -50, Y: -50}, MaxPos: linemath.Vector2{X: 50, Y: 50}, TowerSize: 5},
+		{MinPos: linemath.Vector2{X: -50, Y: -50}, MaxPos: linemath.Vector2{X: 50, Y: 50}, TowerSize: 5, ExactVisual: true, MaxVisible: 5, Debug: true},
+		{TowerSize: 5, Sparse: true},
+	}
+
+	for _, cfg := range configs {
+		a, err := New(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ta := a.(*TowerAOI)
+
+		r := rand.New(rand.NewSource(1))
+		randPos := func() linemath.Vector2 {
+			return linemath.Vector2{X: r.Float32()*100 - 50, Y: r.Float32()*100 - 50}
+		}
+
+		var objs []aoi.IObject
+		for i := 0; i < 50; i++ {
+			if i%3 == 0 {
+				objs = append(objs, aoitest.NewWatcher(fmt.Sprintf("watcher:%d", i), randPos(), r.Float32()*20))
+			} else {
+				objs = append(objs, &aoi.TestMarker{ID: fmt.Sprintf("obj:%d", i), Pos: randPos()})
+			}
+			ta.AddToAOI(objs[i])
+		}
+
+		var groupIDs []int
+		for i := 0; i < 2000; i++ {
+			o := objs[r.Intn(len(objs))]
+			switch op := r.Intn(10); {
+			case op < 5:
+				switch v := o.(type) {
+				case *aoitest.Watcher:
+					v.Pos = randPos()
+				case *aoi.TestMarker:
+					v.Pos = randPos()
+				}
+				ta.Move(o)
+			case op == 5:
+				if ta.RemoveFromAOI(o) != nil {
+					ta.AddToAOI(o)
+				}
+			case op == 6:
+				if ta.AddGlobalMarker(o) == aoi.ErrObjectExisted {
+					ta.RemoveGlobalMarker(o)
+				}
+			case op == 7:
+				if groupID, err := ta.CreateGroup([]aoi.IObject{o, objs[r.Intn(len(objs))]}); err == nil {
+					groupIDs = append(groupIDs, groupID)
+				}
+			case op == 8 && len(groupIDs) > 0:
+				groupID := groupIDs[r.Intn(len(groupIDs))]
+				if ta.AddToGroup(o, groupID) == nil && r.Intn(2) == 0 {
+					ta.RemoveFromGroup(o, groupID)
+				}
+			case op == 9:
+				if w, ok := o.(*aoitest.Watcher); ok {
+					w.Visual = r.Float32() * 20
+					ta.UpdateVisual(w)
+				}
+			}
+
+			if err := ta.Validate(); err != nil {
+				t.Fatal(i, err)
+			}
+		}
+
+		// 破坏内部状态后能检查出来
+		for _, cacheObj := range ta.objs {
+			if cacheObj.wrapWatcher != nil {
+				for _, info := range cacheObj.wrapWatcher.info {
+					info.count++
+					break
+				}
+				break
+			}
+		}
+		if ta.Validate() == nil {
+			t.Fatal("corrupted count")
+		}
+	}
+}
+
+func TestTowerAOI_ExactGlobal(t *testing.T) {
+	ta, err := New(&Config{
+		MinPos:      linemath.Vector2{X: -100, Y: -100},
+		MaxPos:      linemath.Vector2{X: 100, Y: 100},
+		TowerSize:   10,
+		ExactVisual: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gw := aoitest.NewWatcher("global", linemath.Vector2{X: 1, Y: 1}, 20)
+	m := &aoi.TestMarker{ID: "marker", Pos: linemath.Vector2{X: 24, Y: 1}}
+	ta.AddToAOI(gw)
+	ta.AddToAOI(m)
+	if err := ta.AddGlobalMarker(gw); err != nil {
+		t.Fatal(err)
+	}
+	if gw.Sees("marker") {
+		t.Fatal("marker out of visual")
+	}
+
+	// 全局watcher在同一灯塔内移动也要重新判断自己的视野
+	gw.Pos = linemath.Vector2{X: 9, Y: 1}
+	if err := ta.Move(gw); err != nil {
+		t.Fatal(err)
+	}
+	if !gw.Sees("marker") {
+		t.Fatal("marker moved into visual")
+	}
+
+	// 取消全局后按距离判断其他watcher能否看到
+	w := aoitest.NewWatcher("watcher", linemath.Vector2{X: -20, Y: 1}, 20)
+	ta.AddToAOI(w)
+	if !w.Sees("global") {
+		t.Fatal("global marker not visible")
+	}
+	if err := ta.RemoveGlobalMarker(gw); err != nil {
+		t.Fatal(err)
+	}
+	if w.Sees("global") {
+		t.Fatal("marker out of visual after remove global")
+	}
+
+	// 取消全局时位置已经在同一灯塔内变化, 重新判断自己的视野
+	if err := ta.AddGlobalMarker(gw); err != nil {
+		t.Fatal(err)
+	}
+	gw.Pos = linemath.Vector2{X: 1, Y: 1}
+	if err := ta.RemoveGlobalMarker(gw); err != nil {
+		t.Fatal(err)
+	}
+	if gw.Sees("marker") {
+		t.Fatal("marker out of visual after remove global")
+	}
+}
+
+func TestTowerAOI_RemoveGlobalWatcher(t *testing.T) {
+	a, err := New(&Config{
+		TowerSize: 10,
+		Sparse:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ta := a.(*TowerAOI)
+
+	gw := aoitest.NewWatcher("global", linemath.Vector2{X: 5, Y: 5}, 15)
+	ta.AddToAOI(gw)
+	ta.AddToAOI(&aoi.TestMarker{ID: "old", Pos: linemath.Vector2{X: 12, Y: 5}})
+	ta.AddToAOI(&aoi.TestMarker{ID: "new", Pos: linemath.Vector2{X: 1012, Y: 5}})
+	if err := ta.AddGlobalMarker(gw); err != nil {
+		t.Fatal(err)
+	}
+
+	// 取消全局时位置已经变化, 关注的灯塔跟着移动, 原来的灯塔释放
+	gw.Pos = linemath.Vector2{X: 1005, Y: 5}
+	if err := ta.RemoveGlobalMarker(gw); err != nil {
+		t.Fatal(err)
+	}
+	if gw.Sees("old") || !gw.Sees("new") {
+		t.Fatal("visible", gw.Visible())
+	}
+	if ta.towers.len() != 10 {
+		t.Fatal("tower count", ta.towers.len())
+	}
+}
+
+func TestTowerAOI_Conformance(t *testing.T) {
+	aoitest.RunConformance(t, aoitest.Factory{New: func() (aoi.IAOI, error) {
+		return New(&Config{MinPos: aoitest.MinPos, MaxPos: aoitest.MaxPos, TowerSize: 10})
+	}})
+
+	t.Run("ExactVisual", func(t *testing.T) {
+		aoitest.RunConformance(t, aoitest.Factory{New: func() (aoi.IAOI, error) {
+			return New(&Config{MinPos: aoitest.MinPos, MaxPos: aoitest.MaxPos, TowerSize: 10, ExactVisual: true})
+		}})
+	})
+}
@@ -0,0 +1,115 @@
+package toweraoi
+
+// towerGrid 灯塔的存储方式, 有边界时预先分配数组, 无边界时用map按需创建
+type towerGrid interface {
+	// getTower 不存在时创建
+	getTower(x, y int) *Tower
+	// findTower 只查找不创建, 不存在返回nil
+	findTower(x, y int) *Tower
+	// release 灯塔为空时释放
+	release(x, y int)
+	traversal(f func(x, y int, tower *Tower))
+	len() int
+}
+
+type arrayTowerGrid [][]*Tower
+type mapTowerGrid map[int]map[int]*Tower
+
+func newArrayTowerGrid(sizeX, sizeY int) *arrayTowerGrid {
+	at := make(arrayTowerGrid, sizeX)
+	for x := 0; x < sizeX; x++ {
+		at[x] = make([]*Tower, sizeY)
+		for y := 0; y < sizeY; y++ {
+			at[x][y] = NewTower()
+		}
+	}
+
+	return &at
+}
+
+func (at *arrayTowerGrid) getTower(x, y int) *Tower {
+	return (*at)[x][y]
+}
+
+func (at *arrayTowerGrid) findTower(x, y int) *Tower {
+	if x < 0 || x >= len(*at) || y < 0 || y >= len((*at)[x]) {
+		return nil
+	}
+
+	return (*at)[x][y]
+}
+
+// release 数组中的灯塔一直保留
+func (at *arrayTowerGrid) release(x, y int) {
+}
+
+func (at *arrayTowerGrid) traversal(f func(x, y int, tower *Tower)) {
+	for x, m := range *at {
+		for y, v := range m {
+			f(x, y, v)
+		}
+	}
+}
+
+func (at *arrayTowerGrid) len() int {
+	if len(*at) == 0 {
+		return 0
+	}
+
+	return len(*at) * len((*at)[0])
+}
+
+func newMapTowerGrid() *mapTowerGrid {
+	mt := make(mapTowerGrid)
+	return &mt
+}
+
+func (mt *mapTowerGrid) getTower(x, y int) *Tower {
+	if _, ok := (*mt)[x]; !ok {
+		(*mt)[x] = make(map[int]*Tower)
+	}
+	if _, ok := (*mt)[x][y]; !ok {
+		(*mt)[x][y] = NewTower()
+	}
+
+	return (*mt)[x][y]
+}
+
+func (mt *mapTowerGrid) findTower(x, y int) *Tower {
+	if m, ok := (*mt)[x]; ok {
+		return m[y]
+	}
+
+	return nil
+}
+
+func (mt *mapTowerGrid) release(x, y int) {
+	m, ok := (*mt)[x]
+	if !ok {
+		return
+	}
+
+	if tower, ok := m[y]; ok && tower.GetObjsLen() == 0 && tower.GetWatchersLen() == 0 {
+		delete(m, y)
+		if len(m) == 0 {
+			delete(*mt, x)
+		}
+	}
+}
+
+func (mt *mapTowerGrid) traversal(f func(x, y int, tower *Tower)) {
+	for x, m := range *mt {
+		for y, v := range m {
+			f(x, y, v)
+		}
+	}
+}
+
+func (mt *mapTowerGrid) len() int {
+	count := 0
+	for _, m := range *mt {
+		count += len(m)
+	}
+
+	return count
+}
@@ -0,0 +1,204 @@
+package toweraoi
+
+import (
+	"aoi"
+	"fmt"
+
+	log "github.com/cihub/seelog"
+)
+
+// Validate 检查内部状态的一致性, 返回发现的第一个错误, 用于测试和调试, 开销和对象数量成正比
+//
+//   - 每个对象在全局列表或者且只在_CacheObject.X/Y对应的灯塔中
+//   - watcher关注的灯塔正好是towerVisual范围内的灯塔, 并且关注了全局列表
+//   - _CacheInfo.count等于关注的灯塔, 全局列表和群组中包含该对象的数量
+//   - 群组成员和_CacheObject.groups对应, 群组中的watcher正好是成员中的watcher
+func (t *TowerAOI) Validate() error {
+	located := make(map[string]bool, len(t.objs))
+	subscribed := make(map[string]int)
+
+	var err error
+	t.towers.traversal(func(x, y int, tower *Tower) {
+		if err != nil {
+			return
+		}
+
+		for id := range tower.GetObjs() {
+			cacheObj, ok := t.objs[id]
+			if !ok {
+				err = fmt.Errorf("object %s in tower (%d, %d) not existed", id, x, y)
+				return
+			}
+			if located[id] || t.global.Existed(id) {
+				err = fmt.Errorf("object %s in more than one tower", id)
+				return
+			}
+			if cacheObj.X != x || cacheObj.Y != y {
+				err = fmt.Errorf("object %s in tower (%d, %d), cached (%d, %d)", id, x, y, cacheObj.X, cacheObj.Y)
+				return
+			}
+			located[id] = true
+		}
+
+		for id, w := range tower.GetWatchers() {
+			cacheObj, ok := t.objs[id]
+			if !ok || cacheObj.wrapWatcher == nil || cacheObj.wrapWatcher != w {
+				err = fmt.Errorf("watcher %s in tower (%d, %d) not existed", id, x, y)
+				return
+			}
+			if !inTowerRange(cacheObj.X, cacheObj.Y, cacheObj.towerVisual, x, y) {
+				err = fmt.Errorf("watcher %s subscribes tower (%d, %d) out of range", id, x, y)
+				return
+			}
+			subscribed[id]++
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for id := range t.global.GetObjs() {
+		if _, ok := t.objs[id]; !ok {
+			return fmt.Errorf("global object %s not existed", id)
+		}
+		located[id] = true
+	}
+	for id, w := range t.global.GetWatchers() {
+		if cacheObj, ok := t.objs[id]; !ok || cacheObj.wrapWatcher != w {
+			return fmt.Errorf("global watcher %s not existed", id)
+		}
+	}
+
+	for id, cacheObj := range t.objs {
+		if !located[id] {
+			return fmt.Errorf("object %s not in any tower", id)
+		}
+
+		if cacheObj.wrapWatcher == nil {
+			continue
+		}
+		if !t.global.ExistedWatcher(id) {
+			return fmt.Errorf("watcher %s not in global", id)
+		}
+		if expected := t.countTowerRange(cacheObj); subscribed[id] != expected {
+			return fmt.Errorf("watcher %s subscribes %d towers, expected %d", id, subscribed[id], expected)
+		}
+		if err := t.validateInfo(id, cacheObj); err != nil {
+			return err
+		}
+	}
+
+	if err := t.validateGroups(); err != nil {
+		return err
+	}
+
+	if t.batchDepth == 0 && len(t.dirtyWatchers) != 0 {
+		return fmt.Errorf("%d dirty watchers not flushed", len(t.dirtyWatchers))
+	}
+
+	return nil
+}
+
+// countTowerRange watcher应该关注的灯塔数量
+func (t *TowerAOI) countTowerRange(cacheObj *_CacheObject) int {
+	if cacheObj.towerVisual < 0 {
+		return 0
+	}
+
+	startX, endX, startY, endY := t.getTowerRange(cacheObj.X, cacheObj.Y, cacheObj.towerVisual)
+	return (endX - startX + 1) * (endY - startY + 1)
+}
+
+// validateInfo 按灯塔, 全局列表和群组重新计算watcher每个对象的计数
+func (t *TowerAOI) validateInfo(id string, cacheObj *_CacheObject) error {
+	expected := make(map[string]int)
+	count := func(objs map[string]aoi.IObject) {
+		for objID := range objs {
+			expected[objID]++
+		}
+	}
+
+	if cacheObj.towerVisual >= 0 {
+		startX, endX, startY, endY := t.getTowerRange(cacheObj.X, cacheObj.Y, cacheObj.towerVisual)
+		for i := startX; i <= endX; i++ {
+			for j := startY; j <= endY; j++ {
+				if tower := t.towers.findTower(i, j); tower != nil {
+					count(tower.GetObjs())
+				}
+			}
+		}
+	}
+	count(t.global.GetObjs())
+	for _, groupID := range cacheObj.groups {
+		if group, ok := t.groups[groupID]; ok {
+			count(group.GetObjs())
+		}
+	}
+
+	ww := cacheObj.wrapWatcher
+	for objID, info := range ww.info {
+		if info.count != expected[objID] {
+			return fmt.Errorf("watcher %s counts %s %d times, expected %d", id, objID, info.count, expected[objID])
+		}
+		if info.count <= 0 && t.batchDepth == 0 {
+			return fmt.Errorf("watcher %s keeps %s after flush", id, objID)
+		}
+	}
+	for objID := range expected {
+		if _, ok := ww.info[objID]; !ok {
+			return fmt.Errorf("watcher %s missing %s", id, objID)
+		}
+	}
+
+	return nil
+}
+
+func (t *TowerAOI) validateGroups() error {
+	for groupID, group := range t.groups {
+		for id := range group.GetObjs() {
+			cacheObj, ok := t.objs[id]
+			if !ok {
+				return fmt.Errorf("group %d member %s not existed", groupID, id)
+			}
+			if countGroup(cacheObj.groups, groupID) != 1 {
+				return fmt.Errorf("object %s not linked to group %d", id, groupID)
+			}
+			if cacheObj.wrapWatcher != nil && group.GetWatchers()[id] != cacheObj.wrapWatcher {
+				return fmt.Errorf("group %d missing watcher %s", groupID, id)
+			}
+		}
+		for id := range group.GetWatchers() {
+			if !group.Existed(id) {
+				return fmt.Errorf("group %d watcher %s not a member", groupID, id)
+			}
+		}
+	}
+
+	for id, cacheObj := range t.objs {
+		for _, groupID := range cacheObj.groups {
+			if group, ok := t.groups[groupID]; !ok || !group.Existed(id) {
+				return fmt.Errorf("object %s linked to group %d without membership", id, groupID)
+			}
+		}
+	}
+
+	return nil
+}
+
+func countGroup(groups []int, groupID int) int {
+	count := 0
+	for _, v := range groups {
+		if v == groupID {
+			count++
+		}
+	}
+
+	return count
+}
+
+// debugValidate 调试模式下每次操作之后检查, 只记录日志不中断
+func (t *TowerAOI) debugValidate() {
+	if err := t.Validate(); err != nil {
+		log.Error("AOI内部状态错误: ", err)
+	}
+}
@@ -2,6 +2,8 @@ package toweraoi
 
 import (
 	"aoi"
+	"sort"
+
 	log "github.com/cihub/seelog"
 )
 
@@ -10,12 +12,25 @@ type _WrapWatcher struct {
 
 	notify func(watcher *_WrapWatcher)
 	info   map[string]*_CacheInfo
+
+	// filter 灯塔粗筛之后的过滤, 为空时灯塔内的对象全部可见
+	filter func(watcher *_WrapWatcher, info *_CacheInfo) bool
+
+	// maxVisible 大于0时只保留得分最高的maxVisible个对象
+	maxVisible int
+	score      func(watcher *_WrapWatcher, info *_CacheInfo) float32
+
+	tick int // CollectUpdates的调用次数
 }
 
 type _CacheInfo struct {
 	obj     aoi.IObject
 	count   int
 	entered bool
+	visible bool // 限制数量时, 排名之前是否可见
+	score   float32
+
+	syncTick int // 上次同步时watcher的tick
 }
 
 func newWrapWatcher(w aoi.IWatcher, notify func(watcher *_WrapWatcher)) *_WrapWatcher {
@@ -76,28 +91,88 @@ func (wo *_WrapWatcher) OnBatchLeave(objs []aoi.IObject) {
 	wo.notify(wo)
 }
 
-func (wo *_WrapWatcher) Flush() {
+// Flush 通知进入和离开, 返回通知的数量
+func (wo *_WrapWatcher) Flush() (int, int) {
 	enterList := make([]aoi.IObject, 0, 1)
 	leaveList := make([]aoi.IObject, 0, 1)
 
+	var candidates []*_CacheInfo
 	for _, info := range wo.info {
-		if info.count > 0 && !info.entered {
-			//wo.IWatcher.OnObjectEnter(info.obj)
-			enterList = append(enterList, info.obj)
-			info.entered = true
-		} else if info.count <= 0 {
+		if info.count <= 0 {
 			if info.entered {
 				//wo.IWatcher.OnObjectLeave(info.obj)
 				leaveList = append(leaveList, info.obj)
 			}
 			delete(wo.info, info.obj.GetAOIID())
+			continue
+		}
+
+		visible := wo.filter == nil || wo.filter(wo, info)
+		if wo.maxVisible > 0 {
+			info.visible = visible
+			if visible {
+				candidates = append(candidates, info)
+			}
+			continue
+		}
+
+		if visible && !info.entered {
+			//wo.IWatcher.OnObjectEnter(info.obj)
+			enterList = append(enterList, info.obj)
+			info.entered = true
+			info.syncTick = wo.tick
+		} else if !visible && info.entered {
+			leaveList = append(leaveList, info.obj)
+			info.entered = false
 		}
 	}
 
+	if wo.maxVisible > 0 {
+		enterList, leaveList = wo.rank(candidates, enterList, leaveList)
+	}
+
 	if len(enterList) > 0 {
 		wo.IWatcher.OnBatchEnter(enterList)
 	}
 	if len(leaveList) > 0 {
 		wo.IWatcher.OnBatchLeave(leaveList)
 	}
+
+	return len(enterList), len(leaveList)
+}
+
+// rank 可见的对象超过maxVisible时按得分排名, 只有排名变化才进入或离开
+// 得分相同时已经进入的优先, 避免来回切换
+func (wo *_WrapWatcher) rank(candidates []*_CacheInfo, enterList, leaveList []aoi.IObject) ([]aoi.IObject, []aoi.IObject) {
+	if len(candidates) > wo.maxVisible {
+		for _, info := range candidates {
+			info.score = wo.score(wo, info)
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			a, b := candidates[i], candidates[j]
+			if a.score != b.score {
+				return a.score > b.score
+			}
+			if a.entered != b.entered {
+				return a.entered
+			}
+			return a.obj.GetAOIID() < b.obj.GetAOIID()
+		})
+		for _, info := range candidates[wo.maxVisible:] {
+			info.visible = false
+		}
+	}
+
+	for _, info := range wo.info {
+		if info.visible && !info.entered {
+			enterList = append(enterList, info.obj)
+			info.entered = true
+			info.syncTick = wo.tick
+		} else if !info.visible && info.entered {
+			leaveList = append(leaveList, info.obj)
+			info.entered = false
+		}
+	}
+
+	return enterList, leaveList
 }